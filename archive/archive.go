@@ -0,0 +1,261 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+// 弹幕存档是按房间和日期分开的 gzip 压缩 JSONL 文件, 每行一个 Record:
+//
+//	<dir>/<roomId>-<20060102>.jsonl.gz           斗鱼的房间
+//	<dir>/<scheme>-<roomId>-<20060102>.jsonl.gz  其它平台的房间
+//
+// 同一天重新打开文件时追加新的 gzip member, gzip.Reader 可以连续读出.
+
+const Ext = ".jsonl.gz"
+
+type Record struct {
+	Time time.Time `json:"time"`
+	Room int       `json:"room"`
+	// Addr 是房间地址, 以前的存档没有这个字段, 都是斗鱼的房间
+	Addr  string          `json:"addr,omitempty"`
+	Type  string          `json:"type"`
+	Event json.RawMessage `json:"event"`
+}
+
+func NewRecord(t time.Time, ev danmuku.Event) (*Record, error) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{t, ev.Room(), ev.Addr(), ev.EventType(), data}, nil
+}
+
+// RoomAddr 返回记录所属房间的地址
+func (r *Record) RoomAddr() string {
+	if r.Addr != "" {
+		return r.Addr
+	}
+	return danmuku.RoomAddr("", r.Room)
+}
+
+// Decode 把 Record 还原成事件, 没有注册的类型还原为 *danmuku.Unknown.
+func (r *Record) Decode() (danmuku.Event, error) {
+	ev := danmuku.NewEvent(r.Type)
+	if ev == nil {
+		ev = &danmuku.Unknown{}
+	}
+	if err := json.Unmarshal(r.Event, ev); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// FileName 返回 addr 房间某一天的存档文件名, addr 是 danmuku.RoomAddr
+// 返回的地址. 斗鱼的房间沿用以前只有房间号的文件名.
+func FileName(addr string, t time.Time) string {
+	return filePrefix(addr) + "-" + t.Format("20060102") + Ext
+}
+
+func filePrefix(addr string) string {
+	return strings.TrimPrefix(strings.Replace(addr, ":", "-", 1), danmuku.DefaultScheme+"-")
+}
+
+// Files 返回 dir 下某个房间的所有存档, 按日期排序. addr 为空时返回所有房间的,
+// 只写房间号时是斗鱼的房间.
+func Files(dir string, addr string) ([]string, error) {
+	pattern := "*" + Ext
+	if addr != "" {
+		scheme, roomId, err := danmuku.ParseRoomAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		pattern = filePrefix(danmuku.RoomAddr(scheme, roomId)) + "-*" + Ext
+	}
+	files, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+type dayFile struct {
+	day  string
+	file *os.File
+	gz   *gzip.Writer
+	buf  *bufio.Writer
+}
+
+func (f *dayFile) flush() error {
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	return f.gz.Flush()
+}
+
+func (f *dayFile) close() error {
+	if err := f.buf.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.gz.Close(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// Writer 把 Record 写到按房间和日期轮转的存档文件里, 不是并发安全的.
+type Writer struct {
+	dir   string
+	files map[string]*dayFile
+}
+
+func NewWriter(dir string) *Writer {
+	return &Writer{dir, make(map[string]*dayFile)}
+}
+
+func (w *Writer) Write(rec *Record) error {
+	day := rec.Time.Format("20060102")
+	addr := rec.RoomAddr()
+	f, ok := w.files[addr]
+	if ok && f.day != day {
+		delete(w.files, addr)
+		if err := f.close(); err != nil {
+			return err
+		}
+		ok = false
+	}
+	if !ok {
+		if err := os.MkdirAll(w.dir, 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(filepath.Join(w.dir, FileName(addr, rec.Time)),
+			os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		gz := gzip.NewWriter(file)
+		f = &dayFile{day, file, gz, bufio.NewWriter(gz)}
+		w.files[addr] = f
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := f.buf.Write(data); err != nil {
+		return err
+	}
+	return f.buf.WriteByte('\n')
+}
+
+// Flush 把缓冲写到磁盘, 已写出的部分即使进程被杀也能读出来.
+func (w *Writer) Flush() error {
+	for _, f := range w.files {
+		if err := f.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	var firstErr error
+	for room, f := range w.files {
+		if err := f.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(w.files, room)
+	}
+	return firstErr
+}
+
+type Reader struct {
+	gz  *gzip.Reader
+	buf *bufio.Reader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{gz, bufio.NewReaderSize(gz, 64*1024)}, nil
+}
+
+// Next 返回下一条记录, 读完时返回 io.EOF. 被截断的文件 (比如进程被杀时
+// 正在写的最后一行) 返回 io.ErrUnexpectedEOF. 中间某一行无法解析时返回
+// 解析错误, 之后还可以继续读后面的记录.
+func (r *Reader) Next() (*Record, error) {
+	for {
+		line, err := r.buf.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			// gzip 数据不完整时是 io.ErrUnexpectedEOF
+			return nil, err
+		}
+		// 没有换行结尾的最后一行可能没有写完
+		partial := err == io.EOF
+		if len(bytes.TrimSpace(line)) == 0 {
+			if partial {
+				return nil, io.EOF
+			}
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if partial {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return &rec, nil
+	}
+}
+
+// ReadFiles 读出多个存档文件的所有记录, 按时间排序. 文件末尾不完整的部分被忽略.
+func ReadFiles(paths ...string) ([]*Record, error) {
+	records := make([]*Record, 0)
+	for _, path := range paths {
+		if err := readFile(path, &records); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+func readFile(path string, records *[]*Record) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := NewReader(file)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := r.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		*records = append(*records, rec)
+	}
+}
@@ -0,0 +1,417 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/danmuku/stt"
+)
+
+func chat(roomId int, text string) *danmuku.ChatMessage {
+	return &danmuku.ChatMessage{
+		Header:   danmuku.Header{Type: "chatmsg", RoomId: roomId},
+		Uid:      1,
+		Nickname: "a",
+		Text:     text,
+	}
+}
+
+func mustRecord(t *testing.T, tm time.Time, ev danmuku.Event) *Record {
+	rec, err := NewRecord(tm, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestWriterRotation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	day1 := time.Date(2017, 3, 1, 23, 59, 0, 0, time.Local)
+	day2 := day1.Add(2 * time.Minute)
+
+	w := NewWriter(dir)
+	for _, rec := range []*Record{
+		mustRecord(t, day1, chat(1, "a")),
+		mustRecord(t, day1, chat(2, "b")),
+		mustRecord(t, day2, chat(1, "c")),
+	} {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一天再次打开时追加 gzip member
+	w = NewWriter(dir)
+	if err := w.Write(mustRecord(t, day2.Add(time.Second), chat(1, "d"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Files(dir, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || filepath.Base(files[0]) != "1-20170301.jsonl.gz" ||
+		filepath.Base(files[1]) != "1-20170302.jsonl.gz" {
+		t.Fatal("unexpected files", files)
+	}
+	if all, _ := Files(dir, ""); len(all) != 3 {
+		t.Error("expected 3 files, got", all)
+	}
+
+	records, err := ReadFiles(files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := ""
+	for _, rec := range records {
+		ev, err := rec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts += ev.(*danmuku.ChatMessage).Text
+	}
+	if texts != "acd" {
+		t.Error("unexpected records", texts)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tm := time.Date(2017, 3, 1, 12, 0, 0, 0, time.Local)
+	unknown := &danmuku.Unknown{
+		Header: danmuku.Header{Type: "foo", RoomId: 3},
+		Fields: stt.NewMessage("foo", "rid", "3", "x", "y"),
+	}
+	for _, ev := range []danmuku.Event{chat(3, "hi"), unknown} {
+		ev2, err := mustRecord(t, tm, ev).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev2.EventType() != ev.EventType() || ev2.Room() != 3 {
+			t.Errorf("%#v != %#v", ev2, ev)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	tm := time.Date(2017, 3, 1, 12, 0, 0, 0, time.Local)
+	w := NewWriter(dir)
+	for i := 0; i < 100; i++ {
+		if err := w.Write(mustRecord(t, tm, chat(1, "hello"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, FileName("douyu:1", tm))
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data[:len(data)-20], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || len(records) >= 100 {
+		t.Error("unexpected record count", len(records))
+	}
+}
+
+func TestMalformedLine(t *testing.T) {
+	tm := time.Date(2017, 3, 1, 12, 0, 0, 0, time.Local)
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, text := range []string{"a", "", "b"} {
+		line := []byte("{not json")
+		if text != "" {
+			line, _ = json.Marshal(mustRecord(t, tm, chat(1, text)))
+		}
+		gz.Write(append(line, '\n'))
+	}
+	gz.Close()
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := r.Next(); err != nil || rec.Room != 1 {
+		t.Fatalf("first record = %v, %v", rec, err)
+	}
+	if _, err := r.Next(); err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+		t.Errorf("malformed line: err = %v", err)
+	}
+	// 坏掉的一行之后的记录不能被跳过
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev, _ := rec.Decode(); ev.(*danmuku.ChatMessage).Text != "b" {
+		t.Errorf("unexpected record %#v", ev)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	b := danmuku.NewBroadcaster()
+	sub := b.Subscribe(nil, 16, danmuku.Block)
+	r := NewRecorder(dir)
+	done := make(chan error)
+	go func() {
+		done <- r.Record(context.Background(), sub)
+	}()
+
+	ctx := context.Background()
+	b.Publish(ctx, chat(7, "a"))
+	b.Publish(ctx, &danmuku.StateChange{RoomId: 7, State: danmuku.StateConnected})
+	b.Publish(ctx, chat(7, "b"))
+	b.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := Files(dir, "douyu:7")
+	records, err := ReadFiles(files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Type != "chatmsg" || records[1].Room != 7 {
+		t.Error("unexpected records", records)
+	}
+}
+
+func TestRecorderDrain(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	b := danmuku.NewBroadcaster()
+	sub := b.Subscribe(nil, 16, danmuku.Block)
+	received := time.Date(2017, 3, 1, 12, 0, 0, 0, time.Local)
+	for i, text := range []string{"a", "b", "c"} {
+		ev := chat(7, text)
+		danmuku.Stamp(ev, received.Add(time.Duration(i)*time.Second))
+		b.Publish(context.Background(), ev)
+	}
+
+	// ctx 结束时缓冲里的事件也要写入, 时间是收到的时间
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewRecorder(dir).Record(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := Files(dir, "douyu:7")
+	records, err := ReadFiles(files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatal("unexpected records", records)
+	}
+	for i, rec := range records {
+		if want := received.Add(time.Duration(i) * time.Second); !rec.Time.Equal(want) {
+			t.Errorf("record %d time = %s, want %s", i, rec.Time, want)
+		}
+	}
+}
+
+var _ danmuku.Source = (*Replay)(nil)
+
+func TestRoomAddr(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	day := time.Date(2017, 3, 1, 12, 0, 0, 0, time.Local)
+	bilibili := chat(1, "b")
+	bilibili.Scheme = "bilibili"
+	// 以前的存档没有 addr, 按斗鱼的房间处理
+	old := mustRecord(t, day, chat(1, "o"))
+	old.Addr = ""
+
+	w := NewWriter(dir)
+	for _, rec := range []*Record{old, mustRecord(t, day, chat(1, "a")), mustRecord(t, day, bilibili)} {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	douyu, _ := Files(dir, "douyu:1")
+	if len(douyu) != 1 || filepath.Base(douyu[0]) != "1-20170301.jsonl.gz" {
+		t.Fatal("unexpected douyu files", douyu)
+	}
+	files, _ := Files(dir, "bilibili:1")
+	if len(files) != 1 || filepath.Base(files[0]) != "bilibili-1-20170301.jsonl.gz" {
+		t.Fatal("unexpected bilibili files", files)
+	}
+	if _, err := Files(dir, "huya:kpl"); err != danmuku.ErrBadAddr {
+		t.Error("expected ErrBadAddr, got", err)
+	}
+
+	records, err := ReadFiles(append(douyu, files...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReplay("bilibili:1", records)
+	sub := r.Subscribe(nil, 16, danmuku.Block)
+	r.SetSpeed(MaxSpeed)
+	r.Start(context.Background())
+	if s := texts(sub); s != "b" {
+		t.Error("unexpected bilibili replay", s)
+	}
+	r = NewReplay("1", records)
+	sub = r.Subscribe(nil, 16, danmuku.Block)
+	r.Start(context.Background())
+	if s := texts(sub); s != "oa" {
+		t.Error("unexpected douyu replay", s)
+	}
+}
+
+func replayRecords(t *testing.T) []*Record {
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.Local)
+	records := make([]*Record, 0)
+	for i, text := range []string{"a", "b", "c", "d"} {
+		records = append(records, mustRecord(t, start.Add(time.Duration(i)*time.Second), chat(1, text)))
+	}
+	return append(records, mustRecord(t, start, chat(2, "x")))
+}
+
+func texts(sub *danmuku.Subscription) string {
+	s := ""
+	for ev := range sub.Events() {
+		s += ev.(*danmuku.ChatMessage).Text
+	}
+	return s
+}
+
+func TestReplay(t *testing.T) {
+	r := NewReplay("douyu:1", replayRecords(t))
+	if r.Duration() != 3*time.Second {
+		t.Error("unexpected duration", r.Duration())
+	}
+	if err := r.SetSpeed(20); err != ErrSpeed {
+		t.Error("expected ErrSpeed, got", err)
+	}
+	if err := r.SetSpeed(10); err != nil {
+		t.Fatal(err)
+	}
+	sub := r.Subscribe(nil, 16, danmuku.Block)
+
+	begin := time.Now()
+	r.Start(context.Background())
+	if s := texts(sub); s != "abcd" {
+		t.Error("unexpected events", s)
+	}
+	if elapsed := time.Since(begin); elapsed < 250*time.Millisecond || elapsed > time.Second {
+		t.Error("unexpected elapsed time", elapsed)
+	}
+	if r.Err() != io.EOF {
+		t.Error("expected io.EOF, got", r.Err())
+	}
+	if err := r.Start(context.Background()); err != danmuku.ErrClosed {
+		t.Error("expected ErrClosed, got", err)
+	}
+}
+
+func TestReplaySeekPause(t *testing.T) {
+	r := NewReplay("douyu:1", replayRecords(t))
+	r.SetSpeed(10)
+	r.Seek(1500 * time.Millisecond)
+	sub := r.Subscribe(nil, 16, danmuku.Block)
+	r.Start(context.Background())
+
+	ev := <-sub.Events()
+	if ev.(*danmuku.ChatMessage).Text != "c" {
+		t.Fatal("expected c, got", ev)
+	}
+	r.Pause()
+	pos := r.Position()
+	select {
+	case ev := <-sub.Events():
+		t.Fatal("event while paused", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if r.Position() != pos {
+		t.Error("position moved while paused")
+	}
+
+	r.Seek(0)
+	r.Resume()
+	if s := texts(sub); s != "abcd" {
+		t.Error("unexpected events after seek", s)
+	}
+}
+
+func TestReplayResumeWhilePlaying(t *testing.T) {
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.Local)
+	r := NewReplay("douyu:1", []*Record{
+		mustRecord(t, start, chat(1, "a")),
+		mustRecord(t, start.Add(time.Hour), chat(1, "b")),
+	})
+	r.SetSpeed(10)
+	r.Start(context.Background())
+	defer r.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	// 没有暂停时 Resume 不能让播放位置退回去
+	pos := r.Position()
+	r.Resume()
+	if after := r.Position(); after < pos {
+		t.Errorf("position after Resume = %s, before %s", after, pos)
+	}
+	if r.Paused() {
+		t.Error("replay paused after Resume")
+	}
+}
+
+func TestReplayStop(t *testing.T) {
+	r := NewReplay("", replayRecords(t))
+	sub := r.Subscribe(nil, 16, danmuku.Block)
+	r.Start(context.Background())
+	if ev := <-sub.Events(); ev == nil {
+		t.Fatal("expected event")
+	}
+	r.Stop()
+	r.Stop()
+	if r.Err() != context.Canceled {
+		t.Error("expected context.Canceled, got", r.Err())
+	}
+}
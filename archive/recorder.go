@@ -0,0 +1,91 @@
+package archive
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+const DefaultFlushInterval = 5 * time.Second
+
+// Recorder 把订阅到的事件写进存档. 订阅在断线重连时保持不变, 所以录制
+// 不会因为重连中断; 连接状态事件不会被写入.
+type Recorder struct {
+	FlushInterval time.Duration
+
+	writer *Writer
+	now    func() time.Time
+}
+
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{
+		FlushInterval: DefaultFlushInterval,
+
+		writer: NewWriter(dir),
+		now:    time.Now,
+	}
+}
+
+// Record 一直写到订阅关闭或者 ctx 结束, 返回前写出缓冲并关闭所有文件.
+// ctx 结束时订阅缓冲里已经收到的事件也会被写入.
+func (r *Recorder) Record(ctx context.Context, sub *danmuku.Subscription) error {
+	ticker := time.NewTicker(r.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return r.writer.Close()
+			}
+			if err := r.write(ev); err != nil {
+				r.writer.Close()
+				return err
+			}
+		case <-ticker.C:
+			if err := r.writer.Flush(); err != nil {
+				r.writer.Close()
+				return err
+			}
+		case <-ctx.Done():
+			return r.drain(sub)
+		}
+	}
+}
+
+// drain 不阻塞地写出订阅缓冲里剩下的事件, 然后关闭所有文件
+func (r *Recorder) drain(sub *danmuku.Subscription) error {
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return r.writer.Close()
+			}
+			if err := r.write(ev); err != nil {
+				r.writer.Close()
+				return err
+			}
+		default:
+			return r.writer.Close()
+		}
+	}
+}
+
+// write 用收到事件的时间写入 ev, 不知道时用读出的时间
+func (r *Recorder) write(ev danmuku.Event) error {
+	if _, ok := ev.(*danmuku.StateChange); ok {
+		return nil
+	}
+	t := danmuku.ReceivedAt(ev)
+	if t.IsZero() {
+		t = r.now()
+	}
+	rec, err := NewRecord(t, ev)
+	if err != nil {
+		log.Println("archive: encode:", err)
+		return nil
+	}
+	return r.writer.Write(rec)
+}
@@ -0,0 +1,296 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+const (
+	MinSpeed = 0.5
+	MaxSpeed = 10
+)
+
+var ErrSpeed = errors.New("archive: speed out of range")
+
+// Replay 按录制时的相对时间重新发出存档里的事件, 实现了 danmuku.Source,
+// 可以代替直播的 DanmukuRoom. 全部播完后房间结束, Err 返回 io.EOF.
+type Replay struct {
+	roomId      int
+	addr        string
+	records     []*Record
+	broadcaster *danmuku.Broadcaster
+	done        chan struct{}
+	wake        chan struct{}
+
+	mutex   sync.Mutex
+	started bool
+	cancel  context.CancelFunc
+	err     error
+	next    int           // 下一条要发出的记录
+	pos     time.Duration // base 时刻的播放位置
+	base    time.Time
+	speed   float64
+	paused  bool
+}
+
+// NewReplay 回放 records 里属于 addr 房间的记录, addr 为空时回放所有记录.
+// records 需要按时间排序.
+func NewReplay(addr string, records []*Record) *Replay {
+	roomId := 0
+	if addr != "" {
+		scheme, id, err := danmuku.ParseRoomAddr(addr)
+		if err == nil {
+			roomId, addr = id, danmuku.RoomAddr(scheme, id)
+		}
+		filtered := make([]*Record, 0, len(records))
+		for _, rec := range records {
+			if rec.RoomAddr() == addr {
+				filtered = append(filtered, rec)
+			}
+		}
+		records = filtered
+	}
+	return &Replay{
+		roomId:      roomId,
+		addr:        addr,
+		records:     records,
+		broadcaster: danmuku.NewBroadcaster(),
+		done:        make(chan struct{}),
+		wake:        make(chan struct{}, 1),
+		speed:       1,
+	}
+}
+
+// OpenReplay 回放 dir 下 addr 房间的所有存档
+func OpenReplay(dir string, addr string) (*Replay, error) {
+	files, err := Files(dir, addr)
+	if err != nil {
+		return nil, err
+	}
+	records, err := ReadFiles(files...)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplay(addr, records), nil
+}
+
+func (r *Replay) RoomId() int {
+	return r.roomId
+}
+
+// Start 从当前位置开始回放, 重复调用没有效果, 结束后调用返回 danmuku.ErrClosed.
+func (r *Replay) Start(ctx context.Context) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.started {
+		select {
+		case <-r.done:
+			return danmuku.ErrClosed
+		default:
+			return nil
+		}
+	}
+	r.started = true
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.base = time.Now()
+
+	go r.playRoutine(ctx)
+
+	return nil
+}
+
+func (r *Replay) Stop() {
+	r.mutex.Lock()
+	if !r.started {
+		r.started = true
+		r.mutex.Unlock()
+		r.finish(context.Canceled)
+		return
+	}
+	cancel := r.cancel
+	r.mutex.Unlock()
+
+	cancel()
+	<-r.done
+}
+
+func (r *Replay) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err 在回放结束前返回 nil, 播完后返回 io.EOF, 被停止时返回 ctx 的错误.
+func (r *Replay) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+func (r *Replay) Subscribe(filter danmuku.Filter, bufferSize int, policy danmuku.OverflowPolicy) *danmuku.Subscription {
+	return r.broadcaster.Subscribe(filter, bufferSize, policy)
+}
+
+func (r *Replay) Unsubscribe(s *danmuku.Subscription) {
+	r.broadcaster.Unsubscribe(s)
+}
+
+// Duration 返回第一条到最后一条记录的时间
+func (r *Replay) Duration() time.Duration {
+	if len(r.records) == 0 {
+		return 0
+	}
+	return r.offset(len(r.records) - 1)
+}
+
+// Position 返回当前的播放位置, 从第一条记录开始计算.
+func (r *Replay) Position() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.position(time.Now())
+}
+
+func (r *Replay) Speed() float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.speed
+}
+
+// SetSpeed 设置播放速度, 范围是 MinSpeed 到 MaxSpeed.
+func (r *Replay) SetSpeed(speed float64) error {
+	if speed < MinSpeed || speed > MaxSpeed {
+		return ErrSpeed
+	}
+	r.update(func(now time.Time) {
+		r.pos = r.position(now)
+		r.base = now
+		r.speed = speed
+	})
+	return nil
+}
+
+func (r *Replay) Pause() {
+	r.update(func(now time.Time) {
+		r.pos = r.position(now)
+		r.base = now
+		r.paused = true
+	})
+}
+
+// Resume 从暂停的位置继续播放, 没有暂停时没有效果.
+func (r *Replay) Resume() {
+	r.update(func(now time.Time) {
+		if !r.paused {
+			return
+		}
+		r.base = now
+		r.paused = false
+	})
+}
+
+func (r *Replay) Paused() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.paused
+}
+
+// Seek 跳到 pos 处, 之前的记录不再发出, 向前跳会重新发出跳过的记录.
+func (r *Replay) Seek(pos time.Duration) {
+	if pos < 0 {
+		pos = 0
+	}
+	r.update(func(now time.Time) {
+		r.pos = pos
+		r.base = now
+		r.next = sort.Search(len(r.records), func(i int) bool {
+			return r.offset(i) >= pos
+		})
+	})
+}
+
+func (r *Replay) update(f func(now time.Time)) {
+	r.mutex.Lock()
+	f(time.Now())
+	r.mutex.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Replay) offset(i int) time.Duration {
+	return r.records[i].Time.Sub(r.records[0].Time)
+}
+
+func (r *Replay) position(now time.Time) time.Duration {
+	if r.paused || !r.started || r.base.IsZero() {
+		return r.pos
+	}
+	return r.pos + time.Duration(float64(now.Sub(r.base))*r.speed)
+}
+
+func (r *Replay) finish(err error) {
+	r.mutex.Lock()
+	r.err = err
+	r.mutex.Unlock()
+	r.broadcaster.Close()
+	close(r.done)
+}
+
+func (r *Replay) playRoutine(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		r.mutex.Lock()
+		if r.next >= len(r.records) {
+			r.mutex.Unlock()
+			r.finish(io.EOF)
+			return
+		}
+		var rec *Record
+		wait := time.Duration(-1)
+		if !r.paused {
+			wait = time.Duration(float64(r.offset(r.next)-r.position(time.Now())) / r.speed)
+			if wait <= 0 {
+				rec = r.records[r.next]
+				r.next++
+			}
+		}
+		r.mutex.Unlock()
+
+		if rec != nil {
+			ev, err := rec.Decode()
+			if err == nil {
+				r.broadcaster.Publish(ctx, ev)
+			}
+			if ctx.Err() != nil {
+				r.finish(ctx.Err())
+				return
+			}
+			continue
+		}
+
+		var timeout <-chan time.Time
+		if wait > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-timeout:
+		case <-r.wake:
+		case <-ctx.Done():
+			r.finish(ctx.Err())
+			return
+		}
+	}
+}
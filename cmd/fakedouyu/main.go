@@ -0,0 +1,53 @@
+// fakedouyu 启动一个假的弹幕服务器播放脚本, 用于离线开发, 用法:
+//
+//	go run ./cmd/fakedouyu [-addr 127.0.0.1:8601] [-legacy] script
+//	main2 -d -server 127.0.0.1:8601
+//
+// 它依赖测试用的 douyutest, 所以不放在 main2 里.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/zwh8800/Love66/danmuku/douyutest"
+	"github.com/zwh8800/Love66/danmuku/frame"
+)
+
+func main() {
+	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	addr := flag.String("addr", "127.0.0.1:8601", "listen address")
+	legacy := flag.Bool("legacy", false, "use legacy frame layout")
+	flag.Parse()
+
+	script := make([]douyutest.Step, 0)
+	if flag.NArg() > 0 {
+		file, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		script, err = douyutest.ReadScript(file)
+		file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	layout := frame.Open
+	if *legacy {
+		layout = frame.Legacy
+	}
+	server, err := douyutest.Listen(*addr, layout, script)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("fake danmu server listening on %s, %d messages", server.Addr, len(script))
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	server.Close()
+}
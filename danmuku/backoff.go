@@ -0,0 +1,38 @@
+package danmuku
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff 计算第 n 次重连前的等待时间: Min * Factor^(n-1), 不超过 Max,
+// 再随机减去最多 Jitter 比例, 避免大量房间同时重连.
+type Backoff struct {
+	Min        time.Duration
+	Max        time.Duration
+	Factor     float64
+	Jitter     float64
+	MaxRetries int // 0 表示无限重试
+}
+
+var DefaultBackoff = Backoff{
+	Min:    time.Second,
+	Max:    time.Minute,
+	Factor: 2,
+	Jitter: 0.2,
+}
+
+func (b Backoff) Duration(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := float64(b.Min) * math.Pow(b.Factor, float64(attempt-1))
+	if d > float64(b.Max) || math.IsInf(d, 0) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d -= d * b.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
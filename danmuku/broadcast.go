@@ -0,0 +1,159 @@
+package danmuku
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy 决定订阅者的缓冲区满了以后怎么处理新事件
+type OverflowPolicy int
+
+const (
+	// Block 等待订阅者读取, 会拖慢所有订阅者
+	Block OverflowPolicy = iota
+	// DropOldest 丢掉缓冲区里最旧的事件
+	DropOldest
+	// DropNewest 丢掉新来的事件
+	DropNewest
+)
+
+// Filter 返回 false 的事件不会发给订阅者, nil 表示接收所有事件
+type Filter func(ev Event) bool
+
+type Subscription struct {
+	filter  Filter
+	policy  OverflowPolicy
+	events  chan Event
+	done    chan struct{}
+	dropped uint64
+
+	mutex     sync.Mutex
+	closed    bool
+	closeOnce sync.Once
+}
+
+// Events 返回订阅的事件流, 取消订阅或事件源结束后会被关闭.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped 返回因为缓冲区满而丢弃的事件数
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mutex.Lock()
+		s.closed = true
+		close(s.events)
+		s.mutex.Unlock()
+	})
+}
+
+func (s *Subscription) send(ctx context.Context, ev Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	switch s.policy {
+	case Block:
+		select {
+		case s.events <- ev:
+		case <-s.done:
+		case <-ctx.Done():
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.events <- ev:
+				return
+			default:
+			}
+			select {
+			case <-s.events:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	case DropNewest:
+		select {
+		case s.events <- ev:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Broadcaster 把事件分发给多个相互独立的订阅者
+type Broadcaster struct {
+	mutex  sync.RWMutex
+	subs   map[*Subscription]bool
+	closed bool
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subs: make(map[*Subscription]bool)}
+}
+
+// Subscribe 新建一个订阅. 丢弃策略下 bufferSize 至少为 1.
+func (b *Broadcaster) Subscribe(filter Filter, bufferSize int, policy OverflowPolicy) *Subscription {
+	if policy != Block && bufferSize < 1 {
+		bufferSize = 1
+	}
+	s := &Subscription{
+		filter: filter,
+		policy: policy,
+		events: make(chan Event, bufferSize),
+		done:   make(chan struct{}),
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		s.close()
+		return s
+	}
+	b.subs[s] = true
+	return s
+}
+
+func (b *Broadcaster) Unsubscribe(s *Subscription) {
+	b.mutex.Lock()
+	delete(b.subs, s)
+	b.mutex.Unlock()
+	s.close()
+}
+
+// Publish 把 ev 发给所有订阅者, Block 策略的订阅者会阻塞到读取或 ctx 结束.
+func (b *Broadcaster) Publish(ctx context.Context, ev Event) {
+	b.mutex.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mutex.RUnlock()
+
+	for _, s := range subs {
+		if s.filter != nil && !s.filter(ev) {
+			continue
+		}
+		s.send(ctx, ev)
+	}
+}
+
+// Close 关闭所有订阅, 之后的 Subscribe 返回已关闭的订阅.
+func (b *Broadcaster) Close() {
+	b.mutex.Lock()
+	subs := b.subs
+	b.subs = make(map[*Subscription]bool)
+	b.closed = true
+	b.mutex.Unlock()
+
+	for s := range subs {
+		s.close()
+	}
+}
@@ -0,0 +1,80 @@
+package danmuku
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+)
+
+// ChatConn 是一条已经登录的弹幕连接, Read 和 Heartbeat 会在不同的
+// goroutine 里同时调用.
+type ChatConn interface {
+	// Read 读取下一条消息里的事件, 可能为空
+	Read() ([]Event, error)
+	Heartbeat() error
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// ChatDialer 连接弹幕服务器并完成登录, 应该在 ctx 结束时返回.
+type ChatDialer func(ctx context.Context, roomId int) (ChatConn, error)
+
+// NewChatRoom 返回 scheme 平台 roomId 房间的弹幕, 给斗鱼以外的平台使用.
+// 重连和生命周期和斗鱼的房间相同, heartbeatInterval 是心跳间隔, 连接状态
+// 事件的 Addr 是这个房间的地址.
+func NewChatRoom(scheme string, roomId int, dial ChatDialer, heartbeatInterval time.Duration) *DanmukuRoom {
+	r := newDanmukuRoom(scheme, roomId, dial)
+	r.KeepAliveInterval = heartbeatInterval
+	return r
+}
+
+// transportDialer 把 Transport 的连接包装成 ChatConn
+func transportDialer(transport Transport) ChatDialer {
+	return func(ctx context.Context, roomId int) (ChatConn, error) {
+		conn, err := transport.Dial(ctx, roomId)
+		if err != nil {
+			return nil, err
+		}
+		return &douyuConn{conn, roomId}, nil
+	}
+}
+
+type douyuConn struct {
+	*Conn
+	roomId int
+}
+
+func (c *douyuConn) Read() ([]Event, error) {
+	msg, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	ev, err := DecodeEvent(msg)
+	if err != nil {
+		log.Println("danmuku: decode:", err)
+		return nil, nil
+	}
+	tagRoom(ev, c.roomId)
+	return []Event{ev}, nil
+}
+
+func (c *douyuConn) Heartbeat() error {
+	return c.KeepAlive()
+}
+
+// CloseOnDone 在 ctx 结束时关闭 c, 用来打断握手阶段阻塞的读写.
+// 返回的函数用于解除监听.
+func CloseOnDone(ctx context.Context, c io.Closer) func() {
+	done := make(chan bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
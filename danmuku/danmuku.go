@@ -1,305 +1,257 @@
 package danmuku
 
 import (
-	"bytes"
-	"crypto/md5"
-	"encoding/binary"
-	"encoding/hex"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
+	"errors"
 	"log"
-	"net"
-	"net/http"
-	"net/url"
-	"regexp"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
-
-	"github.com/satori/go.uuid"
 )
 
 const (
-	typeSend int32 = 0x000002b1
-	typeRecv int32 = 0x000002b2
+	DefaultKeepAliveInterval = 40 * time.Second
 )
 
-const danmukuServer = "danmu.douyutv.com:8601"
-
-type Danmuku struct {
-	User    string
-	Content string
-}
+var (
+	ErrClosed    = errors.New("danmuku: room already closed")
+	errKeepAlive = errors.New("danmuku: keepalive timeout")
+)
 
+// DanmukuRoom 是一个房间的弹幕, 斗鱼和其它平台共用同一套重连和订阅.
+// DanmukuRoom 只能启动一次. ctx 结束, 调用 Stop 或重连次数用完后房间结束:
+// 所有 goroutine 退出, 所有订阅被关闭, Done 返回的 channel 被关闭,
+// Err 返回结束的原因.
 type DanmukuRoom struct {
-	roomId         int
-	conn           net.Conn
-	gidConn        net.Conn
-	danmukuChannel chan Danmuku
-	stopChannel    chan bool
+	// Backoff 控制断线重连的等待时间
+	Backoff Backoff
+	// KeepAliveInterval 是心跳间隔, 超过两个间隔没有收到任何消息就认为连接已断开
+	KeepAliveInterval time.Duration
+
+	scheme      string
+	roomId      int
+	dial        ChatDialer
+	broadcaster *Broadcaster
+	done        chan struct{}
+
+	mutex   sync.Mutex
+	started bool
+	cancel  context.CancelFunc
+	state   State
+	err     error
 }
 
 func NewDanmukuRoom(roomId int) *DanmukuRoom {
-	return &DanmukuRoom{
-		roomId,
-		nil,
-		nil,
-		nil,
-		nil,
-	}
+	return NewDanmukuRoomWithTransport(roomId, DefaultTransport)
 }
 
-func (r *DanmukuRoom) Start() error {
-	r.danmukuChannel = make(chan Danmuku)
-	r.stopChannel = make(chan bool)
-	roomHtml, err := r.getHtml()
-	if err != nil {
-		return err
-	}
-	sc, err := parseServerConfig(roomHtml)
-	if err != nil {
-		return err
-	}
-	gidConn, err := net.Dial("tcp", sc[0].IP+":"+sc[0].Port)
-	if err != nil {
-		return err
-	}
-	r.gidConn = gidConn
-	defer r.gidConn.Close()
-	gid, err := r.getGid()
-	if err != nil {
-		return err
-	}
-
-	conn, err := net.Dial("tcp", danmukuServer)
-	if err != nil {
-		return err
-	}
-	r.conn = conn
-
-	loginReq := formatMessage(map[string]string{
-		"type":     "loginreq",
-		"username": "auto_KRLJbE8mZM",
-		"password": "1234567890123456",
-		"roomid":   strconv.Itoa(r.roomId),
-	})
+func NewDanmukuRoomWithTransport(roomId int, transport Transport) *DanmukuRoom {
+	return newDanmukuRoom("", roomId, transportDialer(transport))
+}
 
-	if err := writeMessage(r.conn, loginReq); err != nil {
-		return err
+// newDanmukuRoom 新建一个房间, scheme 为空时是斗鱼.
+func newDanmukuRoom(scheme string, roomId int, dial ChatDialer) *DanmukuRoom {
+	return &DanmukuRoom{
+		Backoff:           DefaultBackoff,
+		KeepAliveInterval: DefaultKeepAliveInterval,
+
+		scheme:      scheme,
+		roomId:      roomId,
+		dial:        dial,
+		broadcaster: NewBroadcaster(),
+		done:        make(chan struct{}),
 	}
+}
 
-	joinGroup := formatMessage(map[string]string{
-		"type": "joingroup",
-		"rid":  strconv.Itoa(r.roomId),
-		"gid":  strconv.Itoa(gid),
-	})
-	if err := writeMessage(r.conn, joinGroup); err != nil {
-		return err
+// Start 在后台连接弹幕服务器, 断线后按 Backoff 重连并重新登录入组. 连接
+// 保持一个 KeepAliveInterval 以上才算恢复, 重连次数从头计算.
+// 连接状态通过 StateChange 事件报告. 重复调用 Start 没有效果, 房间结束后
+// 调用返回 ErrClosed.
+func (r *DanmukuRoom) Start(ctx context.Context) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.started {
+		select {
+		case <-r.done:
+			return ErrClosed
+		default:
+			return nil
+		}
 	}
+	r.started = true
+	ctx, r.cancel = context.WithCancel(ctx)
 
-	go r.readRoutine()
-	go r.keepAliveRoutine()
+	go r.superviseRoutine(ctx)
 
 	return nil
 }
 
+// Stop 结束房间并等待所有 goroutine 退出, 可以重复调用.
 func (r *DanmukuRoom) Stop() {
-	close(r.stopChannel)
-	r.conn.Close()
-}
+	r.mutex.Lock()
+	if !r.started {
+		r.started = true
+		r.mutex.Unlock()
+		r.finish(context.Canceled)
+		return
+	}
+	cancel := r.cancel
+	r.mutex.Unlock()
 
-func (r *DanmukuRoom) PeekDanmuku() *Danmuku {
-	danmuku := <-r.danmukuChannel
-	return &danmuku
+	if cancel != nil {
+		cancel()
+	}
+	<-r.done
 }
 
-func (r *DanmukuRoom) GetDanmukuChannel() chan Danmuku {
-	return r.danmukuChannel
+func (r *DanmukuRoom) Done() <-chan struct{} {
+	return r.done
 }
 
-func formatMessage(msg map[string]string) string {
-	message := make([]string, 0)
-	for k, v := range msg {
-		message = append(message, k+"@="+v+"/")
-	}
-	return strings.Join(message, "")
+// Err 在房间结束前返回 nil, 结束后返回 context.Canceled,
+// context.DeadlineExceeded 或者放弃重连前的最后一个错误.
+func (r *DanmukuRoom) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
 }
 
-func parseMessage(message string) map[string]string {
-	msg := make(map[string]string)
-	regex := regexp.MustCompile(`(.*?)@=(.*?)/`)
-	submatchs := regex.FindAllStringSubmatch(message, -1)
-
-	for _, submatch := range submatchs {
-		msg[submatch[1]] = submatch[2]
-	}
-	return msg
+func (r *DanmukuRoom) RoomId() int {
+	return r.roomId
 }
 
-type serverConfig []struct {
-	IP   string `json:"ip"`
-	Port string `json:"port"`
+// Subscribe 返回一个独立的事件流, 多个订阅者互不影响.
+// 连接状态的变化也以 StateChange 事件发出.
+func (r *DanmukuRoom) Subscribe(filter Filter, bufferSize int, policy OverflowPolicy) *Subscription {
+	return r.broadcaster.Subscribe(filter, bufferSize, policy)
 }
 
-func parseServerConfig(html string) (serverConfig, error) {
-	regex := regexp.MustCompile(`"server_config":"(.*?)"`)
-	submatch := regex.FindStringSubmatch(html)
+func (r *DanmukuRoom) Unsubscribe(s *Subscription) {
+	r.broadcaster.Unsubscribe(s)
+}
 
-	jsonData, err := url.QueryUnescape(submatch[1])
-	if err != nil {
-		return nil, err
+func (r *DanmukuRoom) addr() string {
+	scheme := r.scheme
+	if scheme == "" {
+		scheme = DefaultScheme
 	}
+	return RoomAddr(scheme, r.roomId)
+}
 
-	var sc serverConfig
-	if err := json.Unmarshal([]byte(jsonData), &sc); err != nil {
-		return nil, err
-	}
-	return sc, nil
+func (r *DanmukuRoom) State() State {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.state
 }
 
-func (r *DanmukuRoom) getGid() (int, error) {
-	devId := strings.ToUpper(strings.Replace(uuid.NewV4().String(), "-", "", -1))
-	rt := strconv.Itoa(int(time.Now().Unix()))
-	magic := "7oE9nPEG9xXV69phU31FYCLUagKeYtsF"
-	sumArr := md5.Sum([]byte(rt + magic + devId))
-	sum := sumArr[:]
-	vk := hex.EncodeToString(sum)
+func (r *DanmukuRoom) finish(err error) {
+	r.mutex.Lock()
+	r.err = err
+	r.mutex.Unlock()
+	r.broadcaster.Close()
+	close(r.done)
+}
 
-	loginReq := formatMessage(map[string]string{
-		"type":     "loginreq",
-		"username": "",
-		"password": "",
-		"roomid":   strconv.Itoa(r.roomId),
-		"ct":       "0",
-		"devid":    devId,
-		"rt":       rt,
-		"vk":       vk,
-		"ver":      "20150929",
-	})
+func (r *DanmukuRoom) emit(ctx context.Context, ev Event) bool {
+	r.broadcaster.Publish(ctx, ev)
+	return ctx.Err() == nil
+}
 
-	if err := writeMessage(r.gidConn, loginReq); err != nil {
-		return 0, err
-	}
+func (r *DanmukuRoom) setState(ctx context.Context, state State, attempt int, err error) {
+	r.mutex.Lock()
+	r.state = state
+	r.mutex.Unlock()
+	r.emit(ctx, &StateChange{RoomId: r.roomId, Scheme: r.scheme, State: state, Attempt: attempt, Err: err})
+}
 
+func (r *DanmukuRoom) superviseRoutine(ctx context.Context) {
+	attempt := 0
 	for {
-		message, err := readMessage(r.gidConn)
-		if err != nil {
-			return 0, err
-		}
-		msg := parseMessage(message)
-		if msg["type"] == "setmsggroup" {
-			gid, err := strconv.ParseInt(msg["gid"], 10, 32)
-			if err != nil {
-				return 0, nil
+		r.setState(ctx, StateConnecting, attempt, nil)
+		conn, err := r.dial(ctx, r.roomId)
+		if err == nil {
+			r.setState(ctx, StateConnected, attempt, nil)
+			connected := time.Now()
+			err = r.serve(ctx, conn)
+			// 连接保持过一个心跳间隔才清零失败次数, 否则连上就被断开的
+			// 服务器会让重连一直停在最短的等待时间上
+			if time.Since(connected) >= r.KeepAliveInterval {
+				attempt = 0
 			}
-			return int(gid), nil
 		}
-	}
-}
-
-func (r *DanmukuRoom) getHtml() (string, error) {
-	resp, err := http.Get("http://www.douyutv.com/" + strconv.Itoa(r.roomId))
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	return string(data), nil
-}
-
-func writeMessage(conn net.Conn, message string) error {
-	buf := new(bytes.Buffer)
-
-	length := int32(len(message) + 1 + 8)
-	frame := []interface{}{
-		int32(length),
-		int32(length),
-		int32(typeSend),
-	}
-	for _, item := range frame {
-		if err := binary.Write(buf, binary.LittleEndian, item); err != nil {
-			return err
+		if ctx.Err() != nil {
+			r.finish(ctx.Err())
+			return
 		}
-	}
-	buf.Write(append([]byte(message), 0))
-	conn.Write(buf.Bytes())
-
-	return nil
-}
-
-func readMessage(conn net.Conn) (string, error) {
-	var (
-		length      int32
-		length2     int32
-		messageType int32
-	)
-	if err := binary.Read(conn, binary.LittleEndian, &length); err != nil {
-		return "", err
-	}
-	if err := binary.Read(conn, binary.LittleEndian, &length2); err != nil {
-		return "", err
-	}
-	if length != length2 {
-		return "", fmt.Errorf("243: length(%d) != length2(%d)\n", length, length2)
-	}
-	if err := binary.Read(conn, binary.LittleEndian, &messageType); err != nil {
-		return "", err
-	}
-	if messageType != typeRecv {
-		return "", fmt.Errorf("249: messageData(%d) != typeRecv\n", messageType)
-	}
-	messageData := make([]byte, length-8)
 
-	for i := 0; i < int(length-8); {
-		n, err := conn.Read(messageData[i:])
-		if err != nil {
-			return "", err
+		attempt++
+		if r.Backoff.MaxRetries > 0 && attempt > r.Backoff.MaxRetries {
+			r.setState(ctx, StateFailed, attempt, err)
+			r.finish(err)
+			return
+		}
+		log.Println("danmuku: reconnect", r.addr(), attempt, err)
+		r.setState(ctx, StateRetrying, attempt, err)
+		select {
+		case <-time.After(r.Backoff.Duration(attempt)):
+		case <-ctx.Done():
+			r.finish(ctx.Err())
+			return
 		}
-		i += n
 	}
-
-	return string(messageData), nil
 }
 
-func (r *DanmukuRoom) readRoutine() {
+// serve 在一条已登录的连接上收发消息, 直到连接出错或 ctx 结束.
+func (r *DanmukuRoom) serve(ctx context.Context, conn ChatConn) error {
+	var wg sync.WaitGroup
+	done := make(chan bool)
+	release := CloseOnDone(ctx, conn)
+	defer func() {
+		release()
+		close(done)
+		conn.Close()
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.keepAliveRoutine(conn, done)
+	}()
+
 	for {
-		select {
-		case <-r.stopChannel:
-			return
-		default:
-		}
-		message, err := readMessage(r.conn)
+		conn.SetReadDeadline(time.Now().Add(2*r.KeepAliveInterval + 5*time.Second))
+		events, err := conn.Read()
 		if err != nil {
-			log.Println("272:", err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+				err = errKeepAlive
+			}
+			return err
 		}
-		msg := parseMessage(message)
-		if msg["type"] == "chatmessage" {
-			r.danmukuChannel <- Danmuku{
-				msg["snick"],
-				msg["content"],
+		now := time.Now()
+		for _, ev := range events {
+			Stamp(ev, now)
+			if !r.emit(ctx, ev) {
+				return ctx.Err()
 			}
 		}
 	}
 }
 
-func (r *DanmukuRoom) keepAliveRoutine() {
+func (r *DanmukuRoom) keepAliveRoutine(conn ChatConn, done chan bool) {
+	ticker := time.NewTicker(r.KeepAliveInterval)
+	defer ticker.Stop()
 	for {
-		select {
-		case <-r.stopChannel:
+		if err := conn.Heartbeat(); err != nil {
+			log.Println("danmuku: keepalive:", r.addr(), err)
+			conn.Close()
 			return
-		default:
 		}
-		keepAlive := formatMessage(map[string]string{
-			"type": "keeplive",
-			"tick": strconv.Itoa(int(time.Now().Unix())),
-		})
-		if err := writeMessage(r.conn, keepAlive); err != nil {
-			log.Println("297:", err)
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
 		}
-		time.Sleep(40 * time.Second)
 	}
 }
@@ -1,20 +1,634 @@
 package danmuku
 
 import (
-	"log"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku/douyutest"
+	"github.com/zwh8800/Love66/danmuku/frame"
+	"github.com/zwh8800/Love66/danmuku/stt"
 )
 
+func chatScript(texts ...string) []douyutest.Step {
+	steps := make([]douyutest.Step, 0, len(texts))
+	for _, text := range texts {
+		steps = append(steps, douyutest.Step{
+			Delay:   time.Millisecond,
+			Message: stt.NewMessage("chatmsg", "rid", "3258", "uid", "1", "nn", "a", "txt", text),
+		})
+	}
+	return steps
+}
+
+func nextChat(t *testing.T, sub *Subscription) *ChatMessage {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				t.Fatal("subscription closed")
+			}
+			if chat, ok := ev.(*ChatMessage); ok {
+				return chat
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for chat message")
+		}
+	}
+}
+
 func TestDanmuku(t *testing.T) {
-	danmukuRoom := NewDanmukuRoom(3258)
-	if err := danmukuRoom.Start(); err != nil {
-		t.Error(err)
-		return
+	server := douyutest.NewServer(chatScript("hello", "world"))
+	defer server.Close()
+	server.SetFaults(douyutest.Faults{ChunkSize: 3, WriteDelay: time.Millisecond})
+
+	danmukuRoom := NewDanmukuRoomWithTransport(3258, &OpenBarrageTransport{server.Addr})
+	danmukuRoom.KeepAliveInterval = 10 * time.Millisecond
+	sub := danmukuRoom.Subscribe(nil, 16, Block)
+	if err := danmukuRoom.Start(context.Background()); err != nil {
+		t.Fatal(err)
 	}
 	defer danmukuRoom.Stop()
 
+	for _, text := range []string{"hello", "world"} {
+		if chat := nextChat(t, sub); chat.Text != text || chat.Room() != 3258 {
+			t.Fatalf("unexpected chat %#v", chat)
+		}
+	}
+
+	received := server.Received()
+	if len(received) < 3 || received[0].Type() != "loginreq" || received[0].Get("roomid") != "3258" ||
+		received[1].Type() != "joingroup" || received[1].Get("gid") != "-9999" || received[2].Type() != "keeplive" {
+		t.Errorf("unexpected client messages %v", received)
+	}
+}
+
+func TestDanmukuFaults(t *testing.T) {
+	cases := []struct {
+		name   string
+		faults douyutest.Faults
+	}{
+		{"drop", douyutest.Faults{DropAfter: 1}},
+		{"bad length", douyutest.Faults{BadLengthAfter: 1}},
+	}
+	for _, c := range cases {
+		server := douyutest.NewServer(chatScript("a", "b", "c"))
+		server.SetFaults(c.faults)
+
+		r := NewDanmukuRoomWithTransport(3258, &OpenBarrageTransport{server.Addr})
+		r.Backoff = Backoff{Min: time.Millisecond, Max: time.Millisecond, Factor: 1}
+		sub := r.Subscribe(nil, 64, Block)
+		r.Start(context.Background())
+
+		// 第一条弹幕之后连接出错, 重连后脚本从头播放
+		if chat := nextChat(t, sub); chat.Text != "a" {
+			t.Errorf("%s: unexpected chat %#v", c.name, chat)
+		}
+		if sc := nextState(t, sub); sc.State != StateRetrying {
+			t.Errorf("%s: state = %s, want retrying", c.name, sc.State)
+		}
+		server.SetFaults(douyutest.Faults{})
+		for _, text := range []string{"a", "b", "c"} {
+			if chat := nextChat(t, sub); chat.Text != text {
+				t.Errorf("%s: unexpected chat %#v", c.name, chat)
+			}
+		}
+		if server.Logins() != 2 {
+			t.Errorf("%s: logins = %d, want 2", c.name, server.Logins())
+		}
+		r.Stop()
+		server.Close()
+	}
+}
+
+func TestDanmukuLoginRejected(t *testing.T) {
+	server := douyutest.NewServer(nil)
+	defer server.Close()
+	server.SetFaults(douyutest.Faults{RejectLogin: true})
+
+	r := NewDanmukuRoomWithTransport(3258, &OpenBarrageTransport{server.Addr})
+	r.Backoff = Backoff{Min: time.Millisecond, Max: time.Millisecond, Factor: 1, MaxRetries: 1}
+	r.Start(context.Background())
+	<-r.Done()
+	if r.Err() != ErrLoginFailed {
+		t.Errorf("Err() = %v, want ErrLoginFailed", r.Err())
+	}
+}
+
+func TestLegacyTransport(t *testing.T) {
+	server := douyutest.NewServerWithLayout(frame.Legacy, []douyutest.Step{{
+		Message: stt.NewMessage("chatmessage", "rid", "3258", "sender", "7", "snick", "a", "content", "hi"),
+	}})
+	defer server.Close()
+	api := douyutest.NewAPI()
+	defer api.Close()
+	api.SetPage(3258, douyutest.Page(server.Addr))
+
+	transport := &LegacyTransport{server.Addr, api.RoomPage()}
+	conn, err := transport.Dial(context.Background(), 3258)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msg, err := conn.waitFor("chatmsg", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Get("txt") != "hi" || msg.Get("uid") != "7" {
+		t.Errorf("unexpected message %v", msg)
+	}
+	var join stt.Message
+	for _, m := range server.Received() {
+		if m.Type() == "joingroup" {
+			join = m
+		}
+	}
+	if join.Get("gid") != "1" {
+		t.Errorf("unexpected joingroup %v", join)
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	cases := []struct {
+		message string
+		check   func(ev Event) bool
+	}{
+		{
+			"type@=chatmsg/rid@=156277/uid@=1/nn@=a@Sb/txt@=666/level@=12/col@=2/bnn@=粉丝/bl@=7/rg@=4/",
+			func(ev Event) bool {
+				chat, ok := ev.(*ChatMessage)
+				return ok && chat.Room() == 156277 && chat.Nickname == "a/b" && chat.Text == "666" &&
+					chat.Level == 12 && chat.Color == ColorBlue && chat.BadgeName == "粉丝" &&
+					chat.BadgeLevel == 7 && chat.Role == RoleModerator
+			},
+		},
+		{
+			"type@=dgb/rid@=1/uid@=2/nn@=x/gfid@=824/hits@=3/gfcnt@=1/",
+			func(ev Event) bool {
+				gift, ok := ev.(*Gift)
+				return ok && gift.GiftId == "824" && gift.Hits == 3
+			},
+		},
+		{
+			"type@=uenter/rid@=1/uid@=2/nn@=x/level@=5/",
+			func(ev Event) bool {
+				enter, ok := ev.(*UserEnter)
+				return ok && enter.Nickname == "x" && enter.Level == 5
+			},
+		},
+		{
+			"type@=spbc/rid@=1/sn@=a/dn@=b/gn@=火箭/gc@=1/drid@=3258/",
+			func(ev Event) bool {
+				spbc, ok := ev.(*SuperBroadcast)
+				return ok && spbc.GiftName == "火箭" && spbc.DestRoom == 3258
+			},
+		},
+		{
+			"type@=ranklist/rid@=1/list@=uid@AA=1@ASnickname@AA=a@ASgold@AA=100@AS@Suid@AA=2@ASnickname@AA=b@ASgold@AA=50@AS@S/",
+			func(ev Event) bool {
+				rank, ok := ev.(*RankUpdate)
+				return ok && len(rank.List) == 2 && rank.List[1].Nickname == "b" && rank.List[0].Gold == 100
+			},
+		},
+		{
+			"type@=rss/rid@=1/ss@=1/code@=0/",
+			func(ev Event) bool {
+				rss, ok := ev.(*LiveStatusChange)
+				return ok && rss.Live()
+			},
+		},
+		{
+			// 空的或格式不对的数值字段按零值处理, 其它字段照常解码
+			"type@=chatmsg/rid@=1/uid@=2/nn@=x/txt@=hi/level@=/bl@=abc/brid@=/",
+			func(ev Event) bool {
+				chat, ok := ev.(*ChatMessage)
+				return ok && chat.Uid == 2 && chat.Text == "hi" && chat.Level == 0 && chat.BadgeLevel == 0
+			},
+		},
+		{
+			"type@=newblackres/rid@=3/dnic@=x/",
+			func(ev Event) bool {
+				unknown, ok := ev.(*Unknown)
+				return ok && unknown.EventType() == "newblackres" && unknown.Room() == 3 && unknown.Fields.Get("dnic") == "x"
+			},
+		},
+	}
+	for _, c := range cases {
+		msg, err := stt.ParseMessage(c.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ev, err := DecodeEvent(msg)
+		if err != nil {
+			t.Errorf("DecodeEvent(%q): %v", c.message, err)
+			continue
+		}
+		if !c.check(ev) {
+			t.Errorf("DecodeEvent(%q) = %#v", c.message, ev)
+		}
+	}
+}
+
+func TestRoomAddr(t *testing.T) {
+	if addr := (&ChatMessage{Header: Header{RoomId: 1}}).Addr(); addr != "douyu:1" {
+		t.Errorf("Addr() = %s", addr)
+	}
+	if addr := (&StateChange{RoomId: 1, Scheme: "bilibili"}).Addr(); addr != "bilibili:1" {
+		t.Errorf("Addr() = %s", addr)
+	}
+	cases := map[string]string{"156277": "douyu:156277", "Huya:660000": "huya:660000", " bilibili:1 ": "bilibili:1"}
+	for addr, want := range cases {
+		scheme, roomId, err := ParseRoomAddr(addr)
+		if err != nil || RoomAddr(scheme, roomId) != want {
+			t.Errorf("ParseRoomAddr(%q) = %s, %d, %v", addr, scheme, roomId, err)
+		}
+	}
+	for _, addr := range []string{"", "huya:kpl", ":1", "douyu:"} {
+		if _, _, err := ParseRoomAddr(addr); err != ErrBadAddr {
+			t.Errorf("ParseRoomAddr(%q): err = %v", addr, err)
+		}
+	}
+	// 别名只能用 SplitRoomAddr 拆开
+	if scheme, id, err := SplitRoomAddr(" Huya:kpl"); err != nil || scheme != "huya" || id != "kpl" {
+		t.Errorf("SplitRoomAddr = %s, %s, %v", scheme, id, err)
+	}
+	if scheme, id, err := SplitRoomAddr("156277"); err != nil || scheme != DefaultScheme || id != "156277" {
+		t.Errorf("SplitRoomAddr = %s, %s, %v", scheme, id, err)
+	}
+}
+
+func TestLegacyChatMessage(t *testing.T) {
+	msg, _ := stt.ParseMessage("type@=chatmessage/rid@=1/sender@=2/snick@=x/content@=hi/")
+	ev, err := DecodeEvent(legacyToOpen(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat, ok := ev.(*ChatMessage)
+	if !ok || chat.Uid != 2 || chat.Nickname != "x" || chat.Text != "hi" || chat.EventType() != "chatmsg" {
+		t.Errorf("legacy chat = %#v", ev)
+	}
+}
+
+func TestParseServerConfig(t *testing.T) {
+	html := `var $ROOM = {"server_config":"%5B%7B%22ip%22%3A%22119.90.49.110%22%2C%22port%22%3A%228046%22%7D%5D"};`
+	sc, err := parseServerConfig(html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sc) != 1 || sc[0].IP != "119.90.49.110" || sc[0].Port != "8046" {
+		t.Errorf("parseServerConfig = %#v", sc)
+	}
+	if _, err := parseServerConfig("<html></html>"); err == nil {
+		t.Error("expect error for page without server_config")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: 10 * time.Second, Factor: 2}
+	want := []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, d := range want {
+		if got := b.Duration(i); got != d {
+			t.Errorf("Duration(%d) = %s, want %s", i, got, d)
+		}
+	}
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.Duration(3); d < 2*time.Second || d > 4*time.Second {
+			t.Errorf("jittered Duration(3) = %s", d)
+		}
+	}
+}
+
+type pipeTransport struct {
+	fail    int
+	servers chan net.Conn
+}
+
+func (t *pipeTransport) Dial(ctx context.Context, roomId int) (*Conn, error) {
+	if t.fail > 0 {
+		t.fail--
+		return nil, errors.New("dial failed")
+	}
+	client, server := net.Pipe()
+	go func() {
+		t.servers <- server
+	}()
+	return newConn(client, frame.Open), nil
+}
+
+func nextState(t *testing.T, sub *Subscription) *StateChange {
 	for {
-		danmuku := danmukuRoom.PeekDanmuku()
-		log.Printf("%s: %s\n", danmuku.User, danmuku.Content)
+		select {
+		case ev := <-sub.Events():
+			if sc, ok := ev.(*StateChange); ok {
+				return sc
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for state change")
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	transport := &pipeTransport{2, make(chan net.Conn)}
+	r := NewDanmukuRoomWithTransport(1, transport)
+	r.Backoff = Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond, Factor: 2}
+	sub := r.Subscribe(nil, 0, Block)
+	r.Start(context.Background())
+	defer r.Stop()
+
+	want := []State{StateConnecting, StateRetrying, StateConnecting, StateRetrying, StateConnecting, StateConnected}
+	for _, state := range want {
+		if sc := nextState(t, sub); sc.State != state {
+			t.Fatalf("state = %s, want %s", sc.State, state)
+		}
+	}
+
+	server := <-transport.servers
+	go io.Copy(ioutil.Discard, server)
+	encoder := frame.NewEncoder(server, frame.Open)
+	encoder.Type = frame.TypeServer
+	if err := encoder.Encode([]byte("type@=chatmsg/rid@=1/nn@=x/txt@=hi/")); err != nil {
+		t.Fatal(err)
+	}
+	if chat, ok := (<-sub.Events()).(*ChatMessage); !ok || chat.Text != "hi" {
+		t.Fatalf("expect chat message, got %#v", chat)
+	}
+
+	server.Close()
+	for _, state := range []State{StateRetrying, StateConnecting, StateConnected} {
+		if sc := nextState(t, sub); sc.State != state {
+			t.Fatalf("state = %s, want %s", sc.State, state)
+		}
+	}
+	if r.State() != StateConnected {
+		t.Errorf("State() = %s", r.State())
+	}
+}
+
+func TestReconnectGiveUp(t *testing.T) {
+	transport := &pipeTransport{100, make(chan net.Conn)}
+	r := NewDanmukuRoomWithTransport(1, transport)
+	r.Backoff = Backoff{Min: time.Millisecond, Max: time.Millisecond, Factor: 1, MaxRetries: 2}
+	sub := r.Subscribe(nil, 0, Block)
+	r.Start(context.Background())
+	defer r.Stop()
+
+	for {
+		sc := nextState(t, sub)
+		if sc.State == StateFailed {
+			if sc.Attempt != 3 || sc.Err == nil {
+				t.Errorf("failed state = %#v", sc)
+			}
+			return
+		}
+	}
+}
+
+func TestReconnectAcceptThenClose(t *testing.T) {
+	// 服务器接受连接后马上断开, 失败次数要继续累加直到放弃
+	transport := &pipeTransport{0, make(chan net.Conn)}
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case server := <-transport.servers:
+				server.Close()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	r := NewDanmukuRoomWithTransport(1, transport)
+	r.Backoff = Backoff{Min: time.Millisecond, Max: time.Millisecond, Factor: 1, MaxRetries: 2}
+	sub := r.Subscribe(nil, 0, Block)
+	r.Start(context.Background())
+	defer r.Stop()
+
+	attempts := make([]int, 0)
+	for i := 0; i < 20; i++ {
+		sc := nextState(t, sub)
+		switch sc.State {
+		case StateRetrying:
+			attempts = append(attempts, sc.Attempt)
+		case StateFailed:
+			if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 || sc.Attempt != 3 {
+				t.Errorf("attempts = %v, failed at %d", attempts, sc.Attempt)
+			}
+			return
+		}
+	}
+	t.Fatalf("room did not give up, attempts = %v", attempts)
+}
+
+func TestLifecycle(t *testing.T) {
+	r := NewDanmukuRoomWithTransport(1, &pipeTransport{0, make(chan net.Conn, 1)})
+	sub := r.Subscribe(nil, 0, Block)
+	r.Stop()
+	r.Stop()
+	if err := r.Start(context.Background()); err != ErrClosed {
+		t.Errorf("Start after Stop = %v, want ErrClosed", err)
+	}
+	if _, ok := <-sub.Events(); ok {
+		t.Error("subscription should be closed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := &pipeTransport{0, make(chan net.Conn, 1)}
+	r = NewDanmukuRoomWithTransport(1, transport)
+	sub = r.Subscribe(nil, 0, Block)
+	if err := r.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Start(ctx); err != nil {
+		t.Errorf("second Start = %v", err)
+	}
+	for nextState(t, sub).State != StateConnected {
+	}
+	server := <-transport.servers
+	go io.Copy(ioutil.Discard, server)
+
+	// 不再读取事件, 让房间阻塞在发送上, 取消后也必须退出
+	encoder := frame.NewEncoder(server, frame.Open)
+	encoder.Type = frame.TypeServer
+	go encoder.Encode([]byte("type@=chatmsg/rid@=1/txt@=a/"))
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-r.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("room did not stop after cancel")
+	}
+	if r.Err() != context.Canceled {
+		t.Errorf("Err() = %v", r.Err())
+	}
+	for range sub.Events() {
+	}
+	r.Stop()
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := NewBroadcaster()
+	chats := b.Subscribe(func(ev Event) bool {
+		_, ok := ev.(*ChatMessage)
+		return ok
+	}, 10, Block)
+	oldest := b.Subscribe(nil, 2, DropOldest)
+	newest := b.Subscribe(nil, 2, DropNewest)
+
+	ctx := context.Background()
+	for i := 1; i <= 4; i++ {
+		b.Publish(ctx, &ChatMessage{Header: Header{Type: "chatmsg", RoomId: i}})
+	}
+	b.Publish(ctx, &UserEnter{Header: Header{Type: "uenter", RoomId: 5}})
+
+	rooms := func(s *Subscription) []int {
+		result := make([]int, 0)
+		for {
+			select {
+			case ev := <-s.Events():
+				result = append(result, ev.Room())
+			default:
+				return result
+			}
+		}
+	}
+	if got := rooms(chats); len(got) != 4 || got[3] != 4 {
+		t.Errorf("filtered subscription got %v", got)
+	}
+	if got := rooms(oldest); len(got) != 2 || got[0] != 4 || got[1] != 5 || oldest.Dropped() != 3 {
+		t.Errorf("DropOldest got %v, dropped %d", got, oldest.Dropped())
+	}
+	if got := rooms(newest); len(got) != 2 || got[0] != 1 || got[1] != 2 || newest.Dropped() != 3 {
+		t.Errorf("DropNewest got %v, dropped %d", got, newest.Dropped())
+	}
+
+	// 阻塞中的 Publish 在取消订阅后返回
+	blocked := b.Subscribe(nil, 0, Block)
+	published := make(chan bool)
+	go func() {
+		b.Publish(ctx, &ChatMessage{})
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.Unsubscribe(blocked)
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish still blocked after Unsubscribe")
+	}
+	if _, ok := <-blocked.Events(); ok {
+		t.Error("unsubscribed stream should be closed")
+	}
+
+	rooms(chats)
+	b.Close()
+	if _, ok := <-chats.Events(); ok {
+		t.Error("stream should be closed after Close")
+	}
+	if _, ok := <-b.Subscribe(nil, 1, Block).Events(); ok {
+		t.Error("Subscribe after Close should return closed stream")
+	}
+}
+
+func TestHub(t *testing.T) {
+	transport := &pipeTransport{0, make(chan net.Conn, 4)}
+	hub := NewHub(transport, 3)
+	hub.Add("douyu:1")
+	hub.Add("douyu:2")
+	hub.Add("douyu:1")
+	sub := hub.Subscribe(func(ev Event) bool {
+		_, ok := ev.(*ChatMessage)
+		return ok
+	}, 100, Block)
+	if err := hub.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Stop()
+
+	for i := 0; i < 2; i++ {
+		server := <-transport.servers
+		go io.Copy(ioutil.Discard, server)
+		encoder := frame.NewEncoder(server, frame.Open)
+		encoder.Type = frame.TypeServer
+		for j := 0; j < 4; j++ {
+			encoder.Encode([]byte("type@=chatmsg/txt@=" + strconv.Itoa(j) + "/"))
+		}
+	}
+	select {
+	case <-transport.servers:
+		t.Error("room added twice should share one connection")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		select {
+		case ev := <-sub.Events():
+			counts[ev.Addr()]++
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for merged events")
+		}
+	}
+	if counts["douyu:1"] != 4 || counts["douyu:2"] != 4 {
+		t.Errorf("merged stream counts = %v", counts)
+	}
+
+	chats := 0
+	for _, ev := range hub.Backlog("douyu:1") {
+		if chat, ok := ev.(*ChatMessage); ok {
+			chats++
+			if chat.Room() != 1 {
+				t.Errorf("backlog event of room %d", chat.Room())
+			}
+		}
+	}
+	backlog := hub.Backlog("douyu:2")
+	if len(backlog) != 3 || chats != 3 || backlog[2].(*ChatMessage).Text != "3" {
+		t.Errorf("backlog = %#v", backlog)
+	}
+
+	hub.Remove("douyu:1")
+	if hub.Room("douyu:1") == nil {
+		t.Error("room removed while still referenced")
+	}
+	room := hub.Room("douyu:1")
+	hub.Remove("douyu:1")
+	if hub.Room("douyu:1") != nil {
+		t.Error("room not removed")
+	}
+	<-room.Done()
+	if addrs := hub.Rooms(); len(addrs) != 1 || addrs[0] != "douyu:2" {
+		t.Errorf("Rooms() = %v", addrs)
+	}
+
+	// 其它平台房间号相同的房间是不同的房间
+	hub.Add("bilibili:2")
+	if addrs := hub.Rooms(); len(addrs) != 2 || addrs[0] != "bilibili:2" {
+		t.Errorf("Rooms() = %v", addrs)
+	}
+}
+
+func TestNearestColor(t *testing.T) {
+	cases := map[uint32]Color{
+		0xffffff: ColorDefault,
+		0xe33fff: ColorPurple,
+		0x00ff00: ColorGreen,
+		0xff0000: ColorRed,
+		0x58c1de: ColorBlue,
+		0xffed4f: ColorYellow,
+	}
+	for rgb, c := range cases {
+		if got := NearestColor(rgb); got != c {
+			t.Errorf("NearestColor(%06x) = %d, want %d", rgb, got, c)
+		}
 	}
 }
@@ -0,0 +1,176 @@
+package douyutest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 这几个房间在 NewAPI 里已经有数据
+const (
+	RoomOnline  = 156277
+	RoomOffline = 3258
+)
+
+// Response 是一个接口的假回复, Status 为 0 时是 200
+type Response struct {
+	Status int
+	Body   string
+}
+
+// API 是一个假的斗鱼 HTTP 服务器, 提供房间信息 (m.douyu.com/html5/live),
+// 礼物列表 (open.douyucdn.cn/api/RoomApi/room/), 房间页面和需要签名的
+// 直播流接口 (www.douyu.com/lapi/live/getH5Play/). 用 LiveAPI, RoomAPI,
+// RoomPage 和 H5PlayAPI 返回的地址替换 room, gift 和 danmuku 里的地址.
+type API struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	info     map[int]Response
+	plays    map[string]Response
+	gifts    map[int]Response
+	pages    map[int]Response
+	h5Plays  map[int]Response
+	requests []string
+}
+
+// NewAPI 启动一个假的 HTTP 服务器, 其中 RoomOnline 正在直播, RoomOffline
+// 没有直播, 其它房间不存在.
+func NewAPI() *API {
+	a := &API{
+		info:    make(map[int]Response),
+		plays:   make(map[string]Response),
+		gifts:   make(map[int]Response),
+		pages:   make(map[int]Response),
+		h5Plays: make(map[int]Response),
+	}
+	a.info[RoomOnline] = Response{Body: InfoOnline}
+	a.info[RoomOffline] = Response{Body: InfoOffline}
+	a.gifts[RoomOnline] = Response{Body: Gifts}
+	a.gifts[RoomOffline] = Response{Body: Gifts}
+	a.h5Plays[RoomOnline] = Response{Body: H5PlayOnline}
+	a.h5Plays[RoomOffline] = Response{Body: H5PlayOffline}
+	a.Server = httptest.NewServer(http.HandlerFunc(a.serveHTTP))
+	return a
+}
+
+func (a *API) LiveAPI() string {
+	return a.URL + "/html5/live"
+}
+
+func (a *API) H5PlayAPI() string {
+	return a.URL + "/lapi/live/getH5Play/"
+}
+
+func (a *API) RoomAPI() string {
+	return a.URL + "/api/RoomApi/room/"
+}
+
+func (a *API) RoomPage() string {
+	return a.URL + "/"
+}
+
+// SetInfo 设置房间信息接口的回复
+func (a *API) SetInfo(roomId int, resp Response) {
+	a.mutex.Lock()
+	a.info[roomId] = resp
+	a.mutex.Unlock()
+}
+
+// SetPlay 设置请求某个清晰度和线路时房间信息接口的回复, 没有设置时使用
+// SetInfo 的回复
+func (a *API) SetPlay(roomId, rate int, cdn string, resp Response) {
+	a.mutex.Lock()
+	a.plays[strconv.Itoa(roomId)+"/"+strconv.Itoa(rate)+"/"+cdn] = resp
+	a.mutex.Unlock()
+}
+
+// SetH5Play 设置 getH5Play 签名正确时的回复
+func (a *API) SetH5Play(roomId int, resp Response) {
+	a.mutex.Lock()
+	a.h5Plays[roomId] = resp
+	a.mutex.Unlock()
+}
+
+// SetGifts 设置礼物列表接口的回复
+func (a *API) SetGifts(roomId int, resp Response) {
+	a.mutex.Lock()
+	a.gifts[roomId] = resp
+	a.mutex.Unlock()
+}
+
+// SetPage 设置房间页面, 通常是 Page 的返回值
+func (a *API) SetPage(roomId int, resp Response) {
+	a.mutex.Lock()
+	a.pages[roomId] = resp
+	a.mutex.Unlock()
+}
+
+// Requests 返回收到的所有请求的路径和参数, POST 请求是 "POST 路径 表单"
+func (a *API) Requests() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]string(nil), a.requests...)
+}
+
+func (a *API) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	request := r.URL.RequestURI()
+	if r.Method == "POST" {
+		r.ParseForm()
+		request = "POST " + request + " " + r.PostForm.Encode()
+	}
+	a.mutex.Lock()
+	a.requests = append(a.requests, request)
+	var (
+		resp Response
+		ok   bool
+	)
+	switch {
+	case r.URL.Path == "/html5/live":
+		q := r.URL.Query()
+		roomId, _ := strconv.Atoi(q.Get("roomId"))
+		if resp, ok = a.plays[q.Get("roomId")+"/"+q.Get("rate")+"/"+q.Get("cdn")]; ok {
+			break
+		}
+		if resp, ok = a.info[roomId]; !ok {
+			resp = Response{Body: InfoNotFound}
+		}
+	case strings.HasPrefix(r.URL.Path, "/api/RoomApi/room/"):
+		roomId, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/RoomApi/room/"))
+		if resp, ok = a.gifts[roomId]; !ok {
+			resp = Response{Body: GiftsNotFound}
+		}
+	case strings.HasPrefix(r.URL.Path, "/lapi/live/getH5Play/"):
+		rid := strings.TrimPrefix(r.URL.Path, "/lapi/live/getH5Play/")
+		roomId, _ := strconv.Atoi(rid)
+		if !checkSign(rid, r.PostForm) {
+			resp = Response{Body: H5PlayBadSign}
+		} else if resp, ok = a.h5Plays[roomId]; !ok {
+			resp = Response{Body: H5PlayNotFound}
+		}
+	default:
+		roomId, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if resp, ok = a.pages[roomId]; !ok {
+			resp = Response{http.StatusNotFound, "404 page not found"}
+		}
+	}
+	a.mutex.Unlock()
+
+	if resp.Status != 0 {
+		w.WriteHeader(resp.Status)
+	}
+	io.WriteString(w, resp.Body)
+}
+
+// checkSign 检查 getH5Play 的签名是不是 H5Page 里的脚本算出来的
+func checkSign(rid string, form url.Values) bool {
+	v, did, tt := form.Get("v"), form.Get("did"), form.Get("tt")
+	sum := md5.Sum([]byte(rid + did + tt + v))
+	return v == SignVersion && did != "" && tt != "" && form.Get("sign") == hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+package douyutest
+
+import (
+	"net"
+	"net/url"
+)
+
+// 房间信息接口的回复, 字段和真实接口一致, 只保留了用到的部分
+const (
+	InfoOnline = `{"error":0,"msg":"ok","data":{"room_id":"156277","tag_name":"英雄联盟",
+"room_src":"https://rpic.douyucdn.cn/a1701/15/20/156277_170115204702.jpg","room_name":"测试直播间",
+"show_status":"1","online":52314,"nickname":"测试主播",
+"hls_url":"https://hls3a.douyucdn.cn/live/156277rGXYXxoMzv_550/playlist.m3u8?wsSecret=0f8a&wsTime=1484484464",
+"rtmp_cdn":"ws-h5","rtmp_url":"https://hdl3a.douyucdn.cn/live","rtmp_live":"156277rGXYXxoMzv.flv?wsAuth=5d1e&token=h5-douyu-0-156277",
+"rate":0,"multirates":[{"name":"原画","rate":0,"bit":0},{"name":"超清","rate":3,"bit":2000},{"name":"高清","rate":2,"bit":900},{"name":"流畅","rate":1,"bit":500}],
+"cdnsWithName":[{"name":"主线路","cdn":"ws-h5"},{"name":"备用线路5","cdn":"tct-h5"}],
+"is_pass_player":0,"is_ticket":0,"storeLink":""}}`
+
+	// InfoOnlineLow 是请求流畅, 备用线路时的回复
+	InfoOnlineLow = `{"error":0,"msg":"ok","data":{"room_id":"156277","tag_name":"英雄联盟",
+"room_src":"https://rpic.douyucdn.cn/a1701/15/20/156277_170115204702.jpg","room_name":"测试直播间",
+"show_status":"1","online":52314,"nickname":"测试主播",
+"hls_url":"https://tc-tct.douyucdn2.cn/dyliveflv1/156277rGXYXxoMzv_500/playlist.m3u8?txSecret=7c2e&txTime=6553f100",
+"rtmp_cdn":"tct-h5","rtmp_url":"https://tc-tct.douyucdn2.cn/dyliveflv1","rtmp_live":"156277rGXYXxoMzv_500.flv?txSecret=7c2e&txTime=6553f100",
+"rate":1,"multirates":[{"name":"原画","rate":0,"bit":0},{"name":"超清","rate":3,"bit":2000},{"name":"高清","rate":2,"bit":900},{"name":"流畅","rate":1,"bit":500}],
+"cdnsWithName":[{"name":"主线路","cdn":"ws-h5"},{"name":"备用线路5","cdn":"tct-h5"}],
+"is_pass_player":0,"is_ticket":0,"storeLink":""}}`
+
+	InfoOffline = `{"error":0,"msg":"ok","data":{"room_id":"3258","tag_name":"户外",
+"room_src":"https://rpic.douyucdn.cn/a1701/15/20/3258_170115204702.jpg","room_name":"下播了",
+"show_status":"2","online":0,"nickname":"测试主播2","hls_url":"",
+"is_pass_player":0,"is_ticket":0,"storeLink":""}}`
+
+	// InfoNotFound 的 data 是数组, 和正常回复的类型不同
+	InfoNotFound = `{"error":-3,"msg":"房间未找到","data":[]}`
+
+	// InfoClosed 是被关闭或封禁的房间
+	InfoClosed = `{"error":-4,"msg":"房间已被关闭","data":[]}`
+
+	InfoMalformed = `{"error":0,"msg":"ok","data":{"room_id":"156277","show_status":`
+)
+
+// 礼物列表接口的回复
+const (
+	Gifts = `{"error":0,"data":{"room_id":"156277","gift":[
+	{"id":"191","name":"鱼丸","type":"1","pc":100,"gx":1},
+	{"id":"824","name":"荧光棒","type":"2","pc":0.1,"gx":1},
+	{"id":"196","name":"火箭","type":"2","pc":500,"gx":5000}
+]}}`
+
+	GiftsNotFound = `{"error":101,"data":"房间未找到"}`
+)
+
+// H5Page 是带有签名脚本的房间页面. 签名函数 ub98484234(rid, did, tt)
+// 的结果是 "v=<SignVersion>&did=...&tt=...&sign=md5(rid+did+tt+v)",
+// 和真实页面一样, 函数体是运行时解出来再 eval 的.
+const H5Page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<title>测试直播间_测试主播的直播间_斗鱼直播</title>
+<script type="text/javascript">
+var $ROOM = {"room_id":156277,"owner_uid":12345678,"owner_name":"测试主播","room_name":"测试直播间","show_status":1};
+</script>
+<script type="text/javascript">
+var vdwdae325w_64we = ")} ;br + '=ngis&' + tt + '=tt&' + did + '=did&' + v + '=v' nruter ;)(gnirtSot.)v + tt + did + dir(5DM.SJotpyrC = br rav ;'810132021022' = v rav { )tt ,did ,dir( noitcnuf(";
+function ub98484234(xx0, xx1, xx2) {
+    var strc = vdwdae325w_64we.split("").reverse().join("");
+    return eval(strc)(xx0, xx1, xx2);
+}
+</script>
+</head>
+<body>
+<div id="js-player-main"></div>
+</body>
+</html>`
+
+// SignVersion 是 H5Page 里签名脚本的版本号, 也参与签名
+const SignVersion = "220120231018"
+
+// getH5Play 接口的回复
+const (
+	H5PlayOnline = `{"error":0,"msg":"ok","data":{"room_id":156277,"is_mixed":false,"mixed_live":"","mixed_url":"",
+"rtmp_cdn":"hw-h5","rtmp_url":"https://hw-tct.douyucdn.cn/live",
+"rtmp_live":"156277rGXYXxoMzv.flv?wsAuth=9f2c&token=web-h5-0-156277-8c1e&logo=0&expire=0&did=10000000000000000000000000001501&ver=Douyu_223061205&pt=2&st=0",
+"rate":0,"multirates":[{"name":"原画","rate":0,"highBit":0,"bit":0},{"name":"超清","rate":3,"highBit":0,"bit":2000},
+{"name":"高清","rate":2,"highBit":0,"bit":900},{"name":"流畅","rate":1,"highBit":0,"bit":500}],
+"cdnsWithName":[{"name":"主线路","cdn":"hw-h5","isH265":false},{"name":"备用线路5","cdn":"tct-h5","isH265":false}],
+"isPassPlayer":0,"eticket":null,"online":0,"mixedCDN":"","p2p":0,"streamStatus":1,"smt":0,"p2pMeta":[],"p2pCid":0,"p2pCids":"",
+"player_1":"","h265_p2p":0,"h265_p2p_cid":0,"h265_p2p_cids":"","acdn":"","av1_url":"","rtc_stream_url":"","rtc_stream_config":""}}`
+
+	H5PlayOffline  = `{"error":-5,"msg":"房间未开播","data":""}`
+	H5PlayNotFound = `{"error":102,"msg":"房间不存在","data":""}`
+	H5PlayBadSign  = `{"error":-9,"msg":"鉴权失败","data":""}`
+)
+
+// Page 返回一个房间页面, 其中的 server_config 指向 addr, 通常是一个
+// Legacy 帧格式的 Server 的地址.
+func Page(addr string) Response {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	config := url.QueryEscape(`[{"ip":"` + host + `","port":"` + port + `"}]`)
+	return Response{Body: `<html><script>var $ROOM = {"room_id":156277,"server_config":"` + config + `"};</script></html>`}
+}
@@ -0,0 +1,356 @@
+// Package douyutest 提供一个本地的假斗鱼弹幕服务器, 用来离线测试和开发.
+// 它只依赖 frame 和 stt, danmuku 包自己的测试也可以使用.
+package douyutest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku/frame"
+	"github.com/zwh8800/Love66/danmuku/stt"
+)
+
+// Step 是脚本里的一条消息, Delay 是距离上一条消息的时间.
+type Step struct {
+	Delay   time.Duration
+	Message stt.Message
+}
+
+// ReadScript 读取脚本, 每行是等待时间和一条 STT 消息, 用空白分开:
+//
+//	# 注释
+//	0s    type@=chatmsg/rid@=1/nn@=a/txt@=hello/
+//	500ms type@=dgb/rid@=1/nn@=b/gfid@=824/hits@=1/
+//
+// 录制的弹幕可以转换成这种格式回放.
+func ReadScript(r io.Reader) ([]Step, error) {
+	steps := make([]Step, 0)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, errors.New("douyutest: bad script line " + strconv.Itoa(line))
+		}
+		delay, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		msg, err := stt.ParseMessage(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, Step{delay, msg})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// Faults 是注入的故障. 计数只包括脚本里的消息, 每条连接分别计数.
+type Faults struct {
+	// RejectLogin 时用 type@=error 回复登录
+	RejectLogin bool
+	// IgnoreKeepAlive 时不回复心跳
+	IgnoreKeepAlive bool
+	// DropAfter 不为 0 时发送这么多条脚本消息后断开连接
+	DropAfter int
+	// BadLengthAfter 不为 0 时发送这么多条脚本消息后, 之后的帧两个长度不一致
+	BadLengthAfter int
+	// WriteDelay 是每次写之前的等待, 模拟慢速网络
+	WriteDelay time.Duration
+	// ChunkSize 不为 0 时把帧拆成这么大的块分别写出, 模拟 TCP 分包
+	ChunkSize int
+}
+
+// Server 是一个假的弹幕服务器. 它回复 loginreq 和 keeplive, 在收到
+// joingroup 后按 Script 发送消息.
+type Server struct {
+	// Addr 是监听地址, 可以直接作为 OpenBarrageTransport 的 Addr
+	Addr   string
+	Layout frame.Layout
+	Script []Step
+
+	listener net.Listener
+
+	mutex    sync.Mutex
+	faults   Faults
+	conns    map[*serverConn]bool
+	received []stt.Message
+	logins   int
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer 在 127.0.0.1 的随机端口上启动一个使用开放接口帧格式的服务器
+func NewServer(script []Step) *Server {
+	return NewServerWithLayout(frame.Open, script)
+}
+
+func NewServerWithLayout(layout frame.Layout, script []Step) *Server {
+	s, err := Listen("127.0.0.1:0", layout, script)
+	if err != nil {
+		panic("douyutest: " + err.Error())
+	}
+	return s
+}
+
+func Listen(addr string, layout frame.Layout, script []Step) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:     l.Addr().String(),
+		Layout:   layout,
+		Script:   script,
+		listener: l,
+		conns:    make(map[*serverConn]bool),
+	}
+	s.wg.Add(1)
+	go s.acceptRoutine()
+	return s, nil
+}
+
+// Close 关闭监听和所有连接, 等待所有 goroutine 退出.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mutex.Unlock()
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// SetFaults 修改故障设置, 对已有的连接也生效.
+func (s *Server) SetFaults(f Faults) {
+	s.mutex.Lock()
+	s.faults = f
+	s.mutex.Unlock()
+}
+
+// Logins 返回收到的 loginreq 个数
+func (s *Server) Logins() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.logins
+}
+
+// Received 返回收到的所有客户端消息
+func (s *Server) Received() []stt.Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]stt.Message(nil), s.received...)
+}
+
+// Send 把消息发给所有已经入组的连接, 和脚本消息一样计入故障计数
+func (s *Server) Send(msg stt.Message) {
+	s.mutex.Lock()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for c := range s.conns {
+		if c.joined {
+			conns = append(conns, c)
+		}
+	}
+	s.mutex.Unlock()
+	for _, c := range conns {
+		c.write(msg, true)
+	}
+}
+
+// DropAll 断开所有连接, 用来模拟服务器重启.
+func (s *Server) DropAll() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *Server) acceptRoutine() {
+	defer s.wg.Done()
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &serverConn{Conn: netConn, server: s, done: make(chan struct{})}
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			netConn.Close()
+			return
+		}
+		s.conns[c] = true
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mutex.Lock()
+			delete(s.conns, c)
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+type serverConn struct {
+	net.Conn
+	server *Server
+	done   chan struct{}
+	once   sync.Once
+
+	writeMutex sync.Mutex
+	written    int  // 写出的脚本消息个数
+	joined     bool // 由 server.mutex 保护
+}
+
+func (c *serverConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	return c.Conn.Close()
+}
+
+func (c *serverConn) serve() {
+	defer c.Close()
+	decoder := frame.NewDecoder(c, c.server.Layout)
+	decoder.Type = frame.TypeClient
+	for {
+		data, err := decoder.Decode()
+		if err != nil {
+			return
+		}
+		msg, err := stt.ParseMessage(string(data))
+		if err != nil {
+			return
+		}
+
+		s := c.server
+		s.mutex.Lock()
+		s.received = append(s.received, msg)
+		faults := s.faults
+		if msg.Type() == "loginreq" {
+			s.logins++
+		}
+		s.mutex.Unlock()
+
+		switch msg.Type() {
+		case "loginreq":
+			if faults.RejectLogin {
+				c.write(stt.NewMessage("error", "code", "51"), false)
+				return
+			}
+			c.write(stt.NewMessage("loginres", "userid", "0", "roomgroup", "0", "pg", "0"), false)
+			if s.Layout == frame.Legacy {
+				c.write(stt.NewMessage("setmsggroup", "rid", msg.Get("roomid"), "gid", "1"), false)
+			}
+		case "joingroup":
+			s.mutex.Lock()
+			first := !c.joined
+			c.joined = true
+			s.mutex.Unlock()
+			if first {
+				s.wg.Add(1)
+				go func() {
+					defer s.wg.Done()
+					c.play(s.Script)
+				}()
+			}
+		case "keeplive", "mrkl":
+			if !faults.IgnoreKeepAlive {
+				c.write(stt.NewMessage("keeplive", "tick", msg.Get("tick")), false)
+			}
+		case "logout":
+			return
+		}
+	}
+}
+
+func (c *serverConn) play(script []Step) {
+	for _, step := range script {
+		select {
+		case <-time.After(step.Delay):
+		case <-c.done:
+			return
+		}
+		if c.write(step.Message, true) != nil {
+			return
+		}
+	}
+}
+
+// write 写出一条消息, scripted 表示是脚本里的消息, 只有它们计入故障计数.
+func (c *serverConn) write(msg stt.Message, scripted bool) error {
+	c.server.mutex.Lock()
+	faults := c.server.faults
+	c.server.mutex.Unlock()
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if faults.WriteDelay > 0 {
+		time.Sleep(faults.WriteDelay)
+	}
+	if scripted {
+		c.written++
+	}
+
+	body := []byte(msg.String())
+	data := encode(c.server.Layout, body)
+	if scripted && faults.BadLengthAfter > 0 && c.written > faults.BadLengthAfter {
+		binary.LittleEndian.PutUint32(data[4:8], uint32(len(body)+100))
+	}
+	if err := c.writeChunks(data, faults.ChunkSize); err != nil {
+		c.Close()
+		return err
+	}
+	if scripted && faults.DropAfter > 0 && c.written >= faults.DropAfter {
+		c.Close()
+		return io.EOF
+	}
+	return nil
+}
+
+func (c *serverConn) writeChunks(data []byte, size int) error {
+	if size <= 0 {
+		_, err := c.Write(data)
+		return err
+	}
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := c.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// encode 按服务器的消息类型编码一个帧
+func encode(layout frame.Layout, body []byte) []byte {
+	var buf bytes.Buffer
+	e := frame.NewEncoder(&buf, layout)
+	e.Type = frame.TypeServer
+	e.Encode(body)
+	return buf.Bytes()
+}
@@ -0,0 +1,83 @@
+package douyutest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku/frame"
+	"github.com/zwh8800/Love66/danmuku/stt"
+)
+
+func TestReadScript(t *testing.T) {
+	steps, err := ReadScript(strings.NewReader(`
+# 注释
+0s    type@=chatmsg/rid@=1/txt@=hello/
+500ms type@=dgb/rid@=1/gfid@=824/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[1].Delay != 500*time.Millisecond || steps[1].Message.Get("gfid") != "824" {
+		t.Errorf("unexpected steps %v", steps)
+	}
+	if _, err := ReadScript(strings.NewReader("1s")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	data := encode(frame.Legacy, []byte("type@=loginres/"))
+	d := frame.NewDecoder(strings.NewReader(string(data)), frame.Legacy)
+	body, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := stt.ParseMessage(string(body)); msg.Type() != "loginres" {
+		t.Error("unexpected message", msg)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	api := NewAPI()
+	defer api.Close()
+	api.SetPage(RoomOnline, Page("127.0.0.1:8601"))
+	api.SetGifts(1, Response{http.StatusInternalServerError, "oops"})
+
+	get := func(u string) (int, string) {
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	cases := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{api.LiveAPI() + "?roomId=156277", 200, InfoOnline},
+		{api.LiveAPI() + "?roomId=3258", 200, InfoOffline},
+		{api.LiveAPI() + "?roomId=1", 200, InfoNotFound},
+		{api.RoomAPI() + "156277", 200, Gifts},
+		{api.RoomAPI() + "2", 200, GiftsNotFound},
+		{api.RoomAPI() + "1", 500, "oops"},
+		{api.RoomPage() + "156277", 200, Page("127.0.0.1:8601").Body},
+		{api.RoomPage() + "1", 404, "404 page not found"},
+	}
+	for _, c := range cases {
+		if status, body := get(c.url); status != c.status || body != c.body {
+			t.Errorf("GET %s = %d %q", c.url, status, body)
+		}
+	}
+	if n := len(api.Requests()); n != len(cases) {
+		t.Errorf("got %d requests, want %d", n, len(cases))
+	}
+	if !strings.Contains(Page("127.0.0.1:8601").Body, `"server_config":"%5B%7B%22ip%22%3A%22127.0.0.1%22%2C%22port%22%3A%228601%22%7D%5D"`) {
+		t.Error("unexpected page", Page("127.0.0.1:8601").Body)
+	}
+}
@@ -0,0 +1,329 @@
+package danmuku
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku/stt"
+)
+
+type Event interface {
+	EventType() string
+	// Room 是平台内的房间号, 不同平台的房间号可能相同
+	Room() int
+	// Addr 是 "douyu:156277" 这样的房间地址, 区分房间时使用它
+	Addr() string
+}
+
+// DefaultScheme 是没有标明平台的事件所属的平台. 弹幕协议本身是斗鱼的,
+// 从 stt 消息解码的事件都属于斗鱼.
+const DefaultScheme = "douyu"
+
+var ErrBadAddr = errors.New("danmuku: bad room address")
+
+// RoomAddr 返回房间地址, 格式和 provider.Room.String 相同. scheme 为空时
+// 是 DefaultScheme.
+func RoomAddr(scheme string, roomId int) string {
+	if scheme == "" {
+		scheme = DefaultScheme
+	}
+	return scheme + ":" + strconv.Itoa(roomId)
+}
+
+// SplitRoomAddr 把 "douyu:156277" 拆成小写的平台和房间号, 房间号也可以是
+// 别名. 只写房间号时是 DefaultScheme 的房间, 兼容以前只有房间号的播放列表.
+func SplitRoomAddr(addr string) (scheme, id string, err error) {
+	scheme, id = DefaultScheme, strings.TrimSpace(addr)
+	if i := strings.Index(id, ":"); i >= 0 {
+		scheme, id = strings.ToLower(id[:i]), id[i+1:]
+	}
+	if scheme == "" || id == "" {
+		return "", "", ErrBadAddr
+	}
+	return scheme, id, nil
+}
+
+// ParseRoomAddr 解析 RoomAddr 返回的地址, 房间号必须是数字. 房间的别名要用
+// provider.Open 解析.
+func ParseRoomAddr(addr string) (scheme string, roomId int, err error) {
+	scheme, id, err := SplitRoomAddr(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	roomId, err = strconv.Atoi(id)
+	if err != nil {
+		return "", 0, ErrBadAddr
+	}
+	return scheme, roomId, nil
+}
+
+type Header struct {
+	Type   string `stt:"type" json:"type"`
+	RoomId int    `stt:"rid" json:"rid"`
+	// Scheme 是事件所属的平台, 为空时是 DefaultScheme
+	Scheme string `stt:"-" json:"scheme,omitempty"`
+	// Received 是事件源收到这条消息的时间, 不参与编码
+	Received time.Time `stt:"-" json:"-"`
+}
+
+func (h *Header) EventType() string {
+	return h.Type
+}
+
+func (h *Header) Room() int {
+	return h.RoomId
+}
+
+func (h *Header) Addr() string {
+	return RoomAddr(h.Scheme, h.RoomId)
+}
+
+func (h *Header) setRoom(roomId int) {
+	if h.RoomId == 0 {
+		h.RoomId = roomId
+	}
+}
+
+func (h *Header) stamp(t time.Time) {
+	if h.Received.IsZero() {
+		h.Received = t
+	}
+}
+
+func (h *Header) receivedAt() time.Time {
+	return h.Received
+}
+
+// Stamp 记录事件源收到 ev 的时间, 已经记录过的不变. 订阅者的缓冲区积压时
+// 读到事件的时间会晚于收到的时间.
+func Stamp(ev Event, t time.Time) {
+	if ev, ok := ev.(interface{ stamp(time.Time) }); ok {
+		ev.stamp(t)
+	}
+}
+
+// ReceivedAt 返回事件源收到 ev 的时间, 没有记录时返回零值
+func ReceivedAt(ev Event) time.Time {
+	if ev, ok := ev.(interface{ receivedAt() time.Time }); ok {
+		return ev.receivedAt()
+	}
+	return time.Time{}
+}
+
+// tagRoom 给没有 rid 字段的事件补上房间号
+func tagRoom(ev Event, roomId int) {
+	if ev, ok := ev.(interface{ setRoom(int) }); ok {
+		ev.setRoom(roomId)
+	}
+}
+
+type Color int
+
+const (
+	ColorDefault Color = iota
+	ColorRed
+	ColorBlue
+	ColorGreen
+	ColorYellow
+	ColorPurple
+	ColorPink
+)
+
+// RGB 返回网页上弹幕的颜色, 未知的颜色按默认的白色处理.
+func (c Color) RGB() uint32 {
+	switch c {
+	case ColorRed:
+		return 0xff0000
+	case ColorBlue:
+		return 0x1e87f0
+	case ColorGreen:
+		return 0x7ac84b
+	case ColorYellow:
+		return 0xff7f00
+	case ColorPurple:
+		return 0x9b39f4
+	case ColorPink:
+		return 0xff69b4
+	}
+	return 0xffffff
+}
+
+// NearestColor 把其它平台的 RGB 颜色换成最接近的弹幕颜色, 白色是默认颜色.
+func NearestColor(rgb uint32) Color {
+	if rgb == 0xffffff {
+		return ColorDefault
+	}
+	best, bestDistance := ColorDefault, 0xffffff*3
+	for c := ColorRed; c <= ColorPink; c++ {
+		distance := 0
+		for shift := uint(0); shift < 24; shift += 8 {
+			d := int(rgb>>shift&0xff) - int(c.RGB()>>shift&0xff)
+			distance += d * d
+		}
+		if distance < bestDistance {
+			best, bestDistance = c, distance
+		}
+	}
+	return best
+}
+
+// 房间内的身份, 对应 rg 字段
+const (
+	RoleNormal    = 1
+	RoleModerator = 4
+	RoleAnchor    = 5
+)
+
+type ChatMessage struct {
+	Header
+	Cid        string `stt:"cid" json:"cid,omitempty"`
+	Uid        int    `stt:"uid" json:"uid"`
+	Nickname   string `stt:"nn" json:"nn"`
+	Text       string `stt:"txt" json:"txt"`
+	Level      int    `stt:"level" json:"level"`
+	Color      Color  `stt:"col" json:"col,omitempty"`
+	BadgeName  string `stt:"bnn" json:"bnn,omitempty"`
+	BadgeLevel int    `stt:"bl" json:"bl,omitempty"`
+	BadgeRoom  int    `stt:"brid" json:"brid,omitempty"`
+	Role       int    `stt:"rg" json:"rg,omitempty"`
+	Noble      int    `stt:"nl" json:"nl,omitempty"`
+	Avatar     string `stt:"ic" json:"ic,omitempty"`
+}
+
+type Gift struct {
+	Header
+	Uid        int    `stt:"uid" json:"uid"`
+	Nickname   string `stt:"nn" json:"nn"`
+	Level      int    `stt:"level" json:"level"`
+	GiftId     string `stt:"gfid" json:"gfid"`
+	GiftStyle  string `stt:"gs" json:"gs,omitempty"`
+	Count      int    `stt:"gfcnt" json:"gfcnt,omitempty"`
+	Hits       int    `stt:"hits" json:"hits,omitempty"`
+	BadgeName  string `stt:"bnn" json:"bnn,omitempty"`
+	BadgeLevel int    `stt:"bl" json:"bl,omitempty"`
+	Avatar     string `stt:"ic" json:"ic,omitempty"`
+
+	// GiftName 和 Price (元) 由消息里自带礼物信息的平台填写, 斗鱼的礼物
+	// 要用 gift 包查找
+	GiftName string  `stt:"gfn" json:"gfn,omitempty"`
+	Price    float64 `stt:"-" json:"price,omitempty"`
+}
+
+type UserEnter struct {
+	Header
+	Uid      int    `stt:"uid" json:"uid"`
+	Nickname string `stt:"nn" json:"nn"`
+	Level    int    `stt:"level" json:"level"`
+	Noble    int    `stt:"nl" json:"nl,omitempty"`
+	Avatar   string `stt:"ic" json:"ic,omitempty"`
+}
+
+// SuperBroadcast 是全站广播的超级礼物 (火箭, 飞机)
+type SuperBroadcast struct {
+	Header
+	Sender    string `stt:"sn" json:"sn"`
+	Receiver  string `stt:"dn" json:"dn"`
+	GiftName  string `stt:"gn" json:"gn"`
+	GiftCount int    `stt:"gc" json:"gc"`
+	DestRoom  int    `stt:"drid" json:"drid"`
+	GiftStyle string `stt:"gs" json:"gs,omitempty"`
+}
+
+type RankItem struct {
+	Uid      int    `stt:"uid" json:"uid"`
+	Nickname string `stt:"nickname" json:"nickname"`
+	Level    int    `stt:"level" json:"level,omitempty"`
+	Gold     int64  `stt:"gold" json:"gold"`
+	Icon     string `stt:"icon" json:"icon,omitempty"`
+}
+
+type RankUpdate struct {
+	Header
+	Ts      int64      `stt:"ts" json:"ts,omitempty"`
+	ListAll []RankItem `stt:"list_all" json:"list_all,omitempty"`
+	List    []RankItem `stt:"list" json:"list,omitempty"`
+	ListDay []RankItem `stt:"list_day" json:"list_day,omitempty"`
+}
+
+type LiveStatusChange struct {
+	Header
+	Status  int   `stt:"ss" json:"ss"`
+	Code    int   `stt:"code" json:"code,omitempty"`
+	Notify  int   `stt:"notify" json:"notify,omitempty"`
+	EndTime int64 `stt:"endtime" json:"endtime,omitempty"`
+}
+
+func (e *LiveStatusChange) Live() bool {
+	return e.Status == 1
+}
+
+// Unknown 保存没有注册解码器或者解码失败的消息的原始字段
+type Unknown struct {
+	Header
+	Fields stt.Message `stt:"-" json:"fields"`
+}
+
+var (
+	eventTypesMutex sync.RWMutex
+	eventTypes      = map[string]func() Event{
+		"chatmsg":  func() Event { return &ChatMessage{} },
+		"dgb":      func() Event { return &Gift{} },
+		"uenter":   func() Event { return &UserEnter{} },
+		"spbc":     func() Event { return &SuperBroadcast{} },
+		"ranklist": func() Event { return &RankUpdate{} },
+		"rss":      func() Event { return &LiveStatusChange{} },
+	}
+)
+
+// RegisterEvent 为 type@= 等于 typ 的消息注册一个事件类型, newEvent 返回
+// 一个可以被 stt.Unmarshal 解码的 struct 指针.
+func RegisterEvent(typ string, newEvent func() Event) {
+	eventTypesMutex.Lock()
+	defer eventTypesMutex.Unlock()
+	eventTypes[typ] = newEvent
+}
+
+// NewEvent 返回 typ 对应的空事件, 没有注册时返回 nil.
+func NewEvent(typ string) Event {
+	eventTypesMutex.RLock()
+	newEvent, ok := eventTypes[typ]
+	eventTypesMutex.RUnlock()
+	if !ok {
+		return nil
+	}
+	return newEvent()
+}
+
+// DecodeEvent 把消息解码成注册的事件类型. 解码宽松: 格式不对的字段按零值
+// 处理, 还是解码不了时返回保存原始字段的 *Unknown, 不会丢掉整条消息.
+func DecodeEvent(msg stt.Message) (Event, error) {
+	ev := NewEvent(msg.Type())
+	if ev == nil {
+		return unknownEvent(msg), nil
+	}
+	if err := stt.Unmarshal([]byte(msg.String()), ev); err == nil {
+		return ev, nil
+	}
+
+	// 逐个字段检查, 去掉解码不了的字段再解码一次
+	clean := make(stt.Message, 0, len(msg))
+	for _, f := range msg {
+		field := stt.NewMessage(msg.Type(), f.Key, f.Value)
+		if stt.Unmarshal([]byte(field.String()), NewEvent(msg.Type())) == nil {
+			clean = append(clean, f)
+		}
+	}
+	ev = NewEvent(msg.Type())
+	if err := stt.Unmarshal([]byte(clean.String()), ev); err != nil {
+		return unknownEvent(msg), nil
+	}
+	return ev, nil
+}
+
+func unknownEvent(msg stt.Message) *Unknown {
+	rid, _ := strconv.Atoi(msg.Get("rid"))
+	return &Unknown{Header{Type: msg.Type(), RoomId: rid}, msg}
+}
@@ -0,0 +1,154 @@
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// 斗鱼弹幕的帧格式 (小端):
+//
+//	| length uint32 | length uint32 | header 4 bytes | body ... | '\0' |
+//
+// length 不包含第一个 length 字段本身. 旧版 danmu.douyutv.com 把 header 当作
+// int32 消息类型; 第三方开放接口 openbarrage.douyutv.com 把 header 拆成
+// uint16 消息类型 + uint8 加密标志 + uint8 保留字段.
+
+const (
+	TypeClient uint16 = 689
+	TypeServer uint16 = 690
+
+	HeaderSize     = 12
+	DefaultMaxSize = 1 << 20
+)
+
+type Layout int
+
+const (
+	Legacy Layout = iota
+	Open
+)
+
+func (l Layout) String() string {
+	switch l {
+	case Legacy:
+		return "legacy"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("Layout(%d)", int(l))
+}
+
+type LengthMismatchError struct {
+	Length  uint32
+	Length2 uint32
+}
+
+func (e *LengthMismatchError) Error() string {
+	return fmt.Sprintf("frame: length mismatch: %d != %d", e.Length, e.Length2)
+}
+
+type TypeError struct {
+	Layout Layout
+	Want   uint16
+	Header uint32
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("frame: unexpected %s header 0x%08x, want type %d", e.Layout, e.Header, e.Want)
+}
+
+type SizeError struct {
+	Length uint32
+	Max    int
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("frame: invalid length %d (max %d)", e.Length, e.Max)
+}
+
+type Encoder struct {
+	w      io.Writer
+	layout Layout
+
+	// Type 是写出的消息类型, 客户端默认为 TypeClient.
+	Type uint16
+}
+
+func NewEncoder(w io.Writer, layout Layout) *Encoder {
+	return &Encoder{w, layout, TypeClient}
+}
+
+// Encode 写出一帧, body 末尾自动补 '\0'. 整帧一次 Write, 可以并发调用
+// 只要底层 Writer 的单次 Write 是原子的 (比如 net.Conn).
+func (e *Encoder) Encode(body []byte) error {
+	length := uint32(len(body) + 1 + 8)
+	buf := make([]byte, int(length)+4)
+	binary.LittleEndian.PutUint32(buf[0:4], length)
+	binary.LittleEndian.PutUint32(buf[4:8], length)
+	binary.LittleEndian.PutUint16(buf[8:10], e.Type)
+	copy(buf[HeaderSize:], body)
+
+	_, err := e.w.Write(buf)
+	return err
+}
+
+type Decoder struct {
+	r      *bufio.Reader
+	layout Layout
+
+	// Type 是期望读到的消息类型, 客户端默认为 TypeServer.
+	Type uint16
+	// MaxSize 限制单帧长度, 防止错误的长度字段导致分配过大的内存.
+	MaxSize int
+}
+
+func NewDecoder(r io.Reader, layout Layout) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{br, layout, TypeServer, DefaultMaxSize}
+}
+
+// Decode 读取一帧并返回去掉末尾 '\0' 的 body. 帧读到一半时遇到 EOF 返回
+// io.ErrUnexpectedEOF.
+func (d *Decoder) Decode() ([]byte, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(d.r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	length2 := binary.LittleEndian.Uint32(header[4:8])
+	if length != length2 {
+		return nil, &LengthMismatchError{length, length2}
+	}
+	if length < 8 || int64(length) > int64(d.MaxSize) {
+		return nil, &SizeError{length, d.MaxSize}
+	}
+
+	typeHeader := binary.LittleEndian.Uint32(header[8:12])
+	switch d.layout {
+	case Legacy:
+		if typeHeader != uint32(d.Type) {
+			return nil, &TypeError{d.layout, d.Type, typeHeader}
+		}
+	default:
+		if uint16(typeHeader) != d.Type {
+			return nil, &TypeError{d.layout, d.Type, typeHeader}
+		}
+	}
+
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(d.r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if n := len(body); n > 0 && body[n-1] == 0 {
+		body = body[:n-1]
+	}
+	return body, nil
+}
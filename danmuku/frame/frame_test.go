@@ -0,0 +1,105 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func rawFrame(length, length2 uint32, header uint32, body string) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, length)
+	binary.Write(buf, binary.LittleEndian, length2)
+	binary.Write(buf, binary.LittleEndian, header)
+	buf.WriteString(body)
+	return buf.Bytes()
+}
+
+func TestEncode(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := NewEncoder(buf, Open).Encode([]byte("type@=mrkl/")); err != nil {
+		t.Fatal(err)
+	}
+	want := rawFrame(20, 20, 689, "type@=mrkl/\x00")
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Encode = %v, want %v", buf.Bytes(), want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, layout := range []Layout{Legacy, Open} {
+		buf := new(bytes.Buffer)
+		enc := NewEncoder(buf, layout)
+		enc.Type = TypeServer
+		bodies := []string{"type@=loginres/", "", "type@=chatmsg/txt@=弹幕/"}
+		for _, body := range bodies {
+			if err := enc.Encode([]byte(body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dec := NewDecoder(iotest.OneByteReader(buf), layout)
+		for _, body := range bodies {
+			got, err := dec.Decode()
+			if err != nil {
+				t.Fatalf("%s: %v", layout, err)
+			}
+			if string(got) != body {
+				t.Errorf("%s: Decode = %q, want %q", layout, got, body)
+			}
+		}
+		if _, err := dec.Decode(); err != io.EOF {
+			t.Errorf("%s: expect EOF, got %v", layout, err)
+		}
+	}
+}
+
+func TestLayoutHeader(t *testing.T) {
+	// 开放接口的服务器会在加密/保留字节里填非零值
+	data := rawFrame(12, 12, 0x00ff0000|690, "abc\x00")
+
+	if _, err := NewDecoder(bytes.NewReader(data), Open).Decode(); err != nil {
+		t.Errorf("open layout: %v", err)
+	}
+	_, err := NewDecoder(bytes.NewReader(data), Legacy).Decode()
+	if _, ok := err.(*TypeError); !ok {
+		t.Errorf("legacy layout: expect *TypeError, got %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := NewDecoder(bytes.NewReader(rawFrame(12, 13, 690, "abc\x00")), Open).Decode()
+	if _, ok := err.(*LengthMismatchError); !ok {
+		t.Errorf("expect *LengthMismatchError, got %v", err)
+	}
+
+	_, err = NewDecoder(bytes.NewReader(rawFrame(12, 12, 689, "abc\x00")), Open).Decode()
+	if _, ok := err.(*TypeError); !ok {
+		t.Errorf("expect *TypeError, got %v", err)
+	}
+
+	_, err = NewDecoder(bytes.NewReader(rawFrame(4, 4, 690, "")), Open).Decode()
+	if _, ok := err.(*SizeError); !ok {
+		t.Errorf("expect *SizeError, got %v", err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(rawFrame(100, 100, 690, "")), Open)
+	dec.MaxSize = 64
+	if _, err := dec.Decode(); err == nil {
+		t.Error("expect *SizeError")
+	} else if _, ok := err.(*SizeError); !ok {
+		t.Errorf("expect *SizeError, got %v", err)
+	}
+
+	_, err = NewDecoder(bytes.NewReader(rawFrame(100, 100, 690, "short")), Open).Decode()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expect io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	_, err = NewDecoder(bytes.NewReader([]byte{1, 2, 3}), Open).Decode()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expect io.ErrUnexpectedEOF, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package danmuku
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+const DefaultBacklogSize = 200
+
+// Hub 同时监听多个房间, 每个房间只保持一条连接, 所有房间的事件合并成一个
+// 事件流. Hub 还为每个房间保留最近的 backlogSize 个事件, 切换房间时可以
+// 直接显示. 房间用 RoomAddr 格式的地址区分, 不同平台的房间号可以相同.
+type Hub struct {
+	newSource   func(addr string) Source
+	backlogSize int
+	broadcaster *Broadcaster
+
+	mutex   sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped bool
+	rooms   map[string]*hubRoom
+	wg      sync.WaitGroup
+}
+
+type hubRoom struct {
+	room    Source
+	sub     *Subscription
+	refs    int
+	backlog []Event
+	next    int
+	full    bool
+}
+
+func (r *hubRoom) push(ev Event) {
+	if len(r.backlog) == 0 {
+		return
+	}
+	r.backlog[r.next] = ev
+	r.next = (r.next + 1) % len(r.backlog)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *hubRoom) events() []Event {
+	if !r.full {
+		return append([]Event(nil), r.backlog[:r.next]...)
+	}
+	return append(append([]Event(nil), r.backlog[r.next:]...), r.backlog[:r.next]...)
+}
+
+// NewHub 新建一个只有斗鱼房间的 Hub, transport 为 nil 时使用 DefaultTransport.
+// 房间地址可以只写房间号.
+func NewHub(transport Transport, backlogSize int) *Hub {
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	return NewHubWithSource(func(addr string) Source {
+		_, roomId, _ := ParseRoomAddr(addr)
+		return NewDanmukuRoomWithTransport(roomId, transport)
+	}, backlogSize)
+}
+
+// NewHubWithSource 新建一个 Hub, 用 newSource 创建每个地址的事件源, 比如
+// 用各个平台的 provider 或者存档回放. 事件源发出的事件的 Addr 应该和地址
+// 相同.
+func NewHubWithSource(newSource func(addr string) Source, backlogSize int) *Hub {
+	return &Hub{
+		newSource:   newSource,
+		backlogSize: backlogSize,
+		broadcaster: NewBroadcaster(),
+		rooms:       make(map[string]*hubRoom),
+	}
+}
+
+// Start 启动已经加入的所有房间, 之后加入的房间会立即启动.
+func (h *Hub) Start(ctx context.Context) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.stopped {
+		return ErrClosed
+	}
+	if h.ctx != nil {
+		return nil
+	}
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	for _, r := range h.rooms {
+		r.room.Start(h.ctx)
+	}
+	return nil
+}
+
+// Stop 停止所有房间并关闭合并的事件流.
+func (h *Hub) Stop() {
+	h.mutex.Lock()
+	if h.stopped {
+		h.mutex.Unlock()
+		return
+	}
+	h.stopped = true
+	rooms := h.rooms
+	h.rooms = make(map[string]*hubRoom)
+	if h.cancel != nil {
+		h.cancel()
+	}
+	h.mutex.Unlock()
+
+	for _, r := range rooms {
+		r.room.Stop()
+	}
+	h.wg.Wait()
+	h.broadcaster.Close()
+}
+
+// Add 加入一个房间. 同一个房间加入多次共用一条连接, 需要同样次数的 Remove
+// 才会断开.
+func (h *Hub) Add(addr string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.stopped {
+		return
+	}
+	if r, ok := h.rooms[addr]; ok {
+		r.refs++
+		return
+	}
+
+	room := h.newSource(addr)
+	r := &hubRoom{
+		room:    room,
+		sub:     room.Subscribe(nil, 64, Block),
+		refs:    1,
+		backlog: make([]Event, h.backlogSize),
+	}
+	h.rooms[addr] = r
+	h.wg.Add(1)
+	go h.forwardRoutine(r)
+	if h.ctx != nil {
+		room.Start(h.ctx)
+	}
+}
+
+func (h *Hub) Remove(addr string) {
+	h.mutex.Lock()
+	r, ok := h.rooms[addr]
+	if !ok {
+		h.mutex.Unlock()
+		return
+	}
+	r.refs--
+	if r.refs > 0 {
+		h.mutex.Unlock()
+		return
+	}
+	delete(h.rooms, addr)
+	h.mutex.Unlock()
+
+	r.room.Stop()
+}
+
+// Room 返回房间的事件源, 可以单独订阅.
+func (h *Hub) Room(addr string) Source {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if r, ok := h.rooms[addr]; ok {
+		return r.room
+	}
+	return nil
+}
+
+// Rooms 返回所有房间的地址, 按地址排序
+func (h *Hub) Rooms() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	addrs := make([]string, 0, len(h.rooms))
+	for addr := range h.rooms {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Backlog 返回房间最近的事件, 从旧到新.
+func (h *Hub) Backlog(addr string) []Event {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if r, ok := h.rooms[addr]; ok {
+		return r.events()
+	}
+	return nil
+}
+
+// Subscribe 订阅所有房间合并后的事件流, 用 Event.Addr 区分房间.
+func (h *Hub) Subscribe(filter Filter, bufferSize int, policy OverflowPolicy) *Subscription {
+	return h.broadcaster.Subscribe(filter, bufferSize, policy)
+}
+
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.broadcaster.Unsubscribe(s)
+}
+
+func (h *Hub) forwardRoutine(r *hubRoom) {
+	defer h.wg.Done()
+	for ev := range r.sub.Events() {
+		h.mutex.Lock()
+		r.push(ev)
+		ctx := h.ctx
+		h.mutex.Unlock()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		h.broadcaster.Publish(ctx, ev)
+	}
+}
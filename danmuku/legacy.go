@@ -0,0 +1,192 @@
+package danmuku
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/zwh8800/Love66/danmuku/frame"
+	"github.com/zwh8800/Love66/danmuku/stt"
+)
+
+const (
+	LegacyServer   = "danmu.douyutv.com:8601"
+	LegacyRoomPage = "http://www.douyutv.com/"
+)
+
+var errNoServerConfig = errors.New("danmuku: server_config not found in room page")
+
+// LegacyTransport 先从房间页面取 server_config, 向其中的服务器要 gid,
+// 再用假账号登录 danmu.douyutv.com 并加入该 gid 分组.
+type LegacyTransport struct {
+	Addr     string
+	RoomPage string
+}
+
+func NewLegacyTransport() *LegacyTransport {
+	return &LegacyTransport{LegacyServer, LegacyRoomPage}
+}
+
+func (t *LegacyTransport) Dial(ctx context.Context, roomId int) (*Conn, error) {
+	roomHtml, err := t.getHtml(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := parseServerConfig(roomHtml)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: dialTimeout}
+	gidNetConn, err := dialer.DialContext(ctx, "tcp", sc[0].IP+":"+sc[0].Port)
+	if err != nil {
+		return nil, err
+	}
+	gidConn := newConn(gidNetConn, frame.Legacy)
+	defer gidConn.Close()
+	release := CloseOnDone(ctx, gidConn)
+	gid, err := getGid(gidConn, roomId)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	netConn, err := dialer.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := newConn(netConn, frame.Legacy)
+	conn.rewrite = legacyToOpen
+
+	loginReq := stt.NewMessage("loginreq",
+		"username", "auto_KRLJbE8mZM",
+		"password", "1234567890123456",
+		"roomid", strconv.Itoa(roomId),
+	)
+	if err := conn.WriteMessage(loginReq); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	joinGroup := stt.NewMessage("joingroup",
+		"rid", strconv.Itoa(roomId),
+		"gid", strconv.Itoa(gid),
+	)
+	if err := conn.WriteMessage(joinGroup); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *LegacyTransport) getHtml(ctx context.Context, roomId int) (string, error) {
+	req, err := http.NewRequest("GET", t.RoomPage+strconv.Itoa(roomId), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+type serverConfig []struct {
+	IP   string `json:"ip"`
+	Port string `json:"port"`
+}
+
+func parseServerConfig(html string) (serverConfig, error) {
+	regex := regexp.MustCompile(`"server_config":"(.*?)"`)
+	submatch := regex.FindStringSubmatch(html)
+	if submatch == nil {
+		return nil, errNoServerConfig
+	}
+
+	jsonData, err := url.QueryUnescape(submatch[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var sc serverConfig
+	if err := json.Unmarshal([]byte(jsonData), &sc); err != nil {
+		return nil, err
+	}
+	if len(sc) == 0 {
+		return nil, errNoServerConfig
+	}
+	return sc, nil
+}
+
+func getGid(conn *Conn, roomId int) (int, error) {
+	devId := strings.ToUpper(strings.Replace(uuid.NewV4().String(), "-", "", -1))
+	rt := strconv.Itoa(int(time.Now().Unix()))
+	magic := "7oE9nPEG9xXV69phU31FYCLUagKeYtsF"
+	sumArr := md5.Sum([]byte(rt + magic + devId))
+	sum := sumArr[:]
+	vk := hex.EncodeToString(sum)
+
+	loginReq := stt.NewMessage("loginreq",
+		"username", "",
+		"password", "",
+		"roomid", strconv.Itoa(roomId),
+		"ct", "0",
+		"devid", devId,
+		"rt", rt,
+		"vk", vk,
+		"ver", "20150929",
+	)
+
+	if err := conn.WriteMessage(loginReq); err != nil {
+		return 0, err
+	}
+
+	msg, err := conn.waitFor("setmsggroup", loginTimeout)
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.ParseInt(msg.Get("gid"), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int(gid), nil
+}
+
+// legacyToOpen 把旧版服务器的 chatmessage 改写成开放接口的 chatmsg 字段名
+func legacyToOpen(msg stt.Message) stt.Message {
+	if msg.Type() != "chatmessage" {
+		return msg
+	}
+	result := make(stt.Message, 0, len(msg))
+	for _, f := range msg {
+		switch f.Key {
+		case "type":
+			f.Value = "chatmsg"
+		case "snick":
+			f.Key = "nn"
+		case "content":
+			f.Key = "txt"
+		case "sender":
+			f.Key = "uid"
+		case "chatmsgid":
+			f.Key = "cid"
+		}
+		result = append(result, f)
+	}
+	return result
+}
@@ -0,0 +1,15 @@
+package danmuku
+
+import "context"
+
+// Source 是一个房间的事件源. 直播的 DanmukuRoom 和存档回放都实现了它,
+// 生命周期和 DanmukuRoom 相同: 只能启动一次, 结束后关闭所有订阅和 Done.
+type Source interface {
+	RoomId() int
+	Start(ctx context.Context) error
+	Stop()
+	Done() <-chan struct{}
+	Err() error
+	Subscribe(filter Filter, bufferSize int, policy OverflowPolicy) *Subscription
+	Unsubscribe(s *Subscription)
+}
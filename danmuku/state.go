@@ -0,0 +1,52 @@
+package danmuku
+
+import "fmt"
+
+type State int
+
+const (
+	StateIdle State = iota
+	StateConnecting
+	StateConnected
+	StateRetrying
+	StateFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateRetrying:
+		return "retrying"
+	case StateFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// StateChange 在连接状态变化时和弹幕一起发出. Attempt 是连续失败的次数,
+// Err 是导致断开或重试的错误.
+type StateChange struct {
+	RoomId int
+	// Scheme 是房间所属的平台, 为空时是 DefaultScheme
+	Scheme  string
+	State   State
+	Attempt int
+	Err     error
+}
+
+func (e *StateChange) EventType() string {
+	return "connstate"
+}
+
+func (e *StateChange) Room() int {
+	return e.RoomId
+}
+
+func (e *StateChange) Addr() string {
+	return RoomAddr(e.Scheme, e.RoomId)
+}
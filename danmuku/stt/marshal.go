@@ -0,0 +1,320 @@
+package stt
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Marshal 把 v 编码为 STT 文本.
+//
+// 字典 (map, struct, Message) 编码为 key@=value/, 列表 (slice, array) 编码为
+// item/, 标量直接编码为字符串. struct 字段按声明顺序输出, 可以用
+// `stt:"name,omitempty"` 指定键名, `stt:"-"` 忽略字段. map 按键排序输出,
+// 但 "type" 总在最前面.
+func Marshal(v interface{}) ([]byte, error) {
+	s, err := encode(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+// Unmarshal 把 STT 文本解码到 v 指向的值中, 与 Marshal 对称.
+// 数值字段遇到空串时解码为零值, 未知的键会被忽略.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("stt: Unmarshal(non-pointer %T)", v)
+	}
+	return decode(string(data), rv.Elem())
+}
+
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "stt: unsupported type: " + e.Type.String()
+}
+
+type UnmarshalTypeError struct {
+	Value string
+	Type  reflect.Type
+	Err   error
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("stt: cannot unmarshal %q into %s: %v", e.Value, e.Type, e.Err)
+}
+
+var messageType = reflect.TypeOf(Message(nil))
+
+func encode(v reflect.Value) (string, error) {
+	if !v.IsValid() {
+		return "", nil
+	}
+	if v.Type() == messageType {
+		return v.Interface().(Message).String(), nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "", nil
+		}
+		return encode(v.Elem())
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return "1", nil
+		}
+		return "0", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), nil
+		}
+		var buf strings.Builder
+		for i := 0; i < v.Len(); i++ {
+			item, err := encode(v.Index(i))
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(Escape(item))
+			buf.WriteByte('/')
+		}
+		return buf.String(), nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return "", &UnsupportedTypeError{v.Type()}
+		}
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i] == "type" || keys[j] == "type" {
+				return keys[i] == "type"
+			}
+			return keys[i] < keys[j]
+		})
+		msg := make(Message, 0, len(keys))
+		for _, k := range keys {
+			value, err := encode(v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())))
+			if err != nil {
+				return "", err
+			}
+			msg = append(msg, Field{k, value})
+		}
+		return msg.String(), nil
+	case reflect.Struct:
+		msg := make(Message, 0, v.NumField())
+		for _, f := range structFields(v.Type()) {
+			fv := v.FieldByIndex(f.index)
+			if f.omitEmpty && isEmpty(fv) {
+				continue
+			}
+			value, err := encode(fv)
+			if err != nil {
+				return "", err
+			}
+			msg = append(msg, Field{f.name, value})
+		}
+		return msg.String(), nil
+	}
+	return "", &UnsupportedTypeError{v.Type()}
+}
+
+func decode(s string, v reflect.Value) error {
+	if v.Type() == messageType {
+		msg, err := ParseMessage(s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(msg))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decode(s, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return &UnsupportedTypeError{v.Type()}
+		}
+		v.Set(reflect.ValueOf(s))
+		return nil
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		switch s {
+		case "", "0", "false":
+			v.SetBool(false)
+		default:
+			v.SetBool(true)
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if s == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return &UnmarshalTypeError{s, v.Type(), err}
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if s == "" {
+			v.SetUint(0)
+			return nil
+		}
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return &UnmarshalTypeError{s, v.Type(), err}
+		}
+		v.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if s == "" {
+			v.SetFloat(0)
+			return nil
+		}
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return &UnmarshalTypeError{s, v.Type(), err}
+		}
+		v.SetFloat(n)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+		items := ParseList(s)
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decode(item, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Array:
+		items := ParseList(s)
+		for i := 0; i < v.Len(); i++ {
+			if i >= len(items) {
+				v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+				continue
+			}
+			if err := decode(items[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return &UnsupportedTypeError{v.Type()}
+		}
+		msg, err := ParseMessage(s)
+		if err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), len(msg)))
+		}
+		for _, f := range msg {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decode(f.Value, elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(f.Key).Convert(v.Type().Key()), elem)
+		}
+		return nil
+	case reflect.Struct:
+		msg, err := ParseMessage(s)
+		if err != nil {
+			return err
+		}
+		fields := structFields(v.Type())
+		for _, f := range msg {
+			for _, sf := range fields {
+				if sf.name == f.Key {
+					if err := decode(f.Value, v.FieldByIndex(sf.index)); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+		return nil
+	}
+	return &UnsupportedTypeError{v.Type()}
+}
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields 列出 t 的可编码字段, 匿名嵌入且没有 tag 的 struct 会被展开.
+func structFields(t reflect.Type) []field {
+	fields := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("stt")
+		if tag == "-" {
+			continue
+		}
+		if sf.Anonymous && tag == "" && sf.Type.Kind() == reflect.Struct {
+			for _, f := range structFields(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{name, []int{i}, opts == "omitempty"})
+	}
+	return fields
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
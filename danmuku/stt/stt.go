@@ -0,0 +1,147 @@
+package stt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// STT 是斗鱼弹幕协议使用的序列化格式:
+//
+//	key1@=value1/key2@=value2/
+//
+// 键和值中的 "@" 转义为 "@A", "/" 转义为 "@S".
+// 列表写作 item1/item2/, 嵌套的列表或字典整体转义后作为值.
+
+var (
+	escaper   = strings.NewReplacer("@", "@A", "/", "@S")
+	unescaper = strings.NewReplacer("@A", "@", "@S", "/")
+)
+
+func Escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func Unescape(s string) string {
+	return unescaper.Replace(s)
+}
+
+type SyntaxError struct {
+	Msg   string
+	Token string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("stt: %s: %q", e.Msg, e.Token)
+}
+
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Message 是保持键顺序的一层 STT 字典, 值为未转义的原文.
+type Message []Field
+
+func (m Message) Get(key string) string {
+	for _, f := range m {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func (m Message) Has(key string) bool {
+	for _, f := range m {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Message) Set(key, value string) {
+	for i, f := range *m {
+		if f.Key == key {
+			(*m)[i].Value = value
+			return
+		}
+	}
+	*m = append(*m, Field{key, value})
+}
+
+func (m *Message) Del(key string) {
+	msg := (*m)[:0]
+	for _, f := range *m {
+		if f.Key != key {
+			msg = append(msg, f)
+		}
+	}
+	*m = msg
+}
+
+func (m Message) Type() string {
+	return m.Get("type")
+}
+
+func (m Message) Map() map[string]string {
+	result := make(map[string]string, len(m))
+	for _, f := range m {
+		result[f.Key] = f.Value
+	}
+	return result
+}
+
+func (m Message) String() string {
+	var buf strings.Builder
+	for _, f := range m {
+		buf.WriteString(Escape(f.Key))
+		buf.WriteString("@=")
+		buf.WriteString(Escape(f.Value))
+		buf.WriteByte('/')
+	}
+	return buf.String()
+}
+
+func NewMessage(typ string, kv ...string) Message {
+	msg := Message{{"type", typ}}
+	for i := 0; i+1 < len(kv); i += 2 {
+		msg.Set(kv[i], kv[i+1])
+	}
+	return msg
+}
+
+// ParseMessage 解析一层字典, 允许末尾缺少 "/" 或带有 '\0'.
+func ParseMessage(s string) (Message, error) {
+	msg := make(Message, 0)
+	for _, token := range split(s) {
+		if token == "" {
+			continue
+		}
+		i := strings.Index(token, "@=")
+		if i < 0 {
+			return nil, &SyntaxError{"missing @= in field", token}
+		}
+		msg = append(msg, Field{Unescape(token[:i]), Unescape(token[i+2:])})
+	}
+	return msg, nil
+}
+
+// ParseList 解析一层列表.
+func ParseList(s string) []string {
+	tokens := split(s)
+	list := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		list = append(list, Unescape(token))
+	}
+	return list
+}
+
+func split(s string) []string {
+	s = strings.TrimRight(s, "\x00")
+	tokens := strings.Split(s, "/")
+	if len(tokens) > 0 && tokens[len(tokens)-1] == "" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return tokens
+}
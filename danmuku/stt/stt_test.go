@@ -0,0 +1,202 @@
+package stt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	cases := map[string]string{
+		"":       "",
+		"abc":    "abc",
+		"a/b":    "a@Sb",
+		"a@b":    "a@Ab",
+		"@=/":    "@A=@S",
+		"@S":     "@AS",
+		"k@=v/":  "k@A=v@S",
+		"弹幕@/测试": "弹幕@A@S测试",
+	}
+	for raw, escaped := range cases {
+		if got := Escape(raw); got != escaped {
+			t.Errorf("Escape(%q) = %q, want %q", raw, got, escaped)
+		}
+		if got := Unescape(escaped); got != raw {
+			t.Errorf("Unescape(%q) = %q, want %q", escaped, got, raw)
+		}
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	msg, err := ParseMessage("type@=chatmsg/rid@=156277/txt@=a@Sb@Ac/nn@=/\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Message{
+		{"type", "chatmsg"},
+		{"rid", "156277"},
+		{"txt", "a/b@c"},
+		{"nn", ""},
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("ParseMessage = %#v, want %#v", msg, want)
+	}
+	if msg.Type() != "chatmsg" {
+		t.Errorf("Type() = %q", msg.Type())
+	}
+
+	if _, err := ParseMessage("type@=chatmsg/garbage/"); err == nil {
+		t.Error("expect syntax error")
+	}
+
+	msg, err = ParseMessage("type@=keeplive/tick@=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Get("tick") != "1" {
+		t.Errorf("missing trailing slash: %#v", msg)
+	}
+}
+
+func TestMessageOrder(t *testing.T) {
+	msg := NewMessage("loginreq", "roomid", "156277")
+	msg.Set("ct", "0")
+	msg.Set("roomid", "3258")
+	if got, want := msg.String(), "type@=loginreq/roomid@=3258/ct@=0/"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	msg.Del("ct")
+	if got, want := msg.String(), "type@=loginreq/roomid@=3258/"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestMarshalMap(t *testing.T) {
+	data, err := Marshal(map[string]string{
+		"rid":  "1",
+		"type": "joingroup",
+		"gid":  "-9999",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "type@=joingroup/gid@=-9999/rid@=1/"; got != want {
+		t.Errorf("Marshal = %q, want %q", got, want)
+	}
+}
+
+type rankItem struct {
+	Uid  int    `stt:"uid"`
+	Nick string `stt:"nickname"`
+	Gold int64  `stt:"gold"`
+}
+
+type header struct {
+	Type string `stt:"type"`
+	Rid  int    `stt:"rid"`
+}
+
+type rankList struct {
+	header
+	Ts      int64      `stt:"ts"`
+	List    []rankItem `stt:"list"`
+	Tags    []string   `stt:"tags,omitempty"`
+	Extra   string     `stt:"extra,omitempty"`
+	Ignored string     `stt:"-"`
+	Online  bool       `stt:"online"`
+}
+
+func TestStructRoundTrip(t *testing.T) {
+	in := rankList{
+		header: header{"ranklist", 156277},
+		Ts:     1500000000,
+		List: []rankItem{
+			{1, "a/b", 100},
+			{2, "c@d", 50},
+		},
+		Tags:    []string{"x", "y/z", ""},
+		Ignored: "nope",
+		Online:  true,
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "type@=ranklist/rid@=156277/ts@=1500000000/" +
+		"list@=uid@AA=1@AS" + "nickname@AA=a@AAS" + "b@AS" + "gold@AA=100@AS@S" +
+		"uid@AA=2@AS" + "nickname@AA=c@AAAd@AS" + "gold@AA=50@AS@S/" +
+		"tags@=x@Sy@ASz@S@S/online@=1/"
+	if string(data) != want {
+		t.Errorf("Marshal =\n%q\nwant\n%q", data, want)
+	}
+
+	var out rankList
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Ignored = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch:\n%#v\n%#v", in, out)
+	}
+}
+
+func TestUnmarshalNested(t *testing.T) {
+	var v struct {
+		Type string              `stt:"type"`
+		List []map[string]string `stt:"list"`
+		Any  interface{}         `stt:"any"`
+		Ptr  *rankItem           `stt:"ptr"`
+		Arr  [2]int              `stt:"arr"`
+	}
+	data := "type@=ranklist/list@=uid@AA=1@ASnn@AA=x@AS@Suid@AA=2@AS@S/any@=raw/ptr@=uid@A=7/arr@=3@S/"
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.List) != 2 || v.List[0]["nn"] != "x" || v.List[1]["uid"] != "2" {
+		t.Errorf("List = %#v", v.List)
+	}
+	if v.Any != "raw" {
+		t.Errorf("Any = %#v", v.Any)
+	}
+	if v.Ptr == nil || v.Ptr.Uid != 7 {
+		t.Errorf("Ptr = %#v", v.Ptr)
+	}
+	if v.Arr != [2]int{3, 0} {
+		t.Errorf("Arr = %#v", v.Arr)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var v struct {
+		Level int `stt:"level"`
+	}
+	if err := Unmarshal([]byte("level@=abc/"), &v); err == nil {
+		t.Error("expect type error")
+	}
+	if err := Unmarshal([]byte("level@=/"), &v); err != nil || v.Level != 0 {
+		t.Errorf("empty number: %v, %d", err, v.Level)
+	}
+	if err := Unmarshal([]byte("level@=1/"), v); err == nil {
+		t.Error("expect non-pointer error")
+	}
+}
+
+func TestRoundTripMessage(t *testing.T) {
+	msgs := []string{
+		"type@=chatmsg/rid@=1/txt@=@A@S@A@S/",
+		"type@=dgb/list@=a@Sb@S/",
+		"",
+	}
+	for _, s := range msgs {
+		var msg Message
+		if err := Unmarshal([]byte(s), &msg); err != nil {
+			t.Fatal(err)
+		}
+		data, err := Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != s {
+			t.Errorf("round trip %q -> %q", s, data)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package danmuku
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku/frame"
+	"github.com/zwh8800/Love66/danmuku/stt"
+)
+
+const (
+	OpenBarrageServer = "openbarrage.douyutv.com:8601"
+	dialTimeout       = 10 * time.Second
+	loginTimeout      = 10 * time.Second
+)
+
+var ErrLoginFailed = errors.New("danmuku: login failed")
+
+// Transport 负责连接弹幕服务器并完成登录和入组, 返回可以直接收发消息的连接.
+type Transport interface {
+	Dial(ctx context.Context, roomId int) (*Conn, error)
+}
+
+// DefaultTransport 是 NewDanmukuRoom 使用的 Transport.
+var DefaultTransport Transport = &OpenBarrageTransport{OpenBarrageServer}
+
+type Conn struct {
+	net.Conn
+	encoder *frame.Encoder
+	decoder *frame.Decoder
+	rewrite func(stt.Message) stt.Message
+}
+
+func newConn(conn net.Conn, layout frame.Layout) *Conn {
+	return &Conn{
+		conn,
+		frame.NewEncoder(conn, layout),
+		frame.NewDecoder(conn, layout),
+		nil,
+	}
+}
+
+func (c *Conn) WriteMessage(msg stt.Message) error {
+	return c.encoder.Encode([]byte(msg.String()))
+}
+
+func (c *Conn) ReadMessage() (stt.Message, error) {
+	data, err := c.decoder.Decode()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := stt.ParseMessage(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if c.rewrite != nil {
+		msg = c.rewrite(msg)
+	}
+	return msg, nil
+}
+
+func (c *Conn) KeepAlive() error {
+	return c.WriteMessage(stt.NewMessage("keeplive",
+		"tick", strconv.FormatInt(time.Now().Unix(), 10),
+	))
+}
+
+// waitFor 读消息直到出现 type@=typ, 其余消息丢弃.
+func (c *Conn) waitFor(typ string, timeout time.Duration) (stt.Message, error) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	defer c.SetReadDeadline(time.Time{})
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Type() {
+		case typ:
+			return msg, nil
+		case "error":
+			return nil, ErrLoginFailed
+		}
+	}
+}
+
+// OpenBarrageTransport 连接斗鱼第三方开放弹幕接口, 不需要 gid 和账号.
+type OpenBarrageTransport struct {
+	Addr string
+}
+
+func (t *OpenBarrageTransport) Dial(ctx context.Context, roomId int) (*Conn, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := newConn(netConn, frame.Open)
+	release := CloseOnDone(ctx, conn)
+	defer release()
+	if err := openBarrageLogin(conn, roomId); err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+func openBarrageLogin(conn *Conn, roomId int) error {
+	rid := strconv.Itoa(roomId)
+	if err := conn.WriteMessage(stt.NewMessage("loginreq", "roomid", rid)); err != nil {
+		return err
+	}
+	if _, err := conn.waitFor("loginres", loginTimeout); err != nil {
+		return err
+	}
+	return conn.WriteMessage(stt.NewMessage("joingroup", "rid", rid, "gid", "-9999"))
+}
@@ -0,0 +1,82 @@
+package emote
+
+import "regexp"
+
+// Emote 是一个斗鱼表情, 弹幕里写作 [emot:dy101]
+type Emote struct {
+	Emoji string
+	Name  string
+}
+
+// Table 是表情代码到表情的对应表
+var Table = map[string]Emote{
+	"dy101": {"😄", "开心"},
+	"dy102": {"😍", "喜欢"},
+	"dy103": {"😎", "酷"},
+	"dy104": {"😭", "大哭"},
+	"dy105": {"😡", "生气"},
+	"dy106": {"😱", "惊讶"},
+	"dy107": {"😏", "坏笑"},
+	"dy108": {"😴", "睡觉"},
+	"dy109": {"🤔", "疑问"},
+	"dy110": {"😓", "汗"},
+	"dy111": {"😂", "笑哭"},
+	"dy112": {"👍", "赞"},
+	"dy113": {"👏", "鼓掌"},
+	"dy114": {"🙏", "拜托"},
+	"dy115": {"💪", "加油"},
+	"dy116": {"❤", "爱心"},
+	"dy117": {"💔", "心碎"},
+	"dy118": {"🌹", "玫瑰"},
+	"dy119": {"🐟", "鱼丸"},
+	"dy120": {"🚀", "火箭"},
+	"dy121": {"🤮", "吐"},
+	"dy122": {"😘", "亲亲"},
+	"dy123": {"🐶", "单身狗"},
+	"dy124": {"🍉", "吃瓜"},
+	"dy125": {"6⃣", "666"},
+}
+
+// Style 决定表情翻译成什么
+type Style int
+
+const (
+	// StyleEmoji 翻译成 Unicode 表情, 终端里显示最紧凑
+	StyleEmoji Style = iota
+	// StyleText 翻译成 [开心] 这样的文字, 用在不支持 emoji 的字体里
+	StyleText
+)
+
+// Unknown 是未知表情的文字
+const Unknown = "[表情]"
+
+var pattern = regexp.MustCompile(`\[emot:([0-9A-Za-z_]+)\]`)
+
+type Translator struct {
+	Style Style
+	Table map[string]Emote
+}
+
+var (
+	Emoji = &Translator{StyleEmoji, Table}
+	Text  = &Translator{StyleText, Table}
+)
+
+// Translate 把弹幕里的表情代码换成表情, 不认识的代码换成 Unknown.
+func (t *Translator) Translate(text string) string {
+	return pattern.ReplaceAllStringFunc(text, func(code string) string {
+		e, ok := t.Table[pattern.FindStringSubmatch(code)[1]]
+		if !ok {
+			return Unknown
+		}
+		if t.Style == StyleEmoji && e.Emoji != "" {
+			return e.Emoji
+		}
+		return "[" + e.Name + "]"
+	})
+}
+
+// Translate 用 Emoji 翻译表情
+func Translate(text string) string {
+	return Emoji.Translate(text)
+}
@@ -0,0 +1,29 @@
+package emote
+
+import "testing"
+
+func TestTranslate(t *testing.T) {
+	cases := []struct {
+		text  string
+		emoji string
+		tag   string
+	}{
+		{"hello", "hello", "hello"},
+		{"主播[emot:dy101][emot:dy112]", "主播😄👍", "主播[开心][赞]"},
+		{"[emot:dy999] 什么", "[表情] 什么", "[表情] 什么"},
+		{"[emot:] [emot", "[emot:] [emot", "[emot:] [emot"},
+	}
+	for _, c := range cases {
+		if s := Translate(c.text); s != c.emoji {
+			t.Errorf("%q: expected %q, got %q", c.text, c.emoji, s)
+		}
+		if s := Text.Translate(c.text); s != c.tag {
+			t.Errorf("%q: expected %q, got %q", c.text, c.tag, s)
+		}
+	}
+
+	tr := &Translator{StyleEmoji, map[string]Emote{"x": {"", "没有表情"}}}
+	if s := tr.Translate("[emot:x]"); s != "[没有表情]" {
+		t.Error("expected text fallback, got", s)
+	}
+}
@@ -0,0 +1,177 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type ASSOptions struct {
+	Width          int
+	Height         int
+	FontName       string
+	FontSize       int
+	ScrollDuration time.Duration
+	FixedDuration  time.Duration
+}
+
+var DefaultASSOptions = ASSOptions{
+	Width:          1920,
+	Height:         1080,
+	FontName:       "Microsoft YaHei",
+	FontSize:       48,
+	ScrollDuration: 8 * time.Second,
+	FixedDuration:  4 * time.Second,
+}
+
+// placement 是一条弹幕在屏幕上的行和显示时间
+type placement struct {
+	lane  int
+	width int
+	start time.Duration
+	end   time.Duration
+}
+
+// lanes 给同一种模式的弹幕分配行. 滚动弹幕只有在前一条完全进入屏幕,
+// 并且到达左边缘前不会追上前一条时才放进同一行; 顶部和底部弹幕要等前一条
+// 消失. 没有空闲的行时选最早空出来的行, 弹幕会有部分重叠.
+type lanes struct {
+	opts ASSOptions
+	last []*placement
+}
+
+func newLanes(opts ASSOptions) *lanes {
+	n := opts.Height / opts.FontSize
+	if n < 1 {
+		n = 1
+	}
+	return &lanes{opts, make([]*placement, n)}
+}
+
+func (l *lanes) speed(width int) float64 {
+	return float64(l.opts.Width+width) / l.opts.ScrollDuration.Seconds()
+}
+
+// freeAt 返回 lane 可以放下一条宽 width 的弹幕的最早时间
+func (l *lanes) freeAt(lane int, mode Mode, width int) time.Duration {
+	prev := l.last[lane]
+	if prev == nil {
+		return 0
+	}
+	if mode != ModeScroll {
+		return prev.end
+	}
+	entered := prev.start + seconds(float64(prev.width)/l.speed(prev.width))
+	cleared := prev.end - seconds(float64(l.opts.Width)/l.speed(width))
+	if entered > cleared {
+		return entered
+	}
+	return cleared
+}
+
+func (l *lanes) place(t time.Duration, mode Mode, width int) *placement {
+	duration := l.opts.FixedDuration
+	if mode == ModeScroll {
+		duration = l.opts.ScrollDuration
+	}
+	best, bestFree := 0, time.Duration(-1)
+	for i := range l.last {
+		free := l.freeAt(i, mode, width)
+		if free <= t {
+			best = i
+			break
+		}
+		if bestFree < 0 || free < bestFree {
+			best, bestFree = i, free
+		}
+	}
+	p := &placement{best, width, t, t + duration}
+	l.last[best] = p
+	return p
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// textWidth 估计文字的宽度, 全角字符按一个字号, 其它按半个字号.
+func textWidth(text string, fontSize int) int {
+	width := 0
+	for _, r := range text {
+		if r >= 0x1100 {
+			width += fontSize
+		} else {
+			width += fontSize / 2
+		}
+	}
+	return width
+}
+
+func assTime(d time.Duration) string {
+	cs := int64(d / (10 * time.Millisecond))
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+var assEscaper = strings.NewReplacer(
+	"\\", "＼",
+	"{", "｛",
+	"}", "｝",
+	"\r\n", " ",
+	"\n", " ",
+)
+
+// WriteASS 输出 ASS 字幕. 滚动弹幕从右向左移动, 顶部和底部弹幕居中显示,
+// 每种模式各自分行避免重叠.
+func WriteASS(w io.Writer, comments []Comment, opts ASSOptions) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "[Script Info]\n")
+	fmt.Fprint(bw, "ScriptType: v4.00+\n")
+	fmt.Fprintf(bw, "PlayResX: %d\n", opts.Width)
+	fmt.Fprintf(bw, "PlayResY: %d\n", opts.Height)
+	fmt.Fprint(bw, "WrapStyle: 2\n")
+	fmt.Fprint(bw, "ScaledBorderAndShadow: yes\n\n")
+	fmt.Fprint(bw, "[V4+ Styles]\n")
+	fmt.Fprint(bw, "Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, "+
+		"Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, "+
+		"Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	fmt.Fprintf(bw, "Style: Danmaku,%s,%d,&H00FFFFFF,&H00FFFFFF,&H00000000,&H00000000,"+
+		"0,0,0,0,100,100,0,0,1,2,0,7,0,0,0,1\n\n", opts.FontName, opts.FontSize)
+	fmt.Fprint(bw, "[Events]\n")
+	fmt.Fprint(bw, "Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+
+	layouts := map[Mode]*lanes{
+		ModeScroll: newLanes(opts),
+		ModeTop:    newLanes(opts),
+		ModeBottom: newLanes(opts),
+	}
+	for _, c := range comments {
+		l, ok := layouts[c.Mode]
+		if !ok {
+			continue
+		}
+		text := assEscaper.Replace(c.Text)
+		width := textWidth(text, opts.FontSize)
+		p := l.place(c.Time, c.Mode, width)
+
+		var pos string
+		switch c.Mode {
+		case ModeScroll:
+			y := p.lane * opts.FontSize
+			pos = fmt.Sprintf(`\move(%d,%d,%d,%d)`, opts.Width, y, -width, y)
+		case ModeTop:
+			pos = fmt.Sprintf(`\an8\pos(%d,%d)`, opts.Width/2, p.lane*opts.FontSize)
+		case ModeBottom:
+			pos = fmt.Sprintf(`\an8\pos(%d,%d)`, opts.Width/2, opts.Height-(p.lane+1)*opts.FontSize)
+		}
+		color := ""
+		if c.Color != 0xffffff {
+			// ASS 的颜色是 BGR
+			color = fmt.Sprintf(`\c&H%02X%02X%02X&`, c.Color&0xff, c.Color>>8&0xff, c.Color>>16&0xff)
+		}
+		fmt.Fprintf(bw, "Dialogue: 2,%s,%s,Danmaku,,0000,0000,0000,,{%s%s}%s\n",
+			assTime(p.start), assTime(p.end), pos, color, text)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,95 @@
+package export
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zwh8800/Love66/archive"
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/emote"
+)
+
+// Mode 是弹幕的位置, 取值和 Bilibili 的 mode 相同
+type Mode int
+
+const (
+	ModeScroll Mode = 1
+	ModeBottom Mode = 4
+	ModeTop    Mode = 5
+)
+
+// Comment 是一条要叠加到视频上的弹幕
+type Comment struct {
+	// Time 是相对视频开头的时间
+	Time  time.Duration
+	Date  time.Time
+	Mode  Mode
+	Text  string
+	Color uint32
+	Uid   int
+}
+
+type Options struct {
+	// Offset 加到每条弹幕的时间上. 默认第一条记录对应视频开头, 录像比弹幕
+	// 晚开始时用负数, 早开始时用正数. 调整后时间为负的弹幕被丢弃.
+	Offset time.Duration
+	// GiftName 返回礼物的名字, 为 nil 时不导出礼物
+	GiftName func(giftId string) string
+	// Translate 翻译弹幕里的表情, 为 nil 时使用 emote.Text, 因为字幕字体
+	// 通常不支持 emoji
+	Translate func(text string) string
+}
+
+// Comments 把存档记录转换成弹幕: 聊天消息滚动, 礼物在底部, 超级礼物广播在顶部.
+// records 需要按时间排序.
+func Comments(records []*archive.Record, opts Options) []Comment {
+	comments := make([]Comment, 0, len(records))
+	if len(records) == 0 {
+		return comments
+	}
+	start := records[0].Time
+	translate := opts.Translate
+	if translate == nil {
+		translate = emote.Text.Translate
+	}
+	for _, rec := range records {
+		t := rec.Time.Sub(start) + opts.Offset
+		if t < 0 {
+			continue
+		}
+		ev, err := rec.Decode()
+		if err != nil {
+			continue
+		}
+		c := Comment{Time: t, Date: rec.Time, Color: 0xffffff}
+		switch ev := ev.(type) {
+		case *danmuku.ChatMessage:
+			c.Mode = ModeScroll
+			c.Text = translate(ev.Text)
+			c.Color = ev.Color.RGB()
+			c.Uid = ev.Uid
+		case *danmuku.Gift:
+			if opts.GiftName == nil {
+				continue
+			}
+			count := ev.Count
+			if count == 0 {
+				count = 1
+			}
+			c.Mode = ModeBottom
+			c.Text = fmt.Sprintf("%s 送出 %s ×%d", ev.Nickname, opts.GiftName(ev.GiftId), count)
+			c.Uid = ev.Uid
+		case *danmuku.SuperBroadcast:
+			c.Mode = ModeTop
+			c.Text = fmt.Sprintf("%s 送给 %s %s ×%d", ev.Sender, ev.Receiver, ev.GiftName, ev.GiftCount)
+			c.Color = danmuku.ColorYellow.RGB()
+		default:
+			continue
+		}
+		if c.Text == "" {
+			continue
+		}
+		comments = append(comments, c)
+	}
+	return comments
+}
@@ -0,0 +1,123 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zwh8800/Love66/archive"
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+func record(t *testing.T, tm time.Time, ev danmuku.Event) *archive.Record {
+	rec, err := archive.NewRecord(tm, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestComments(t *testing.T) {
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.Local)
+	records := []*archive.Record{
+		record(t, start, &danmuku.ChatMessage{Header: danmuku.Header{Type: "chatmsg"}, Text: "early"}),
+		record(t, start.Add(3*time.Second), &danmuku.ChatMessage{
+			Header: danmuku.Header{Type: "chatmsg"}, Uid: 1, Text: "red[emot:dy101]", Color: danmuku.ColorRed,
+		}),
+		record(t, start.Add(4*time.Second), &danmuku.Gift{
+			Header: danmuku.Header{Type: "dgb"}, Nickname: "a", GiftId: "824",
+		}),
+		record(t, start.Add(5*time.Second), &danmuku.SuperBroadcast{
+			Header: danmuku.Header{Type: "spbc"}, Sender: "a", Receiver: "b", GiftName: "火箭", GiftCount: 1,
+		}),
+	}
+
+	comments := Comments(records, Options{Offset: -2 * time.Second})
+	if len(comments) != 2 {
+		t.Fatal("unexpected comments", comments)
+	}
+	if c := comments[0]; c.Time != time.Second || c.Mode != ModeScroll || c.Color != 0xff0000 || c.Text != "red[开心]" {
+		t.Error("unexpected chat", c)
+	}
+	if c := comments[1]; c.Mode != ModeTop || c.Text != "a 送给 b 火箭 ×1" {
+		t.Error("unexpected spbc", c)
+	}
+
+	comments = Comments(records, Options{GiftName: func(string) string { return "鱼丸" }})
+	if len(comments) != 4 || comments[2].Mode != ModeBottom || comments[2].Text != "a 送出 鱼丸 ×1" {
+		t.Error("unexpected gift", comments)
+	}
+}
+
+func TestWriteXML(t *testing.T) {
+	comments := []Comment{{
+		Time:  1500 * time.Millisecond,
+		Date:  time.Unix(1488340800, 0),
+		Mode:  ModeScroll,
+		Text:  "<666>",
+		Color: 0xff0000,
+		Uid:   1,
+	}}
+	var buf bytes.Buffer
+	if err := WriteXML(&buf, comments); err != nil {
+		t.Fatal(err)
+	}
+	want := `<d p="1.50000,1,25,16711680,1488340800,0,83dcefb7,1">&lt;666&gt;</d>`
+	if !strings.Contains(buf.String(), want) {
+		t.Error("unexpected xml", buf.String())
+	}
+}
+
+func TestLanes(t *testing.T) {
+	opts := DefaultASSOptions
+	l := newLanes(opts)
+	width := textWidth("弹幕弹幕", opts.FontSize)
+	if width != 4*opts.FontSize {
+		t.Error("unexpected width", width)
+	}
+	if p := l.place(0, ModeScroll, width); p.lane != 0 {
+		t.Error("expected lane 0, got", p.lane)
+	}
+	// 前一条还没有完全进入屏幕
+	if p := l.place(100*time.Millisecond, ModeScroll, width); p.lane != 1 {
+		t.Error("expected lane 1, got", p.lane)
+	}
+	// 同样宽度的弹幕速度相同, 前一条进入屏幕后就可以跟上
+	if p := l.place(2*time.Second, ModeScroll, width); p.lane != 0 {
+		t.Error("expected lane 0, got", p.lane)
+	}
+	// 更长的弹幕更快, 会追上前两行的弹幕
+	if p := l.place(2200*time.Millisecond, ModeScroll, 30*opts.FontSize); p.lane != 2 {
+		t.Error("expected lane 2, got", p.lane)
+	}
+
+	fixed := newLanes(opts)
+	fixed.place(0, ModeTop, width)
+	if p := fixed.place(time.Second, ModeTop, width); p.lane != 1 {
+		t.Error("expected lane 1, got", p.lane)
+	}
+	if p := fixed.place(opts.FixedDuration, ModeTop, width); p.lane != 0 {
+		t.Error("expected lane 0, got", p.lane)
+	}
+}
+
+func TestWriteASS(t *testing.T) {
+	comments := []Comment{
+		{Time: time.Second, Mode: ModeScroll, Text: "{蓝}", Color: 0x1e87f0},
+		{Time: 61 * time.Second, Mode: ModeBottom, Text: "底部", Color: 0xffffff},
+	}
+	var buf bytes.Buffer
+	if err := WriteASS(&buf, comments, DefaultASSOptions); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"PlayResX: 1920",
+		`Dialogue: 2,0:00:01.00,0:00:09.00,Danmaku,,0000,0000,0000,,{\move(1920,0,-144,0)\c&HF0871E&}｛蓝｝`,
+		`Dialogue: 2,0:01:01.00,0:01:05.00,Danmaku,,0000,0000,0000,,{\an8\pos(960,1032)}底部`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %q in\n%s", want, buf.String())
+		}
+	}
+}
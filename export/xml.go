@@ -0,0 +1,37 @@
+package export
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+)
+
+const xmlFontSize = 25
+
+// WriteXML 按 Bilibili 的弹幕格式输出, 每条弹幕是一个 <d p="..."> 元素,
+// p 依次是时间 (秒), 模式, 字号, 十进制颜色, unix 时间, 弹幕池, 用户 id 的
+// crc32 和行号.
+func WriteXML(w io.Writer, comments []Comment) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, xml.Header)
+	fmt.Fprint(bw, "<i>\n")
+	fmt.Fprint(bw, "\t<chatserver>chat.bilibili.com</chatserver>\n")
+	fmt.Fprint(bw, "\t<chatid>0</chatid>\n")
+	fmt.Fprint(bw, "\t<mission>0</mission>\n")
+	fmt.Fprintf(bw, "\t<maxlimit>%d</maxlimit>\n", len(comments))
+	fmt.Fprint(bw, "\t<source>k-v</source>\n")
+	for i, c := range comments {
+		uidHash := crc32.ChecksumIEEE([]byte(strconv.Itoa(c.Uid)))
+		fmt.Fprintf(bw, "\t<d p=\"%.5f,%d,%d,%d,%d,0,%08x,%d\">",
+			c.Time.Seconds(), c.Mode, xmlFontSize, c.Color, c.Date.Unix(), uidHash, i+1)
+		if err := xml.EscapeText(bw, []byte(c.Text)); err != nil {
+			return err
+		}
+		fmt.Fprint(bw, "</d>\n")
+	}
+	fmt.Fprint(bw, "</i>\n")
+	return bw.Flush()
+}
@@ -0,0 +1,275 @@
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+const DefaultReloadInterval = 5 * time.Second
+
+// Rules 是一组过滤规则. 允许名单里的用户不受其它规则限制, 屏蔽名单里的
+// 用户的所有事件都被过滤. 关键词和正则, 等级和长度只对弹幕生效.
+type Rules struct {
+	Keywords       []string `json:"keywords,omitempty"`
+	Regexps        []string `json:"regexps,omitempty"`
+	BlockUids      []int    `json:"block_uids,omitempty"`
+	BlockNicknames []string `json:"block_nicknames,omitempty"`
+	AllowUids      []int    `json:"allow_uids,omitempty"`
+	AllowNicknames []string `json:"allow_nicknames,omitempty"`
+	// MinLevel 是发弹幕的最低用户等级, 0 表示不限制
+	MinLevel int `json:"min_level,omitempty"`
+	// MaxLength 是弹幕的最大字数, 0 表示不限制
+	MaxLength int `json:"max_length,omitempty"`
+}
+
+// Config 是配置文件里的 filter 部分:
+//
+//	"filter": {
+//		"keywords": ["广告"],
+//		"min_level": 5,
+//		"rooms": {"douyu:156277": {"min_level": -1, "block_uids": [123]}},
+//		"flood": {"window": 10, "user_rate": 5, "user_period": 10}
+//	}
+//
+// rooms 的键是 "bilibili:21452505" 这样的房间地址, 只写房间号时是斗鱼的房间.
+// 房间的名单和关键词追加到全局规则上, 不为 0 的 MinLevel 和 MaxLength
+// 覆盖全局设置, 设成 -1 取消全局的限制.
+type Config struct {
+	Rules
+	Rooms map[string]Rules `json:"rooms,omitempty"`
+	Flood FloodConfig      `json:"flood,omitempty"`
+}
+
+// Load 读取配置文件里的 filter 部分, 没有时返回空配置.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := struct {
+		Filter *Config `json:"filter"`
+	}{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Filter == nil {
+		return &Config{}, nil
+	}
+	return file.Filter, nil
+}
+
+func merge(global, room Rules) Rules {
+	r := global
+	r.Keywords = append(append([]string(nil), global.Keywords...), room.Keywords...)
+	r.Regexps = append(append([]string(nil), global.Regexps...), room.Regexps...)
+	r.BlockUids = append(append([]int(nil), global.BlockUids...), room.BlockUids...)
+	r.BlockNicknames = append(append([]string(nil), global.BlockNicknames...), room.BlockNicknames...)
+	r.AllowUids = append(append([]int(nil), global.AllowUids...), room.AllowUids...)
+	r.AllowNicknames = append(append([]string(nil), global.AllowNicknames...), room.AllowNicknames...)
+	if room.MinLevel != 0 {
+		r.MinLevel = room.MinLevel
+	}
+	if room.MaxLength != 0 {
+		r.MaxLength = room.MaxLength
+	}
+	return r
+}
+
+type compiled struct {
+	keywords       []string
+	regexps        []*regexp.Regexp
+	blockUids      map[int]bool
+	blockNicknames map[string]bool
+	allowUids      map[int]bool
+	allowNicknames map[string]bool
+	minLevel       int
+	maxLength      int
+}
+
+func compile(rules Rules) (*compiled, error) {
+	c := &compiled{
+		blockUids:      make(map[int]bool),
+		blockNicknames: make(map[string]bool),
+		allowUids:      make(map[int]bool),
+		allowNicknames: make(map[string]bool),
+		minLevel:       rules.MinLevel,
+		maxLength:      rules.MaxLength,
+	}
+	for _, keyword := range rules.Keywords {
+		if keyword != "" {
+			c.keywords = append(c.keywords, strings.ToLower(keyword))
+		}
+	}
+	for _, expr := range rules.Regexps {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		c.regexps = append(c.regexps, re)
+	}
+	for _, uid := range rules.BlockUids {
+		c.blockUids[uid] = true
+	}
+	for _, nn := range rules.BlockNicknames {
+		c.blockNicknames[nn] = true
+	}
+	for _, uid := range rules.AllowUids {
+		c.allowUids[uid] = true
+	}
+	for _, nn := range rules.AllowNicknames {
+		c.allowNicknames[nn] = true
+	}
+	return c, nil
+}
+
+func (c *compiled) allowUser(uid int, nickname string) (allowed, blocked bool) {
+	if c.allowUids[uid] || c.allowNicknames[nickname] {
+		return true, false
+	}
+	return false, c.blockUids[uid] || c.blockNicknames[nickname]
+}
+
+func (c *compiled) allowChat(chat *danmuku.ChatMessage) bool {
+	if c.minLevel > 0 && chat.Level < c.minLevel {
+		return false
+	}
+	if c.maxLength > 0 && utf8.RuneCountInString(chat.Text) > c.maxLength {
+		return false
+	}
+	text := strings.ToLower(chat.Text)
+	for _, keyword := range c.keywords {
+		if strings.Contains(text, keyword) {
+			return false
+		}
+	}
+	for _, re := range c.regexps {
+		if re.MatchString(chat.Text) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *compiled) allow(ev danmuku.Event) bool {
+	var uid int
+	var nickname string
+	switch ev := ev.(type) {
+	case *danmuku.ChatMessage:
+		uid, nickname = ev.Uid, ev.Nickname
+	case *danmuku.Gift:
+		uid, nickname = ev.Uid, ev.Nickname
+	case *danmuku.UserEnter:
+		uid, nickname = ev.Uid, ev.Nickname
+	default:
+		return true
+	}
+	allowed, blocked := c.allowUser(uid, nickname)
+	if allowed {
+		return true
+	}
+	if blocked {
+		return false
+	}
+	if chat, ok := ev.(*danmuku.ChatMessage); ok {
+		return c.allowChat(chat)
+	}
+	return true
+}
+
+// Engine 按规则过滤事件, 可以在运行时替换规则, 并发安全.
+type Engine struct {
+	mutex  sync.RWMutex
+	global *compiled
+	rooms  map[string]*compiled
+	flood  *Flood
+}
+
+func New(cfg *Config) (*Engine, error) {
+	e := &Engine{flood: NewFlood(cfg.Flood)}
+	if err := e.Update(cfg); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Update 替换规则和 Flood 的设置, 规则有错误时保留原来的规则.
+func (e *Engine) Update(cfg *Config) error {
+	global, err := compile(cfg.Rules)
+	if err != nil {
+		return err
+	}
+	rooms := make(map[string]*compiled)
+	for addr, rules := range cfg.Rooms {
+		scheme, roomId, err := danmuku.ParseRoomAddr(addr)
+		if err != nil {
+			return err
+		}
+		if rooms[danmuku.RoomAddr(scheme, roomId)], err = compile(merge(cfg.Rules, rules)); err != nil {
+			return err
+		}
+	}
+
+	e.mutex.Lock()
+	e.global, e.rooms = global, rooms
+	e.mutex.Unlock()
+	e.flood.Update(cfg.Flood)
+	return nil
+}
+
+// Flood 返回按配置里 flood 部分合并刷屏的 Flood, 它的设置和规则一起更新.
+func (e *Engine) Flood() *Flood {
+	return e.flood
+}
+
+// Allow 返回事件是否通过过滤, 可以直接作为 danmuku.Filter 使用.
+func (e *Engine) Allow(ev danmuku.Event) bool {
+	e.mutex.RLock()
+	c, ok := e.rooms[ev.Addr()]
+	if !ok {
+		c = e.global
+	}
+	e.mutex.RUnlock()
+	return c.allow(ev)
+}
+
+// Watch 每隔 interval 检查一次配置文件, 修改后重新加载规则, 直到 ctx 结束.
+// 加载失败时打印错误并继续使用原来的规则.
+func (e *Engine) Watch(ctx context.Context, path string, interval time.Duration) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+		cfg, err := Load(path)
+		if err == nil {
+			err = e.Update(cfg)
+		}
+		if err != nil {
+			log.Println("filter: reload:", err)
+			continue
+		}
+		log.Println("filter: reloaded", path)
+	}
+}
@@ -0,0 +1,199 @@
+package filter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+func chat(roomId, uid int, nickname, text string, level int) *danmuku.ChatMessage {
+	return &danmuku.ChatMessage{
+		Header:   danmuku.Header{Type: "chatmsg", RoomId: roomId},
+		Uid:      uid,
+		Nickname: nickname,
+		Text:     text,
+		Level:    level,
+	}
+}
+
+func bilibili(chat *danmuku.ChatMessage) *danmuku.ChatMessage {
+	chat.Scheme = "bilibili"
+	return chat
+}
+
+func TestEngine(t *testing.T) {
+	cfg := &Config{
+		Rules: Rules{
+			Keywords:       []string{"QQ群"},
+			Regexps:        []string{`^6+$`},
+			BlockUids:      []int{100},
+			BlockNicknames: []string{"spam"},
+			AllowUids:      []int{200},
+			MinLevel:       5,
+			MaxLength:      10,
+		},
+		Rooms: map[string]Rules{
+			"2":          {MinLevel: -1, BlockUids: []int{300}, AllowNicknames: []string{"spam"}},
+			"bilibili:3": {Keywords: []string{"hello"}},
+		},
+	}
+	e, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ev    danmuku.Event
+		allow bool
+	}{
+		{chat(1, 1, "a", "hello", 10), true},
+		{chat(1, 1, "a", "加qq群123", 10), false},
+		{chat(1, 1, "a", "6666", 10), false},
+		{chat(1, 1, "a", "66666 主播", 10), true},
+		{chat(1, 1, "a", "hello", 1), false},
+		{chat(1, 1, "a", "一二三四五六七八九十一", 10), false},
+		{chat(1, 100, "a", "hello", 10), false},
+		{chat(1, 1, "spam", "hello", 10), false},
+		{chat(1, 200, "a", "6666", 1), true},
+		{&danmuku.Gift{Header: danmuku.Header{Type: "dgb", RoomId: 1}, Uid: 100}, false},
+		{&danmuku.Gift{Header: danmuku.Header{Type: "dgb", RoomId: 1}, Uid: 1, Level: 1}, true},
+		{&danmuku.StateChange{RoomId: 1}, true},
+		// 房间覆盖
+		{chat(2, 1, "a", "hello", 1), true},
+		{chat(2, 1, "a", "加QQ群", 10), false},
+		{chat(2, 300, "a", "hello", 10), false},
+		{chat(2, 100, "a", "hello", 10), false},
+		{chat(2, 1, "spam", "6666", 10), true},
+		// 其它平台的同号房间不受覆盖影响
+		{bilibili(chat(2, 1, "a", "hello", 1)), false},
+		{bilibili(chat(3, 1, "a", "hello", 10)), false},
+		{chat(3, 1, "a", "hello", 10), true},
+	}
+	for i, c := range cases {
+		if e.Allow(c.ev) != c.allow {
+			t.Errorf("case %d: expected %v for %#v", i, c.allow, c.ev)
+		}
+	}
+
+	if err := e.Update(&Config{Rules: Rules{Regexps: []string{"("}}}); err == nil {
+		t.Error("expected regexp error")
+	}
+	if err := e.Update(&Config{Rooms: map[string]Rules{"huya:kpl": {}}}); err != danmuku.ErrBadAddr {
+		t.Error("expected ErrBadAddr, got", err)
+	}
+	if e.Allow(chat(1, 100, "a", "hello", 10)) {
+		t.Error("rules should be kept after a failed update")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "playlist.json")
+	if err := ioutil.WriteFile(path, []byte(`{"playlist": [1]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Watch(ctx, path, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	ev := chat(1, 1, "a", "广告", 10)
+	if !e.Allow(ev) {
+		t.Fatal("empty config should allow everything")
+	}
+	data := []byte(`{"playlist": [1], "filter": {"keywords": ["广告"], "flood": {"window": -1}}}`)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	for i := 0; i < 100 && (e.Allow(ev) || e.Flood().Config().Window != -1); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if e.Allow(ev) {
+		t.Error("config was not reloaded")
+	}
+	if e.Flood().Config().Window != -1 {
+		t.Error("flood config was not reloaded", e.Flood().Config())
+	}
+	first, _ := e.Flood().Check(chat(1, 1, "a", "666", 10))
+	if line, _ := e.Flood().Check(chat(1, 2, "a", "666", 10)); line.Id == first.Id {
+		t.Error("flood should not collapse after window -1 is loaded", line)
+	}
+}
+
+func TestFlood(t *testing.T) {
+	f := NewFlood(FloodConfig{UserRate: 3, UserPeriod: 10})
+	now := time.Unix(0, 0)
+	f.now = func() time.Time { return now }
+
+	check := func(uid int, text string) (Line, bool) {
+		return f.Check(chat(1, uid, "a", text, 10))
+	}
+	first, _ := check(1, "666")
+	if first.Count != 1 {
+		t.Fatal("expected new line", first)
+	}
+	now = now.Add(time.Second)
+	if line, _ := check(2, "６６６６！"); line.Id != first.Id || line.Count != 2 {
+		t.Error("expected collapsed line", line)
+	}
+	if line, _ := check(3, "hello"); line.Id == first.Id || line.Count != 1 {
+		t.Error("expected new line", line)
+	}
+	if line, _ := f.Check(chat(2, 4, "a", "666", 10)); line.Id == first.Id {
+		t.Error("rooms should not be collapsed together", line)
+	}
+
+	// 窗口是滑动的, 最后一条之后超过窗口才开始新的一行
+	now = now.Add(9 * time.Second)
+	if line, _ := check(4, "666"); line.Id != first.Id || line.Count != 3 {
+		t.Error("expected collapsed line", line)
+	}
+	now = now.Add(11 * time.Second)
+	if line, _ := check(5, "666"); line.Id == first.Id || line.Count != 1 {
+		t.Error("expected new line after window", line)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, ok := check(6, "a"+strconv.Itoa(i)); !ok {
+			t.Error("message under rate cap hidden", i)
+		}
+	}
+	if _, ok := check(6, "spam"); ok {
+		t.Error("message over rate cap shown")
+	}
+	now = now.Add(11 * time.Second)
+	if _, ok := check(6, "back"); !ok {
+		t.Error("user should be shown again after period")
+	}
+
+	// CheckAt 按给定的时间合并, 用来重建历史弹幕
+	replay := NewFlood(f.Config())
+	at := time.Unix(1000, 0)
+	first, _ = replay.CheckAt(chat(1, 1, "a", "233", 10), at)
+	if line, _ := replay.CheckAt(chat(1, 2, "a", "233", 10), at.Add(5*time.Second)); line.Id != first.Id || line.Count != 2 {
+		t.Error("expected collapsed line", line)
+	}
+	if line, _ := replay.CheckAt(chat(1, 3, "a", "233", 10), at.Add(20*time.Second)); line.Id == first.Id {
+		t.Error("expected new line after window", line)
+	}
+}
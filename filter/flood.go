@@ -0,0 +1,168 @@
+package filter
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+const DefaultFloodWindow = 10 * time.Second
+
+// FloodConfig 是配置文件里 filter.flood 部分, 时间单位是秒.
+type FloodConfig struct {
+	// Window 内相同的弹幕合并成一行, 0 使用 DefaultFloodWindow, -1 不合并
+	Window int `json:"window,omitempty"`
+	// 一个用户在 UserPeriod 内超过 UserRate 条的弹幕被隐藏, 0 表示不限制
+	UserRate   int `json:"user_rate,omitempty"`
+	UserPeriod int `json:"user_period,omitempty"`
+}
+
+// Line 是合并后的一行弹幕. Count 为 1 时是新的一行, 大于 1 时应该把
+// 同一个 Id 的行更新成 "×Count".
+type Line struct {
+	Id    uint64
+	Count int
+}
+
+type floodGroup struct {
+	id    uint64
+	count int
+	last  time.Time
+}
+
+// Flood 合并刷屏的弹幕, 并限制单个用户的发言频率. 并发安全.
+type Flood struct {
+	cfg        FloodConfig
+	window     time.Duration
+	userRate   int
+	userPeriod time.Duration
+
+	mutex     sync.Mutex
+	now       func() time.Time
+	nextId    uint64
+	groups    map[string]*floodGroup
+	users     map[int][]time.Time
+	lastPrune time.Time
+}
+
+func NewFlood(cfg FloodConfig) *Flood {
+	f := &Flood{
+		now:    time.Now,
+		groups: make(map[string]*floodGroup),
+		users:  make(map[int][]time.Time),
+	}
+	f.Update(cfg)
+	return f
+}
+
+// Update 替换设置, 已经合并的行和发言记录保留.
+func (f *Flood) Update(cfg FloodConfig) {
+	window := DefaultFloodWindow
+	if cfg.Window != 0 {
+		window = time.Duration(cfg.Window) * time.Second
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.cfg = cfg
+	f.window = window
+	f.userRate = cfg.UserRate
+	f.userPeriod = time.Duration(cfg.UserPeriod) * time.Second
+}
+
+// Config 返回当前的设置, 可以用来新建一个同样设置的 Flood.
+func (f *Flood) Config() FloodConfig {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.cfg
+}
+
+// normalize 去掉空白和标点, 忽略大小写, 把重复的字符压缩成一个,
+// 这样 "666", "6666 " 和 "６６６!" 被认为是同一条弹幕.
+func normalize(text string) string {
+	var b bytes.Buffer
+	var prev rune = -1
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		// 全角字符转成半角
+		if r >= '！' && r <= '～' {
+			r = r - '！' + '!'
+		}
+		if r == prev {
+			continue
+		}
+		prev = r
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return text
+	}
+	return b.String()
+}
+
+// Check 返回弹幕合并后的行, ok 为 false 时弹幕因为用户发言太快被隐藏.
+func (f *Flood) Check(chat *danmuku.ChatMessage) (line Line, ok bool) {
+	return f.CheckAt(chat, f.now())
+}
+
+// CheckAt 和 Check 相同, 但是把 now 作为收到弹幕的时间, 用来合并之前收到的
+// 弹幕. 时间需要按顺序.
+func (f *Flood) CheckAt(chat *danmuku.ChatMessage, now time.Time) (line Line, ok bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.prune(now)
+
+	if f.userRate > 0 && f.userPeriod > 0 {
+		times := f.users[chat.Uid]
+		i := 0
+		for i < len(times) && now.Sub(times[i]) >= f.userPeriod {
+			i++
+		}
+		times = append(times[i:], now)
+		f.users[chat.Uid] = times
+		if len(times) > f.userRate {
+			return Line{}, false
+		}
+	}
+
+	if f.window < 0 {
+		f.nextId++
+		return Line{f.nextId, 1}, true
+	}
+	key := chat.Addr() + "/" + normalize(chat.Text)
+	g, found := f.groups[key]
+	if found && now.Sub(g.last) < f.window {
+		g.count++
+		g.last = now
+		return Line{g.id, g.count}, true
+	}
+	f.nextId++
+	f.groups[key] = &floodGroup{f.nextId, 1, now}
+	return Line{f.nextId, 1}, true
+}
+
+func (f *Flood) prune(now time.Time) {
+	interval := f.window
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if now.Sub(f.lastPrune) < interval {
+		return
+	}
+	f.lastPrune = now
+	for key, g := range f.groups {
+		if now.Sub(g.last) >= f.window {
+			delete(f.groups, key)
+		}
+	}
+	for uid, times := range f.users {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= f.userPeriod {
+			delete(f.users, uid)
+		}
+	}
+}
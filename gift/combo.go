@@ -0,0 +1,199 @@
+package gift
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+const DefaultComboTimeout = 5 * time.Second
+
+func init() {
+	danmuku.RegisterEvent("giftcombo", func() danmuku.Event { return &Combo{} })
+}
+
+// Combo 是一个用户连续送出的同一种礼物, 由一条或多条 dgb 消息合并而成.
+type Combo struct {
+	danmuku.Header
+	Uid      int       `stt:"uid" json:"uid"`
+	Nickname string    `stt:"nn" json:"nn"`
+	GiftId   string    `stt:"gfid" json:"gfid"`
+	Gift     *Gift     `stt:"-" json:"gift,omitempty"`
+	Count    int       `stt:"gfcnt" json:"gfcnt"`
+	Hits     int       `stt:"hits" json:"hits"`
+	Start    time.Time `stt:"-" json:"start"`
+	End      time.Time `stt:"-" json:"end"`
+}
+
+// Decode 把一条 dgb 消息转换成只有一次连击的 Combo. 消息自带礼物名字时
+// 使用消息里的名字和价格, 否则用 lookup 查找, lookup 为 nil 或者找不到礼物
+// 时 Gift 为 nil.
+func Decode(ev *danmuku.Gift, lookup func(roomId int, giftId string) *Gift) *Combo {
+	count := ev.Count
+	if count <= 0 {
+		count = 1
+	}
+	hits := ev.Hits
+	if hits <= 0 {
+		hits = 1
+	}
+	c := &Combo{
+		Header:   danmuku.Header{Type: "giftcombo", RoomId: ev.RoomId, Scheme: ev.Scheme},
+		Uid:      ev.Uid,
+		Nickname: ev.Nickname,
+		GiftId:   ev.GiftId,
+		Count:    count,
+		Hits:     hits,
+	}
+	switch {
+	case ev.GiftName != "":
+		c.Gift = &Gift{Id: ev.GiftId, Name: ev.GiftName, Type: TypeYuwan}
+		if ev.Price > 0 {
+			c.Gift.Type, c.Gift.Price = TypeYuchi, ev.Price
+		}
+	case lookup != nil && (ev.Scheme == "" || ev.Scheme == danmuku.DefaultScheme):
+		// 礼物接口只有斗鱼的礼物, 其它平台的 id 会查到错误的礼物
+		c.Gift = lookup(ev.Room(), ev.GiftId)
+	}
+	return c
+}
+
+func (c *Combo) Name() string {
+	if c.Gift != nil && c.Gift.Name != "" {
+		return c.Gift.Name
+	}
+	return "礼物" + c.GiftId
+}
+
+// Value 返回礼物一共值多少元
+func (c *Combo) Value() float64 {
+	if c.Gift == nil {
+		return 0
+	}
+	return c.Gift.Value(c.Count)
+}
+
+// String 返回 "X 送出 Y ×N (¥Z)", 鱼丸礼物不显示价格.
+func (c *Combo) String() string {
+	s := fmt.Sprintf("%s 送出 %s ×%d", c.Nickname, c.Name(), c.Count)
+	if value := c.Value(); value > 0 {
+		price := strings.TrimRight(strings.TrimRight(strconv.FormatFloat(value, 'f', 2, 64), "0"), ".")
+		s += " (¥" + price + ")"
+	}
+	return s
+}
+
+type comboKey struct {
+	addr   string
+	uid    int
+	giftId string
+}
+
+// Combiner 把连击的 dgb 消息合并成一个 Combo. 一次连击在 hits 重新开始
+// 或者 Timeout 内没有新的连击时结束. 不是并发安全的.
+type Combiner struct {
+	Timeout time.Duration
+
+	lookup  func(roomId int, giftId string) *Gift
+	pending map[comboKey]*Combo
+	now     func() time.Time
+}
+
+func NewCombiner(lookup func(roomId int, giftId string) *Gift) *Combiner {
+	return &Combiner{
+		Timeout: DefaultComboTimeout,
+
+		lookup:  lookup,
+		pending: make(map[comboKey]*Combo),
+		now:     time.Now,
+	}
+}
+
+// Add 加入一条 dgb 消息, 返回因此结束的上一次连击, 没有时返回 nil.
+func (c *Combiner) Add(ev *danmuku.Gift) *Combo {
+	now := c.now()
+	hit := Decode(ev, c.lookup)
+	key := comboKey{hit.Addr(), hit.Uid, hit.GiftId}
+
+	prev, ok := c.pending[key]
+	if ok && hit.Hits > prev.Hits && now.Sub(prev.End) < c.Timeout {
+		prev.Count += hit.Count
+		prev.Hits = hit.Hits
+		prev.End = now
+		return nil
+	}
+	hit.Start, hit.End = now, now
+	c.pending[key] = hit
+	if ok {
+		return prev
+	}
+	return nil
+}
+
+// Expire 返回超时结束的连击, 按开始时间和 uid 排序.
+func (c *Combiner) Expire() []*Combo {
+	now := c.now()
+	return c.take(func(combo *Combo) bool {
+		return now.Sub(combo.End) >= c.Timeout
+	})
+}
+
+// Flush 结束所有连击
+func (c *Combiner) Flush() []*Combo {
+	return c.take(func(*Combo) bool { return true })
+}
+
+func (c *Combiner) take(done func(*Combo) bool) []*Combo {
+	combos := make([]*Combo, 0)
+	for key, combo := range c.pending {
+		if done(combo) {
+			combos = append(combos, combo)
+			delete(c.pending, key)
+		}
+	}
+	sort.Slice(combos, func(i, j int) bool {
+		if combos[i].Start.Equal(combos[j].Start) {
+			return combos[i].Uid < combos[j].Uid
+		}
+		return combos[i].Start.Before(combos[j].Start)
+	})
+	return combos
+}
+
+// Run 从订阅里读取事件交给 emit, 其中 dgb 消息合并成 Combo 后再发出.
+// 订阅关闭或者 ctx 结束时发出所有未结束的连击并返回.
+func (c *Combiner) Run(ctx context.Context, sub *danmuku.Subscription, emit func(danmuku.Event)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	defer func() {
+		for _, combo := range c.Flush() {
+			emit(combo)
+		}
+	}()
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if g, ok := ev.(*danmuku.Gift); ok {
+				if combo := c.Add(g); combo != nil {
+					emit(combo)
+				}
+				continue
+			}
+			emit(ev)
+		case <-ticker.C:
+			for _, combo := range c.Expire() {
+				emit(combo)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package gift
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// TypeYuwan 是用鱼丸 (免费) 买的礼物, TypeYuchi 是用鱼翅 (1 鱼翅 = 1 元) 买的礼物
+	TypeYuwan = "1"
+	TypeYuchi = "2"
+
+	DefaultTTL = 24 * time.Hour
+
+	DefaultTimeout = 10 * time.Second
+
+	retryInterval = time.Minute
+	// lookupTimeout 限制 Lookup 等待礼物列表的时间
+	lookupTimeout = 5 * time.Second
+)
+
+// RoomAPI 是房间信息接口, 后面加上房间号
+var RoomAPI = "http://open.douyucdn.cn/api/RoomApi/room/"
+
+var httpClient = &http.Client{Timeout: DefaultTimeout}
+
+type Gift struct {
+	Id    string  `json:"id"`
+	Name  string  `json:"name"`
+	Type  string  `json:"type"`
+	Price float64 `json:"pc"`
+	Exp   float64 `json:"gx"`
+	Desc  string  `json:"desc,omitempty"`
+	Intro string  `json:"intro,omitempty"`
+	Himg  string  `json:"himg,omitempty"`
+	Mimg  string  `json:"mimg,omitempty"`
+}
+
+// Value 返回 count 个礼物值多少元, 鱼丸礼物不值钱.
+func (g *Gift) Value(count int) float64 {
+	if g.Type != TypeYuchi {
+		return 0
+	}
+	return g.Price * float64(count)
+}
+
+// Catalog 是一个房间可以送的礼物
+type Catalog struct {
+	RoomId  int              `json:"room_id"`
+	Updated time.Time        `json:"updated"`
+	Gifts   map[string]*Gift `json:"gifts"`
+}
+
+func (c *Catalog) Get(giftId string) *Gift {
+	if c == nil {
+		return nil
+	}
+	return c.Gifts[giftId]
+}
+
+// Fetch 从房间信息接口获取礼物列表
+func Fetch(ctx context.Context, roomId int) (*Catalog, error) {
+	req, err := http.NewRequest("GET", RoomAPI+strconv.Itoa(roomId), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var roomData struct {
+		Error int `json:"error"`
+		Data  struct {
+			Gift []*Gift `json:"gift"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &roomData); err != nil {
+		return nil, err
+	}
+	if roomData.Error != 0 {
+		return nil, fmt.Errorf("gift: room %d: error %d", roomId, roomData.Error)
+	}
+
+	catalog := &Catalog{roomId, time.Now(), make(map[string]*Gift)}
+	for _, gift := range roomData.Data.Gift {
+		catalog.Gifts[gift.Id] = gift
+	}
+	return catalog, nil
+}
+
+// DefaultCacheDir 返回 ~/.cache/love66/gift
+func DefaultCacheDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".cache", "love66", "gift")
+}
+
+// Cache 把礼物列表保存在内存和 Dir 下, 超过 TTL 后重新获取, 获取失败时
+// 继续使用过期的列表. 并发安全.
+type Cache struct {
+	Dir string
+	TTL time.Duration
+
+	mutex    sync.Mutex
+	catalogs map[int]*Catalog
+	calls    map[int]*call
+	fetch    func(ctx context.Context, roomId int) (*Catalog, error)
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{
+		Dir: dir,
+		TTL: DefaultTTL,
+
+		catalogs: make(map[int]*Catalog),
+		calls:    make(map[int]*call),
+		fetch:    Fetch,
+	}
+}
+
+func (c *Cache) path(roomId int) string {
+	return filepath.Join(c.Dir, strconv.Itoa(roomId)+".json")
+}
+
+func (c *Cache) fresh(catalog *Catalog) bool {
+	return catalog != nil && time.Since(catalog.Updated) < c.TTL
+}
+
+// call 是一次正在进行的获取, 同一个房间同时只获取一次
+type call struct {
+	done    chan struct{}
+	catalog *Catalog
+	err     error
+}
+
+// Get 返回房间的礼物列表. 获取时不持有锁, 同一个房间的其他调用等待同一次
+// 获取的结果, 不同房间互不影响.
+func (c *Cache) Get(ctx context.Context, roomId int) (*Catalog, error) {
+	c.mutex.Lock()
+	catalog := c.catalogs[roomId]
+	if catalog == nil && c.Dir != "" {
+		if data, err := ioutil.ReadFile(c.path(roomId)); err == nil {
+			var cached Catalog
+			if json.Unmarshal(data, &cached) == nil {
+				catalog = &cached
+			}
+		}
+	}
+	if c.fresh(catalog) {
+		c.catalogs[roomId] = catalog
+		c.mutex.Unlock()
+		return catalog, nil
+	}
+	if cl, ok := c.calls[roomId]; ok {
+		c.mutex.Unlock()
+		select {
+		case <-cl.done:
+			return cl.catalog, cl.err
+		case <-ctx.Done():
+			return catalog, ctx.Err()
+		}
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[roomId] = cl
+	c.mutex.Unlock()
+
+	cl.catalog, cl.err = c.fetchCatalog(ctx, roomId, catalog)
+
+	c.mutex.Lock()
+	delete(c.calls, roomId)
+	c.mutex.Unlock()
+	close(cl.done)
+	return cl.catalog, cl.err
+}
+
+// fetchCatalog 获取并保存礼物列表, 失败时使用过期的列表 stale.
+func (c *Cache) fetchCatalog(ctx context.Context, roomId int, stale *Catalog) (*Catalog, error) {
+	fetched, err := c.fetch(ctx, roomId)
+	if err != nil {
+		// 一分钟内不再重试
+		retry := time.Now().Add(retryInterval - c.TTL)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if stale != nil {
+			copied := *stale
+			copied.Updated = retry
+			c.catalogs[roomId] = &copied
+			return &copied, nil
+		}
+		c.catalogs[roomId] = &Catalog{roomId, retry, make(map[string]*Gift)}
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.catalogs[roomId] = fetched
+	c.mutex.Unlock()
+	if c.Dir != "" {
+		if err := c.save(fetched); err != nil {
+			return fetched, err
+		}
+	}
+	return fetched, nil
+}
+
+func (c *Cache) save(catalog *Catalog) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(catalog)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path(catalog.RoomId), data, 0644)
+}
+
+// Cached 和 Lookup 相同但是不等待网络: 礼物列表不在内存里或者已经过期时
+// 在后台获取, 这次返回已有的结果. 在事件流上查找礼物时使用它, 不会因为
+// 礼物接口慢而卡住弹幕.
+func (c *Cache) Cached(roomId int, giftId string) *Gift {
+	c.mutex.Lock()
+	catalog := c.catalogs[roomId]
+	_, fetching := c.calls[roomId]
+	c.mutex.Unlock()
+	if !c.fresh(catalog) && !fetching {
+		go c.Prefetch(roomId)
+	}
+	return catalog.Get(giftId)
+}
+
+// Prefetch 获取房间的礼物列表, 房间开始接收弹幕前调用, 之后 Cached 就能
+// 查到礼物.
+func (c *Cache) Prefetch(roomId int) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	if _, err := c.Get(ctx, roomId); err != nil {
+		log.Println("gift: prefetch:", roomId, err)
+	}
+}
+
+// Lookup 返回房间里的礼物, 找不到或者等待超过 lookupTimeout 时返回 nil.
+func (c *Cache) Lookup(roomId int, giftId string) *Gift {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	catalog, _ := c.Get(ctx, roomId)
+	return catalog.Get(giftId)
+}
@@ -0,0 +1,249 @@
+package gift
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/danmuku/douyutest"
+)
+
+func TestFetch(t *testing.T) {
+	api := douyutest.NewAPI()
+	defer api.Close()
+	defer func(u string) { RoomAPI = u }(RoomAPI)
+	RoomAPI = api.RoomAPI()
+
+	catalog, err := Fetch(context.Background(), 156277)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rocket := catalog.Get("196")
+	if rocket == nil || rocket.Name != "火箭" || rocket.Value(2) != 1000 || rocket.Exp != 5000 {
+		t.Error("unexpected gift", rocket)
+	}
+	if yuwan := catalog.Get("191"); yuwan.Value(10) != 0 {
+		t.Error("yuwan gift should be free")
+	}
+	if _, err := Fetch(context.Background(), 1); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fetches := 0
+	fail := false
+	fetch := func(ctx context.Context, roomId int) (*Catalog, error) {
+		fetches++
+		if fail {
+			return nil, errors.New("network down")
+		}
+		return &Catalog{roomId, time.Now(), map[string]*Gift{"824": {Id: "824", Name: "荧光棒"}}}, nil
+	}
+
+	c := NewCache(dir)
+	c.fetch = fetch
+	if g := c.Lookup(1, "824"); g == nil || g.Name != "荧光棒" {
+		t.Fatal("unexpected gift", g)
+	}
+	c.Lookup(1, "824")
+	if fetches != 1 {
+		t.Error("expected 1 fetch, got", fetches)
+	}
+
+	// 新的 Cache 从磁盘读取
+	c = NewCache(dir)
+	c.fetch = fetch
+	if g := c.Lookup(1, "824"); g == nil || fetches != 1 {
+		t.Error("expected cached gift", g, fetches)
+	}
+
+	// 过期后获取失败时使用过期的列表
+	c = NewCache(dir)
+	c.fetch = fetch
+	c.TTL = 0
+	fail = true
+	if g := c.Lookup(1, "824"); g == nil || fetches != 2 {
+		t.Error("expected stale gift", g, fetches)
+	}
+	if _, err := c.Get(context.Background(), 2); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	fetches := map[int]int{}
+	c := NewCache("")
+	c.fetch = func(ctx context.Context, roomId int) (*Catalog, error) {
+		mutex.Lock()
+		fetches[roomId]++
+		mutex.Unlock()
+		if roomId == 1 {
+			<-release
+		}
+		return &Catalog{roomId, time.Now(), map[string]*Gift{"824": {Id: "824"}}}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g := c.Lookup(1, "824"); g == nil {
+				t.Error("expected gift")
+			}
+		}()
+	}
+	for started := false; !started; {
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		started = fetches[1] > 0
+		mutex.Unlock()
+	}
+	// 房间 1 获取时不影响其他房间, 等待的调用可以超时
+	if g := c.Lookup(2, "824"); g == nil {
+		t.Error("expected gift")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, 1); err != context.DeadlineExceeded {
+		t.Error("expected deadline exceeded, got", err)
+	}
+	close(release)
+	wg.Wait()
+	if fetches[1] != 1 || fetches[2] != 1 {
+		t.Error("expected 1 fetch per room, got", fetches)
+	}
+}
+
+func TestCacheCached(t *testing.T) {
+	release := make(chan struct{})
+	fetched := make(chan int, 1)
+	c := NewCache("")
+	c.fetch = func(ctx context.Context, roomId int) (*Catalog, error) {
+		<-release
+		defer func() { fetched <- roomId }()
+		return &Catalog{roomId, time.Now(), map[string]*Gift{"824": {Id: "824", Name: "荧光棒"}}}, nil
+	}
+
+	// 礼物列表还没有获取时不等待
+	if g := c.Cached(1, "824"); g != nil {
+		t.Error("expected nil before fetch", g)
+	}
+	close(release)
+	select {
+	case <-fetched:
+	case <-time.After(time.Second):
+		t.Fatal("catalog was not fetched in background")
+	}
+	for i := 0; i < 100 && c.Cached(1, "824") == nil; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if g := c.Cached(1, "824"); g == nil || g.Name != "荧光棒" {
+		t.Error("unexpected gift", g)
+	}
+}
+
+func gift(uid int, giftId string, hits int) *danmuku.Gift {
+	return &danmuku.Gift{
+		Header:   danmuku.Header{Type: "dgb", RoomId: 1},
+		Uid:      uid,
+		Nickname: "a",
+		GiftId:   giftId,
+		Hits:     hits,
+	}
+}
+
+func TestCombiner(t *testing.T) {
+	gifts := map[string]*Gift{
+		"824": {Id: "824", Name: "荧光棒", Type: TypeYuchi, Price: 0.1},
+		"191": {Id: "191", Name: "鱼丸", Type: TypeYuwan, Price: 100},
+	}
+	c := NewCombiner(func(roomId int, giftId string) *Gift { return gifts[giftId] })
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	for hits := 1; hits <= 3; hits++ {
+		if combo := c.Add(gift(1, "824", hits)); combo != nil {
+			t.Fatal("combo ended early", combo)
+		}
+		now = now.Add(time.Second)
+	}
+	c.Add(gift(2, "191", 1))
+	if combos := c.Expire(); len(combos) != 0 {
+		t.Fatal("unexpected expired combos", combos)
+	}
+
+	// hits 重新开始时上一次连击结束
+	combo := c.Add(gift(1, "824", 1))
+	if combo == nil || combo.String() != "a 送出 荧光棒 ×3 (¥0.3)" {
+		t.Fatal("unexpected combo", combo)
+	}
+
+	now = now.Add(DefaultComboTimeout)
+	combos := c.Expire()
+	if len(combos) != 2 || combos[0].String() != "a 送出 荧光棒 ×1 (¥0.1)" || combos[1].String() != "a 送出 鱼丸 ×1" {
+		t.Error("unexpected expired combos", combos)
+	}
+	if combos := c.Flush(); len(combos) != 0 {
+		t.Error("unexpected pending combos", combos)
+	}
+
+	if name := Decode(gift(1, "1", 1), nil).Name(); name != "礼物1" {
+		t.Error("unexpected name", name)
+	}
+	// 自带名字和价格的礼物不查找
+	g := gift(1, "824", 1)
+	g.GiftName, g.Price, g.Count = "小心心", 0.5, 2
+	if combo := Decode(g, c.lookup); combo.String() != "a 送出 小心心 ×2 (¥1)" {
+		t.Error("unexpected combo", combo)
+	}
+	// 其它平台的礼物不在斗鱼的礼物接口里查找
+	g = gift(1, "824", 1)
+	g.Scheme = "bilibili"
+	if combo := Decode(g, c.lookup); combo.Gift != nil {
+		t.Error("unexpected gift from douyu catalog", combo.Gift)
+	}
+}
+
+func TestCombinerRun(t *testing.T) {
+	b := danmuku.NewBroadcaster()
+	sub := b.Subscribe(nil, 16, danmuku.Block)
+	c := NewCombiner(nil)
+	events := make([]danmuku.Event, 0)
+	done := make(chan bool)
+	go func() {
+		c.Run(context.Background(), sub, func(ev danmuku.Event) { events = append(events, ev) })
+		close(done)
+	}()
+
+	ctx := context.Background()
+	b.Publish(ctx, gift(1, "824", 1))
+	b.Publish(ctx, gift(1, "824", 2))
+	b.Publish(ctx, &danmuku.ChatMessage{Header: danmuku.Header{Type: "chatmsg", RoomId: 1}})
+	b.Close()
+	<-done
+
+	if len(events) != 2 {
+		t.Fatal("unexpected events", events)
+	}
+	if _, ok := events[0].(*danmuku.ChatMessage); !ok {
+		t.Error("expected chat first", events[0])
+	}
+	if combo, ok := events[1].(*Combo); !ok || combo.Count != 2 || combo.EventType() != "giftcombo" {
+		t.Error("unexpected combo", events[1])
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -10,34 +12,59 @@ import (
 
 	"strconv"
 
+	"github.com/zwh8800/Love66/archive"
 	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/emote"
+	"github.com/zwh8800/Love66/filter"
+	"github.com/zwh8800/Love66/gift"
 	"github.com/zwh8800/Love66/player"
-	"github.com/zwh8800/Love66/room"
+	"github.com/zwh8800/Love66/provider"
+	_ "github.com/zwh8800/Love66/provider/bilibili"
+	_ "github.com/zwh8800/Love66/provider/douyu"
+	_ "github.com/zwh8800/Love66/provider/huya"
+	"github.com/zwh8800/Love66/stats"
+	"github.com/zwh8800/Love66/theme"
 	"github.com/zwh8800/Love66/view"
 )
 
 var (
-	isDebug       bool
-	rooms         []*room.DouyuRoom
-	danmukuRooms  []*danmuku.DanmukuRoom
-	currentRoom   int
-	mainPlayer    *player.Player
-	maxLineCount  int
-	quitChannel   chan bool = make(chan bool)
-	changeChannel chan bool = make(chan bool)
+	isDebug      bool
+	rooms        []*provider.Room
+	danmukuHub   *danmuku.Hub
+	danmukuRules *filter.Engine
+	danmukuFlood *filter.Flood
+	danmukuTheme *theme.Theme
+	rightLineIds []uint64
+	danmukuStats *stats.Collector
+	statsWindow  int
+	currentRoom  int
+	mainPlayer   *player.Player
+	maxLineCount int
+	quitChannel  chan bool = make(chan bool)
+
+	// 按键和窗口大小的变化通过这几个 channel 交给 mainLoop, currentRoom,
+	// statsWindow, maxLineCount 和 rightLineIds 只在 mainLoop 里读写
+	roomChannel      = make(chan int) // 切换房间的方向, 1 或 -1
+	lineCountChannel = make(chan int)
+	statsChannel     = make(chan bool)
 )
 
+var statsWindows = [...]time.Duration{stats.Minute, stats.TenMinutes, stats.Session}
+
 func main() {
 	playlistFilename := flag.String("playlist", "playlist.json", "specify a playlist with json format")
+	replayDir := flag.String("replay", "", "replay danmu recorded in dir instead of connecting")
 	flag.Parse()
 
-	isDebug, rooms, danmukuRooms = parsePlaylist(*playlistFilename)
+	isDebug, rooms, danmukuHub = parsePlaylist(*playlistFilename, *replayDir)
+	danmukuRules, danmukuFlood = loadFilter(*playlistFilename)
+	danmukuTheme = loadTheme(*playlistFilename)
 	if !isDebug {
 		os.Stderr.Close()
 	}
 	currentRoom = 0
 
-	mainPlayer = player.NewPlayer(rooms[currentRoom].LiveStreamUrl())
+	mainPlayer = player.NewPlayer(rooms[currentRoom].StreamUrl(context.Background()))
 
 	if err := view.Init(); err != nil {
 		log.Panic(err)
@@ -45,43 +72,25 @@ func main() {
 	defer view.DeInit()
 	maxLineCount = view.GetMaxLineCount()
 
-	view.SetData(getViewData(nil, nil))
+	view.SetData(getViewData(nil, 0, nil))
 	view.OnMaxLineCountChange(func(args ...interface{}) {
-		var ok bool
-		maxLineCount, ok = args[0].(int)
+		count, ok := args[0].(int)
 		if !ok {
 			log.Panic("cast error")
 		}
-		view.SetData(getViewData(nil, nil))
-		view.Update()
+		sendInt(lineCountChannel, count)
 	})
 	view.OnKeyNext(func(args ...interface{}) {
-		stopDanmukuRoom()
-		if currentRoom >= len(rooms)-1 {
-			currentRoom = 0
-		} else {
-			currentRoom++
-		}
-		startDanmukuRoom()
-		playRoom()
-
-		view.SetData(getViewData(nil, nil))
-		view.Update()
-		changeChannel <- true
+		sendInt(roomChannel, 1)
 	})
 	view.OnKeyPrev(func(args ...interface{}) {
-		stopDanmukuRoom()
-		if currentRoom <= 0 {
-			currentRoom = len(rooms) - 1
-		} else {
-			currentRoom--
-		}
-		startDanmukuRoom()
-		playRoom()
-
-		view.SetData(getViewData(nil, nil))
-		view.Update()
-		changeChannel <- true
+		sendInt(roomChannel, -1)
+	})
+	view.OnKeyStats(func(args ...interface{}) {
+		select {
+		case statsChannel <- true:
+		case <-quitChannel:
+		}
 	})
 	view.OnKeyQuit(func(args ...interface{}) {
 		close(quitChannel)
@@ -89,29 +98,75 @@ func main() {
 	view.Update()
 	go view.MainLoop()
 
-	dataChannel := make(chan *view.Data)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go danmukuRules.Watch(ctx, *playlistFilename, filter.DefaultReloadInterval)
+
+	// 统计在事件流上查礼物, 只用已经获取的礼物列表, 斗鱼的房间先在后台获取
+	giftCache := gift.NewCache(gift.DefaultCacheDir())
+	for _, room := range rooms {
+		if room.Scheme == danmuku.DefaultScheme {
+			go giftCache.Prefetch(room.Id)
+		}
+	}
+	danmukuStats = stats.New(giftCache.Cached)
+	statsSub := danmukuHub.Subscribe(nil, 1024, danmuku.DropOldest)
 	go func() {
-		for {
-			danmukuRoom := danmukuRooms[currentRoom]
-			select {
-			case <-changeChannel:
-			case danmuku := <-danmukuRoom.GetDanmukuChannel():
-				dataChannel <- getViewData(view.GetData(), &danmuku)
-			}
+		for ev := range statsSub.Events() {
+			danmukuStats.Add(ev)
 		}
 	}()
 
-	startDanmukuRoom()
+	sub := danmukuHub.Subscribe(danmukuRules.Allow, 256, danmuku.DropOldest)
+	danmukuHub.Start(ctx)
+	defer danmukuHub.Stop()
 	playRoom()
 
-	mainLoop(dataChannel)
+	mainLoop(sub.Events())
 }
 
-func mainLoop(dataChannel chan *view.Data) {
+// sendInt 把按键交给 mainLoop, 退出后不再等待
+func sendInt(c chan int, v int) {
+	select {
+	case c <- v:
+	case <-quitChannel:
+	}
+}
+
+// mainLoop 处理弹幕和按键, 界面的数据只在这里更新.
+func mainLoop(events <-chan danmuku.Event) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
 	for {
 		select {
-		case data := <-dataChannel:
-			view.SetData(data)
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if ev.Addr() != rooms[currentRoom].String() {
+				continue
+			}
+			line, lineId, shown := displayLine(danmukuFlood, ev, time.Now())
+			if !shown {
+				continue
+			}
+			view.SetData(getViewData(view.GetData(), lineId, line))
+			view.Update()
+		case step := <-roomChannel:
+			currentRoom = (currentRoom + step + len(rooms)) % len(rooms)
+			playRoom()
+			view.SetData(getViewData(nil, 0, nil))
+			view.Update()
+		case maxLineCount = <-lineCountChannel:
+			view.SetData(getViewData(nil, 0, nil))
+			view.Update()
+		case <-statsChannel:
+			statsWindow = (statsWindow + 1) % len(statsWindows)
+			view.SetStats(getStatsLines())
+			view.Update()
+		case <-ticker.C:
+			view.SetStats(getStatsLines())
 			view.Update()
 		case <-quitChannel:
 			return
@@ -119,83 +174,212 @@ func mainLoop(dataChannel chan *view.Data) {
 	}
 }
 
-func parsePlaylist(playlistFilename string) (bool, []*room.DouyuRoom, []*danmuku.DanmukuRoom) {
+// parsePlaylist 读取播放列表, 列表里是 "douyu:156277" 这样的地址, 只写
+// 数字时是斗鱼的房间号.
+func parsePlaylist(playlistFilename, replayDir string) (bool, []*provider.Room, *danmuku.Hub) {
 	playlistData, err := ioutil.ReadFile(playlistFilename)
 	if err != nil {
 		log.Panic(err)
 	}
 	playlist := struct {
-		Debug    bool  `json:"debug"`
-		Playlist []int `json:"playlist"`
+		Debug    bool          `json:"debug"`
+		Playlist []interface{} `json:"playlist"`
 	}{}
 
 	if err := json.Unmarshal(playlistData, &playlist); err != nil {
 		log.Panic(err)
 	}
-	rooms := make([]*room.DouyuRoom, 0)
-	danmukuRooms := make([]*danmuku.DanmukuRoom, 0)
-	for _, roomId := range playlist.Playlist {
-		room, err := room.NewDouyuRoom(int(roomId))
+	rooms := make([]*provider.Room, 0)
+	roomsByAddr := make(map[string]*provider.Room)
+	hub := danmuku.NewHubWithSource(func(addr string) danmuku.Source {
+		return roomsByAddr[addr].Chat()
+	}, danmuku.DefaultBacklogSize)
+	if replayDir != "" {
+		hub = danmuku.NewHubWithSource(func(addr string) danmuku.Source {
+			replay, err := archive.OpenReplay(replayDir, addr)
+			if err != nil {
+				log.Println(err)
+				return archive.NewReplay(addr, nil)
+			}
+			return replay
+		}, danmuku.DefaultBacklogSize)
+	}
+	for _, entry := range playlist.Playlist {
+		addr, pref := playlistEntry(entry)
+		room, err := provider.Open(context.Background(), addr)
 		if err != nil {
 			log.Panic(err)
 		}
+		room.Preference = pref
+		// 弹幕按地址区分房间, 同一个房间写了多次时只保留第一个
+		if _, ok := roomsByAddr[room.String()]; ok {
+			log.Printf("skip %s: already in playlist", room)
+			continue
+		}
+		roomsByAddr[room.String()] = room
 		rooms = append(rooms, room)
-		danmukuRoom := danmuku.NewDanmukuRoom(int(roomId))
-		danmukuRooms = append(danmukuRooms, danmukuRoom)
+		hub.Add(room.String())
+	}
+	return playlist.Debug, rooms, hub
+}
+
+// playlistEntry 解析播放列表的一项. 一项可以是房间号, "douyu:156277" 这样
+// 的地址, 或者指定了直播流偏好的对象:
+//
+//	{"room": "huya:kpl", "quality": "lowest", "format": "flv", "cdn": "tx"}
+func playlistEntry(entry interface{}) (string, provider.Preference) {
+	var pref provider.Preference
+	if obj, ok := entry.(map[string]interface{}); ok {
+		data, _ := json.Marshal(obj)
+		if err := json.Unmarshal(data, &pref); err != nil {
+			log.Panic(err)
+		}
+		entry = obj["room"]
+	}
+	if roomId, ok := entry.(float64); ok {
+		return strconv.Itoa(int(roomId)), pref
+	}
+	if addr, ok := entry.(string); ok {
+		return addr, pref
+	}
+	return fmt.Sprint(entry), pref
+}
+
+func loadFilter(playlistFilename string) (*filter.Engine, *filter.Flood) {
+	cfg, err := filter.Load(playlistFilename)
+	if err != nil {
+		log.Panic(err)
+	}
+	engine, err := filter.New(cfg)
+	if err != nil {
+		log.Panic(err)
 	}
-	return playlist.Debug, rooms, danmukuRooms
+	return engine, engine.Flood()
+}
+
+func loadTheme(playlistFilename string) *theme.Theme {
+	t, err := theme.Load(playlistFilename)
+	if err != nil {
+		log.Panic(err)
+	}
+	view.SetMonochrome(t.Monochrome)
+	return t
 }
 
 func playRoom() {
 	room := rooms[currentRoom]
-	room.RefreshIfExpire(time.Minute * 2)
-	mainPlayer.ChangeLiveStreamUrl(room.LiveStreamUrl())
+	room.RefreshIfExpire(context.Background(), time.Minute*2)
+	mainPlayer.ChangeLiveStreamUrl(room.StreamUrl(context.Background()))
 	mainPlayer.Play()
 }
 
-func startDanmukuRoom() {
-	room := rooms[currentRoom]
-	if room.Online() {
-		curRoom := danmukuRooms[currentRoom]
-		curRoom.Start()
+func eventLine(ev danmuku.Event) (view.Line, bool) {
+	switch ev := ev.(type) {
+	case *danmuku.ChatMessage:
+		return danmukuTheme.Chat(ev, emote.Translate(ev.Text)), true
+	case *danmuku.SuperBroadcast:
+		return danmukuTheme.NoticeLine(ev.Sender + " 送给 " + ev.Receiver + " " + strconv.Itoa(ev.GiftCount) + " 个" + ev.GiftName), true
+	case *danmuku.LiveStatusChange:
+		if ev.Live() {
+			return danmukuTheme.NoticeLine("【开播了】"), true
+		}
+		return danmukuTheme.NoticeLine("【下播了】"), true
+	case *danmuku.StateChange:
+		switch ev.State {
+		case danmuku.StateConnected:
+			return danmukuTheme.NoticeLine("【弹幕已连接】"), true
+		case danmuku.StateRetrying:
+			return danmukuTheme.NoticeLine("【弹幕断开, 第" + strconv.Itoa(ev.Attempt) + "次重连】"), true
+		case danmuku.StateFailed:
+			return danmukuTheme.NoticeLine("【弹幕连接失败】"), true
+		}
 	}
+	return nil, false
 }
 
-func stopDanmukuRoom() {
-	room := rooms[currentRoom]
-	if room.Online() {
-		prevRoom := danmukuRooms[currentRoom]
-		prevRoom.Stop()
+// displayLine 返回事件显示的行, 弹幕经过 flood 合并, 合并的行带上计数.
+// lineId 为 0 的行不会被替换.
+func displayLine(flood *filter.Flood, ev danmuku.Event, t time.Time) (line view.Line, lineId uint64, ok bool) {
+	line, ok = eventLine(ev)
+	if !ok {
+		return nil, 0, false
+	}
+	chat, isChat := ev.(*danmuku.ChatMessage)
+	if !isChat {
+		return line, 0, true
+	}
+	collapsed, shown := flood.CheckAt(chat, t)
+	if !shown {
+		return nil, 0, false
+	}
+	if collapsed.Count > 1 {
+		line = append(line, view.Span{Text: " ×" + strconv.Itoa(collapsed.Count), Style: view.Style{Bold: true}})
 	}
+	return line, collapsed.Id, true
 }
 
-func getViewData(prevData *view.Data, newDanmuku *danmuku.Danmuku) *view.Data {
-	var danmukuData []string
+// getViewData 在 prevData 后面加上 newLine. lineId 不为 0 并且已经显示过时
+// 原地替换那一行, 用来更新合并弹幕的计数.
+func getViewData(prevData *view.Data, lineId uint64, newLine view.Line) *view.Data {
+	var danmukuData []view.Line
 	if prevData == nil {
-		danmukuData = []string{
-			"欢迎",
+		danmukuData = []view.Line{
+			view.Plain("欢迎"),
+		}
+		rightLineIds = []uint64{0}
+		// 历史弹幕用单独的 Flood 按收到的时间合并, 不影响实时弹幕的计数
+		flood := filter.NewFlood(danmukuFlood.Config())
+		for _, ev := range danmukuHub.Backlog(rooms[currentRoom].String()) {
+			if !danmukuRules.Allow(ev) {
+				continue
+			}
+			t := danmuku.ReceivedAt(ev)
+			if t.IsZero() {
+				t = time.Now()
+			}
+			line, id, ok := displayLine(flood, ev, t)
+			if !ok {
+				continue
+			}
+			if i := lineIndex(id); i >= 0 {
+				danmukuData[i] = line
+			} else {
+				danmukuData = append(danmukuData, line)
+				rightLineIds = append(rightLineIds, id)
+			}
+		}
+		if len(danmukuData) > maxLineCount {
+			danmukuData = danmukuData[len(danmukuData)-maxLineCount:]
 		}
+		// 这些 id 来自单独的 Flood, 不能和实时弹幕的 id 混在一起
+		rightLineIds = make([]uint64, len(danmukuData))
+	} else if i := lineIndex(lineId); i >= 0 {
+		danmukuData = append([]view.Line(nil), prevData.RightLines...)
+		danmukuData[i] = newLine
 	} else {
-		danmukuData = append(prevData.RightLines, newDanmuku.User+": "+newDanmuku.Content)
+		danmukuData = append(prevData.RightLines, newLine)
+		rightLineIds = append(rightLineIds, lineId)
 		if len(danmukuData) > maxLineCount {
 			danmukuData =
 				danmukuData[len(danmukuData)-maxLineCount : len(danmukuData)]
+			rightLineIds = rightLineIds[len(rightLineIds)-maxLineCount:]
 		}
 	}
 
 	room := rooms[currentRoom]
+	info := room.Info()
 	onlineStr := ""
-	if room.Online() {
+	if info.Online {
 		onlineStr = "【在线】"
 	} else {
 		onlineStr = "【离线】"
 	}
 	data := view.Data{
 		[]string{
-			onlineStr + room.Nickname(),
-			"#" + strconv.Itoa(room.RoomId()),
-			room.RoomName(),
-			room.GameName(),
+			onlineStr + info.Nickname,
+			"#" + room.String(),
+			info.Title,
+			info.Category,
 			//			strings.Replace(room.Details(), "\n", " ", -1),
 			//			room.LiveStreamUrl(),
 		},
@@ -205,3 +389,55 @@ func getViewData(prevData *view.Data, newDanmuku *danmuku.Danmuku) *view.Data {
 
 	return &data
 }
+
+func lineIndex(lineId uint64) int {
+	if lineId == 0 {
+		return -1
+	}
+	for i := len(rightLineIds) - 1; i >= 0; i-- {
+		if rightLineIds[i] == lineId {
+			return i
+		}
+	}
+	return -1
+}
+
+func getStatsLines() []string {
+	window := statsWindows[statsWindow]
+	s := danmukuStats.Snapshot(rooms[currentRoom].String(), window)
+	title := "【统计 本场】"
+	switch window {
+	case stats.Minute:
+		title = "【统计 1分钟】"
+	case stats.TenMinutes:
+		title = "【统计 10分钟】"
+	}
+	lines := []string{
+		title,
+		fmt.Sprintf("弹幕 %d 条 (%.1f/分钟)", s.Messages, s.PerMinute),
+		fmt.Sprintf("发言 %d 人  进房 %d 人", s.Chatters, s.Enters),
+		fmt.Sprintf("礼物 ¥%.1f", s.GiftValue),
+	}
+	if len(s.TopChatters) > 0 {
+		line := "话痨:"
+		for _, c := range s.TopChatters {
+			line += fmt.Sprintf(" %s(%d)", c.Key, c.Count)
+		}
+		lines = append(lines, line)
+	}
+	if len(s.TopTokens) > 0 {
+		line := "热词:"
+		for _, c := range s.TopTokens {
+			line += fmt.Sprintf(" %s(%d)", c.Key, c.Count)
+		}
+		lines = append(lines, line)
+	}
+	if len(s.TopGifters) > 0 {
+		line := "土豪:"
+		for _, v := range s.TopGifters {
+			line += fmt.Sprintf(" %s(¥%.1f)", v.Key, v.Value)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
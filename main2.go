@@ -41,22 +41,26 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
-	"regexp"
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/zwh8800/Love66/archive"
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/emote"
+	"github.com/zwh8800/Love66/export"
+	"github.com/zwh8800/Love66/gift"
 )
 
 type DouyuLiveData struct {
@@ -182,216 +186,176 @@ func WaitPlay(pipeReader *io.PipeReader) {
 	go io.Copy(ioutil.Discard, bufReader)
 }
 
-const (
-	OpenDouyuAddr        = "openbarrage.douyutv.com:8601"
-	MsgTypeSend   uint16 = 689
-	MsgTypeRecv   uint16 = 690
-)
-
-func sendMsg(conn net.Conn, msg string) error {
-	msgLen := len(msg) + 1 + 12
-	buf := make([]byte, msgLen)
-	binary.LittleEndian.PutUint32(buf[0:4], uint32(msgLen-4))
-	binary.LittleEndian.PutUint32(buf[4:8], uint32(msgLen-4))
-	binary.LittleEndian.PutUint16(buf[8:10], MsgTypeSend)
-	copy(buf[12:], []byte(msg))
-
-	_, err := conn.Write(buf)
-	return err
-}
-
-func danmukuLogin(conn net.Conn, roomId int) {
-	msg := fmt.Sprintf("type@=loginreq/roomid@=%d/", roomId)
-	if err := sendMsg(conn, msg); err != nil {
-		log.Println(err)
-	}
-}
-
-func danmukuJoin(conn net.Conn, roomId int) {
-	msg := fmt.Sprintf("type@=joingroup/rid@=%d/gid@=-9999/", roomId)
-	if err := sendMsg(conn, msg); err != nil {
-		log.Println(err)
-	}
-}
-
-func danmukuKeeplive(conn net.Conn) {
-	msg := fmt.Sprintf("type@=keeplive/tick@=%d/", time.Now().Unix())
-	if err := sendMsg(conn, msg); err != nil {
-		log.Println(err)
-	}
-}
-
-func parseMessage(message string) map[string]string {
-	msg := make(map[string]string)
-	regex := regexp.MustCompile(`(.*?)@=(.*?)/`)
-	submatchs := regex.FindAllStringSubmatch(message, -1)
-
-	for _, submatch := range submatchs {
-		msg[submatch[1]] = submatch[2]
-	}
-	return msg
-}
-
-func readMessage(conn net.Conn) (string, error) {
-	var (
-		length      uint32
-		length2     uint32
-		messageType uint16
-		unused      uint16
-	)
-	if err := binary.Read(conn, binary.LittleEndian, &length); err != nil {
-		return "", err
-	}
-	if err := binary.Read(conn, binary.LittleEndian, &length2); err != nil {
-		return "", err
-	}
-	if length != length2 {
-		return "", fmt.Errorf("243: length(%d) != length2(%d)\n", length, length2)
-	}
-	if err := binary.Read(conn, binary.LittleEndian, &messageType); err != nil {
-		return "", err
-	}
-	if messageType != MsgTypeRecv {
-		return "", fmt.Errorf("249: messageData(%d) != typeRecv\n", messageType)
-	}
-	if err := binary.Read(conn, binary.LittleEndian, &unused); err != nil {
-		return "", err
-	}
-	length = length - 8
-	messageData := make([]byte, length)
-
-	for i := 0; i < int(length); {
-		n, err := conn.Read(messageData[i:])
-		if err != nil {
-			return "", err
+func printEvent(ev danmuku.Event) {
+	switch ev := ev.(type) {
+	case *danmuku.ChatMessage:
+		colorCode := ""
+		switch ev.Color {
+		case danmuku.ColorRed:
+			colorCode = "\033[1;91m"
+		case danmuku.ColorBlue:
+			colorCode = "\033[1;94m"
+		case danmuku.ColorGreen:
+			colorCode = "\033[1;92m"
+		case danmuku.ColorYellow:
+			colorCode = "\033[1;93m"
+		case danmuku.ColorPurple:
+			colorCode = "\033[1;38;5;129m"
+		case danmuku.ColorPink:
+			colorCode = "\033[1;38;5;213m"
+		default:
+			colorCode = "\033[1m"
 		}
-		i += n
+		log.Printf("%s(%d): %s%s\033[0m", ev.Nickname, ev.Uid, colorCode, emote.Translate(ev.Text))
+	case *gift.Combo:
+		log.Printf("%s(%d) \033[90m%s\033[0m", ev.Nickname, ev.Uid, ev)
+	default:
+		// log.Printf("%#v", ev)
 	}
 
-	return string(messageData), nil
-}
-
-type GiftData struct {
-	Data struct {
-		Gift []struct {
-			Desc  string  `json:"desc"`
-			Gx    float64 `json:"gx"`
-			Himg  string  `json:"himg"`
-			ID    string  `json:"id"`
-			Intro string  `json:"intro"`
-			Mimg  string  `json:"mimg"`
-			Name  string  `json:"name"`
-			Pc    float64 `json:"pc"`
-			Type  string  `json:"type"`
-		} `json:"gift"`
-	} `json:"data"`
-	Error int `json:"error"`
 }
 
-var gifts = make(map[string]string)
+// Danmuku 打印房间的弹幕, server 不为空时连接到这个地址的弹幕服务器,
+// 比如 cmd/fakedouyu 启动的假服务器.
+func Danmuku(roomId int, server string) {
+	gifts := gift.NewCache(gift.DefaultCacheDir())
+	gifts.Prefetch(roomId)
 
-func getGiftList(roomId int) {
-
-	resp, err := http.Get(fmt.Sprintf("http://open.douyucdn.cn/api/RoomApi/room/%d", roomId))
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println(err)
-		return
+	room := danmuku.NewDanmukuRoom(roomId)
+	if server != "" {
+		room = danmuku.NewDanmukuRoomWithTransport(roomId, &danmuku.OpenBarrageTransport{Addr: server})
 	}
-
-	var giftData GiftData
-	if err := json.Unmarshal(data, &giftData); err != nil {
+	sub := room.Subscribe(nil, 256, danmuku.Block)
+	if err := room.Start(context.Background()); err != nil {
 		log.Println(err)
 		return
 	}
+	gift.NewCombiner(gifts.Cached).Run(context.Background(), sub, printEvent)
+}
 
-	log.Println("giftData =", JsonStringify(giftData, true))
+// Record 把房间的弹幕录制到 dir, 收到信号后写完缓冲再退出
+func Record(roomId int, dir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		log.Println("bye")
+		cancel()
+	}()
 
-	if giftData.Error != 0 {
-		log.Printf("giftData.error = %d\n", giftData.Error)
+	room := danmuku.NewDanmukuRoom(roomId)
+	sub := room.Subscribe(nil, 1024, danmuku.Block)
+	if err := room.Start(ctx); err != nil {
+		log.Println(err)
 		return
 	}
+	defer room.Stop()
 
-	for _, gift := range giftData.Data.Gift {
-		gifts[gift.ID] = gift.Name
+	log.Printf("recording room %d to %s", roomId, dir)
+	if err := archive.NewRecorder(dir).Record(ctx, sub); err != nil {
+		log.Println(err)
 	}
 }
 
-func danmukuReadAndPrint(conn net.Conn) {
-	msgStr, err := readMessage(conn)
+// Export 把存档导出成弹幕文件, 用法:
+//
+//	main2 export [-format xml|ass] [-offset 1.5s] [-room addr] [-o file] archive...
+//
+// archive 可以是存档文件或者目录, 目录时导出 -room 指定房间的所有存档.
+func Export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "ass", "output format, xml or ass")
+	offset := flags.Duration("offset", 0, "add to every danmu time")
+	room := flags.String("room", "", "room address like 156277 or bilibili:1, empty for all rooms")
+	output := flags.String("o", "", "output file, default stdout")
+	flags.Parse(args)
+
+	files := make([]string, 0)
+	for _, path := range flags.Args() {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirFiles, err := archive.Files(path, *room)
+			if err != nil {
+				log.Fatal(err)
+			}
+			files = append(files, dirFiles...)
+		} else {
+			files = append(files, path)
+		}
+	}
+	records, err := archive.ReadFiles(files...)
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
 	}
-	message := parseMessage(msgStr)
-
-	switch message["type"] {
-	case "chatmsg":
-		colorCode := ""
-		switch message["col"] {
-		case "1": // 红
-			colorCode = "\033[1;91m"
-		case "2": // 蓝
-			colorCode = "\033[1;94m"
-		case "3": // 绿
-			colorCode = "\033[1;92m"
-		case "4": // 黄
-			colorCode = "\033[1;93m"
-		case "5": // 紫
-			colorCode = "\033[1;38;5;129m"
-		case "6": // 粉
-			colorCode = "\033[1;38;5;213m"
-		default:
-			colorCode = "\033[1m"
+	opts := export.Options{Offset: *offset}
+	if *room != "" {
+		scheme, roomId, err := danmuku.ParseRoomAddr(*room)
+		if err != nil {
+			log.Fatal(err)
 		}
-		log.Printf("%s(%s): %s%s\033[0m", message["nn"], message["uid"], colorCode, message["txt"])
-	case "dgb":
-		hits := message["hits"]
-		if hits == "" {
-			hits = "1"
+		addr := danmuku.RoomAddr(scheme, roomId)
+		filtered := records[:0]
+		for _, rec := range records {
+			if rec.RoomAddr() == addr {
+				filtered = append(filtered, rec)
+			}
+		}
+		records = filtered
+		if scheme == danmuku.DefaultScheme {
+			gifts := gift.NewCache(gift.DefaultCacheDir())
+			opts.GiftName = func(giftId string) string {
+				if g := gifts.Lookup(roomId, giftId); g != nil {
+					return g.Name
+				}
+				return "礼物" + giftId
+			}
 		}
-		log.Printf("%s(%s) \033[90m送出 %s (%s 连击)\033[0m", message["nn"], message["uid"], gifts[message["gfid"]], hits)
-	default:
-		// log.Printf("%#v", message)
 	}
+	comments := export.Comments(records, opts)
 
-}
-
-func Danmuku(roomId int) {
-	getGiftList(roomId)
-
-	conn, err := net.Dial("tcp", OpenDouyuAddr)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	danmukuLogin(conn, roomId)
-	danmukuJoin(conn, roomId)
-	go func() {
-		for {
-			danmukuKeeplive(conn)
-			time.Sleep(30 * time.Second)
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}()
-
-	for {
-		danmukuReadAndPrint(conn)
+		defer file.Close()
+		w = file
+	}
+	switch *format {
+	case "xml":
+		err = export.WriteXML(w, comments)
+	case "ass":
+		err = export.WriteASS(w, comments, export.DefaultASSOptions)
+	default:
+		log.Fatal("unknown format: ", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
+	log.Printf("exported %d danmu", len(comments))
 }
 
 func main() {
 	log.SetFlags(log.Lshortfile | log.LstdFlags)
 
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		Export(os.Args[2:])
+		return
+	}
+
 	roomId := flag.Int("id", 156277, "room id")
 	onlyDanmu := flag.Bool("d", false, "only danmu")
 	watchVideo := flag.Bool("v", false, "watch video")
+	recordDir := flag.String("record", "", "record danmu to dir")
+	server := flag.String("server", "", "danmu server address, for cmd/fakedouyu")
 	flag.Parse()
 
+	if *recordDir != "" {
+		Record(*roomId, *recordDir)
+		return
+	}
+
 	go func() {
 		c := make(chan os.Signal)
 		signal.Notify(c, os.Interrupt, os.Kill)
@@ -411,7 +375,7 @@ func main() {
 		os.Exit(0)
 	}()
 
-	go Danmuku(*roomId)
+	go Danmuku(*roomId, *server)
 
 	for {
 		if *onlyDanmu {
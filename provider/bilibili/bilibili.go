@@ -0,0 +1,177 @@
+// Package bilibili 是 Bilibili 直播的 provider, 导入后可以使用
+// "bilibili:21452505" 这样的地址.
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/provider"
+)
+
+// Scheme 是 Bilibili 房间地址和事件里的平台名
+const Scheme = "bilibili"
+
+// API 是直播接口的地址, 测试时可以换成假服务器
+var API = "https://api.live.bilibili.com"
+
+const (
+	// DefaultChatServer 在取不到弹幕服务器列表时使用
+	DefaultChatServer = "wss://broadcastlv.chat.bilibili.com/sub"
+	DefaultTimeout    = 10 * time.Second
+)
+
+var httpClient = &http.Client{Timeout: DefaultTimeout}
+
+func init() {
+	provider.Register(Scheme, &Provider{})
+}
+
+type Provider struct {
+	// Dialer 用来连接弹幕服务器, 为 nil 时使用 websocket.DefaultDialer
+	Dialer *websocket.Dialer
+}
+
+// getJSON 请求 API 下的 path, 检查返回的 code 后把 data 解析到 v
+func getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	req, err := http.NewRequest("GET", API+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bilibili: %s: %s", path, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("bilibili: %s: error %d: %s", path, result.Code, result.Message)
+	}
+	return json.Unmarshal(result.Data, v)
+}
+
+type roomInfo struct {
+	RoomInfo struct {
+		RoomId     int    `json:"room_id"`
+		ShortId    int    `json:"short_id"`
+		Uid        int    `json:"uid"`
+		Title      string `json:"title"`
+		LiveStatus int    `json:"live_status"`
+		AreaName   string `json:"area_name"`
+	} `json:"room_info"`
+	AnchorInfo struct {
+		BaseInfo struct {
+			Uname string `json:"uname"`
+		} `json:"base_info"`
+	} `json:"anchor_info"`
+}
+
+func getRoomInfo(ctx context.Context, roomId int) (*roomInfo, error) {
+	var info roomInfo
+	err := getJSON(ctx, "/xlive/web-room/v1/index/getInfoByRoom", url.Values{"room_id": {strconv.Itoa(roomId)}}, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Resolve 把短号换成真实的房间号
+func (p *Provider) Resolve(ctx context.Context, id string) (int, error) {
+	roomId, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, provider.ErrBadAddr
+	}
+	info, err := getRoomInfo(ctx, roomId)
+	if err != nil {
+		return 0, err
+	}
+	return info.RoomInfo.RoomId, nil
+}
+
+func (p *Provider) Info(ctx context.Context, roomId int) (*provider.Info, error) {
+	info, err := getRoomInfo(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	return &provider.Info{
+		Online:   info.RoomInfo.LiveStatus == 1,
+		Title:    info.RoomInfo.Title,
+		Nickname: info.AnchorInfo.BaseInfo.Uname,
+		Category: info.RoomInfo.AreaName,
+	}, nil
+}
+
+// Streams 返回原画的 flv 流, 没有直播时为空
+func (p *Provider) Streams(ctx context.Context, roomId int) ([]provider.Stream, error) {
+	var playUrl struct {
+		CurrentQn int `json:"current_qn"`
+		Qualities []struct {
+			Qn   int    `json:"qn"`
+			Desc string `json:"desc"`
+		} `json:"quality_description"`
+		Durl []struct {
+			Url string `json:"url"`
+		} `json:"durl"`
+	}
+	query := url.Values{"cid": {strconv.Itoa(roomId)}, "platform": {"web"}, "qn": {"10000"}}
+	if err := getJSON(ctx, "/room/v1/Room/playUrl", query, &playUrl); err != nil {
+		return nil, err
+	}
+	quality := ""
+	for _, q := range playUrl.Qualities {
+		if q.Qn == playUrl.CurrentQn {
+			quality = q.Desc
+		}
+	}
+	streams := make([]provider.Stream, 0, len(playUrl.Durl))
+	for _, d := range playUrl.Durl {
+		streams = append(streams, provider.Stream{Url: d.Url, Quality: quality, Format: "flv"})
+	}
+	return streams, nil
+}
+
+func (p *Provider) Chat(roomId int) danmuku.Source {
+	return NewChatRoom(roomId, p.Dialer)
+}
+
+type danmuInfo struct {
+	Token    string `json:"token"`
+	HostList []struct {
+		Host    string `json:"host"`
+		WssPort int    `json:"wss_port"`
+	} `json:"host_list"`
+}
+
+// chatServer 返回弹幕服务器地址和认证用的 token, 接口出错时使用
+// DefaultChatServer 和空 token.
+func chatServer(ctx context.Context, roomId int) (string, string) {
+	var info danmuInfo
+	err := getJSON(ctx, "/xlive/web-room/v1/index/getDanmuInfo", url.Values{"id": {strconv.Itoa(roomId)}, "type": {"0"}}, &info)
+	if err != nil || len(info.HostList) == 0 {
+		return DefaultChatServer, ""
+	}
+	host := info.HostList[0]
+	return fmt.Sprintf("wss://%s:%d/sub", host.Host, host.WssPort), info.Token
+}
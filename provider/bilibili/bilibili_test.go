@@ -0,0 +1,355 @@
+package bilibili
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/provider"
+)
+
+const (
+	danmuMsg = `{"cmd":"DANMU_MSG:4:0:2:2:2:0","info":[[0,1,25,16738408,1700000000000,1700000000,0,"8b4f1c2e",0,0,0,"",0,"{}","{}",{}],
+"主播好",[12345,"观众甲",1,0,0,10000,1,""],[21,"粉丝团","主播乙",21452505,398668,"",0,0,0,0,0,0],[31,0,9868950,">50000"],["",""],0,0,null,{"ts":1700000000,"ct":"A"},0,0,null,null,0,105]}`
+	sendGift         = `{"cmd":"SEND_GIFT","data":{"giftId":31036,"giftName":"小花花","num":3,"super_gift_num":2,"uid":23456,"uname":"观众丙","face":"https://i0.hdslb.com/face.jpg","price":100,"coin_type":"gold","action":"投喂","medal_info":{"medal_name":"粉丝团","medal_level":5}}}`
+	silverGift       = `{"cmd":"SEND_GIFT","data":{"giftId":1,"giftName":"辣条","num":10,"uid":1,"uname":"a","price":100,"coin_type":"silver"}}`
+	interactEnterMsg = `{"cmd":"INTERACT_WORD","data":{"uid":34567,"uname":"观众丁","msg_type":1,"roomid":21452505,"fans_medal":{"medal_name":"","medal_level":0}}}`
+	interactLikeMsg  = `{"cmd":"INTERACT_WORD","data":{"uid":34567,"uname":"观众丁","msg_type":2,"roomid":21452505}}`
+	onlineRank       = `{"cmd":"ONLINE_RANK_COUNT","data":{"count":100}}`
+)
+
+func compress(version uint16, packets ...*Packet) *Packet {
+	var buf bytes.Buffer
+	var w interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	if version == VersionBrotli {
+		w = brotli.NewWriter(&buf)
+	} else {
+		w = zlib.NewWriter(&buf)
+	}
+	for _, p := range packets {
+		w.Write(p.Encode())
+	}
+	w.Close()
+	return &Packet{Version: version, Operation: OpCommand, Body: buf.Bytes()}
+}
+
+func commandPacket(body string) *Packet {
+	return &Packet{Version: VersionJSON, Operation: OpCommand, Body: []byte(body)}
+}
+
+func TestPackets(t *testing.T) {
+	heartbeat := &Packet{Version: VersionHeartbeat, Operation: OpHeartbeatReply, Body: []byte{0, 0, 1, 0}}
+	data := heartbeat.Encode()
+	if !bytes.Equal(data[:16], []byte{0, 0, 0, 20, 0, 16, 0, 1, 0, 0, 0, 3, 0, 0, 0, 1}) {
+		t.Errorf("unexpected header % x", data[:16])
+	}
+
+	data = append(data, compress(VersionBrotli, commandPacket("1"), commandPacket("2")).Encode()...)
+	data = append(data, compress(VersionZlib, commandPacket("3")).Encode()...)
+	packets, err := DecodePackets(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := make([]string, 0)
+	for _, p := range packets {
+		bodies = append(bodies, fmt.Sprintf("%d:%s", p.Operation, p.Body))
+	}
+	if got := strings.Join(bodies, " "); got != "3:\x00\x00\x01\x00 5:1 5:2 5:3" {
+		t.Errorf("unexpected packets %q", got)
+	}
+
+	bad := [][]byte{
+		data[:10],
+		data[:19],
+		{0, 0, 0, 16, 0, 8, 0, 0, 0, 0, 0, 5, 0, 0, 0, 1},
+		(&Packet{Version: VersionZlib, Operation: OpCommand, Body: []byte("junk")}).Encode(),
+	}
+	for _, b := range bad {
+		if _, err := DecodePackets(b); err == nil {
+			t.Errorf("DecodePackets(% x): expected error", b)
+		}
+	}
+
+	// 解压后太大的包不展开
+	huge := commandPacket(strings.Repeat("6", maxDecompressedSize))
+	for _, version := range []uint16{VersionZlib, VersionBrotli} {
+		if _, err := DecodePackets(compress(version, huge).Encode()); err != errTooLarge {
+			t.Errorf("version %d: expected errTooLarge, got %v", version, err)
+		}
+	}
+}
+
+func TestDecodeCommand(t *testing.T) {
+	ev, err := DecodeCommand([]byte(danmuMsg), 21452505)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat, ok := ev.(*danmuku.ChatMessage)
+	if !ok || chat.Room() != 21452505 || chat.Addr() != "bilibili:21452505" || chat.EventType() != "chatmsg" || chat.Text != "主播好" ||
+		chat.Uid != 12345 || chat.Nickname != "观众甲" || chat.Level != 31 ||
+		chat.Role != danmuku.RoleModerator || chat.Color != danmuku.ColorPink ||
+		chat.BadgeName != "粉丝团" || chat.BadgeLevel != 21 || chat.BadgeRoom != 21452505 {
+		t.Errorf("unexpected chat %#v", ev)
+	}
+
+	ev, err = DecodeCommand([]byte(sendGift), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := ev.(*danmuku.Gift)
+	if !ok || g.GiftId != "31036" || g.GiftName != "小花花" || g.Count != 3 || g.Hits != 2 ||
+		g.Price != 0.1 || g.Nickname != "观众丙" || g.Uid != 23456 || g.BadgeLevel != 5 {
+		t.Errorf("unexpected gift %#v", ev)
+	}
+	ev, _ = DecodeCommand([]byte(silverGift), 1)
+	if g := ev.(*danmuku.Gift); g.Price != 0 {
+		t.Error("silver gift should be free", g.Price)
+	}
+
+	ev, err = DecodeCommand([]byte(interactEnterMsg), 1)
+	if enter, ok := ev.(*danmuku.UserEnter); err != nil || !ok || enter.Uid != 34567 || enter.Nickname != "观众丁" {
+		t.Errorf("unexpected enter %#v %v", ev, err)
+	}
+	for _, body := range []string{interactLikeMsg, onlineRank} {
+		if ev, err := DecodeCommand([]byte(body), 1); ev != nil || err != nil {
+			t.Errorf("DecodeCommand(%s) = %#v, %v", body, ev, err)
+		}
+	}
+	for _, body := range []string{`{`, `{"cmd":"DANMU_MSG","info":[[],"x"]}`, `{"cmd":"SEND_GIFT","data":[]}`} {
+		if _, err := DecodeCommand([]byte(body), 1); err == nil {
+			t.Errorf("DecodeCommand(%s): expected error", body)
+		}
+	}
+}
+
+// fakeAPI 启动假的直播接口并修改 API, chat 是 getDanmuInfo 返回的弹幕服务器地址
+func fakeAPI(chat string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/xlive/web-room/v1/index/getInfoByRoom":
+			switch q.Get("room_id") {
+			case "1", "21452505":
+				fmt.Fprint(w, `{"code":0,"message":"0","data":{"room_info":{"room_id":21452505,"short_id":1,"uid":2,
+"title":"测试直播","live_status":1,"area_name":"单机游戏"},"anchor_info":{"base_info":{"uname":"主播乙"}}}}`)
+			case "2":
+				fmt.Fprint(w, `{"code":0,"message":"0","data":{"room_info":{"room_id":2,"title":"休息","live_status":0},"anchor_info":{}}}`)
+			default:
+				fmt.Fprint(w, `{"code":19002000,"message":"获取初始化数据失败","data":null}`)
+			}
+		case "/room/v1/Room/playUrl":
+			if q.Get("cid") != "21452505" {
+				fmt.Fprint(w, `{"code":0,"message":"0","data":{"current_qn":0,"quality_description":null,"durl":[]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"code":0,"message":"0","data":{"current_qn":10000,"quality_description":[{"qn":10000,"desc":"原画"},{"qn":150,"desc":"高清"}],
+"durl":[{"url":"https://cn-gotcha01.bilivideo.com/live-bvc/1.flv"},{"url":"https://cn-gotcha02.bilivideo.com/live-bvc/1.flv"}]}}`)
+		case "/xlive/web-room/v1/index/getDanmuInfo":
+			u, _ := url.Parse(chat)
+			host, port := u.Hostname(), u.Port()
+			fmt.Fprintf(w, `{"code":0,"message":"0","data":{"token":"token-%s","host_list":[{"host":"%s","port":2243,"wss_port":%s,"ws_port":2244}]}}`,
+				q.Get("id"), host, port)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	API = server.URL
+	return server
+}
+
+func TestProvider(t *testing.T) {
+	defer func(u string) { API = u }(API)
+	api := fakeAPI("wss://127.0.0.1:1/sub")
+	defer api.Close()
+	ctx := context.Background()
+
+	r, err := provider.Open(ctx, "bilibili:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := r.Info()
+	if r.String() != "bilibili:21452505" || !info.Online || info.Title != "测试直播" ||
+		info.Nickname != "主播乙" || info.Category != "单机游戏" {
+		t.Errorf("unexpected room %s %#v", r, info)
+	}
+	streams, err := r.Provider.Streams(ctx, r.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 || streams[0].Quality != "原画" || streams[0].Format != "flv" ||
+		r.StreamUrl(ctx) != "https://cn-gotcha01.bilivideo.com/live-bvc/1.flv" {
+		t.Errorf("unexpected streams %#v", streams)
+	}
+
+	r, err = provider.Open(ctx, "bilibili:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Info().Online || r.StreamUrl(ctx) != "" {
+		t.Errorf("unexpected room %s %#v", r, r.Info())
+	}
+
+	for _, addr := range []string{"bilibili:3", "bilibili:abc"} {
+		if _, err := provider.Open(ctx, addr); err == nil {
+			t.Errorf("Open(%s): expected error", addr)
+		}
+	}
+	if server, token := chatServer(ctx, 21452505); server != "wss://127.0.0.1:1/sub" || token != "token-21452505" {
+		t.Error("unexpected chat server", server, token)
+	}
+	API = "http://127.0.0.1:1"
+	if server, token := chatServer(ctx, 21452505); server != DefaultChatServer || token != "" {
+		t.Error("unexpected chat server", server, token)
+	}
+}
+
+// fakeChat 是弹幕服务器的替身: 检查认证包, 回复心跳, 并把 packets
+// 里的消息发给每个连接.
+type fakeChat struct {
+	*httptest.Server
+	rejectAuth bool
+	packets    [][]byte
+
+	mutex      sync.Mutex
+	auths      []map[string]interface{}
+	heartbeats int
+}
+
+func newFakeChat(packets ...[]byte) *fakeChat {
+	c := &fakeChat{packets: packets}
+	upgrader := websocket.Upgrader{}
+	c.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c.serve(conn)
+	}))
+	return c
+}
+
+func (c *fakeChat) serve(conn *websocket.Conn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		packets, err := DecodePackets(data)
+		if err != nil {
+			return
+		}
+		for _, p := range packets {
+			switch p.Operation {
+			case OpAuth:
+				var auth map[string]interface{}
+				json.Unmarshal(p.Body, &auth)
+				c.mutex.Lock()
+				c.auths = append(c.auths, auth)
+				c.mutex.Unlock()
+				code := 0
+				if c.rejectAuth {
+					code = -101
+				}
+				reply := &Packet{Version: VersionHeartbeat, Operation: OpAuthReply, Body: []byte(fmt.Sprintf(`{"code":%d}`, code))}
+				conn.WriteMessage(websocket.BinaryMessage, reply.Encode())
+				for _, data := range c.packets {
+					conn.WriteMessage(websocket.BinaryMessage, data)
+				}
+			case OpHeartbeat:
+				c.mutex.Lock()
+				c.heartbeats++
+				c.mutex.Unlock()
+				reply := &Packet{Version: VersionHeartbeat, Operation: OpHeartbeatReply, Body: []byte{0, 0, 0, 1}}
+				conn.WriteMessage(websocket.BinaryMessage, reply.Encode())
+			}
+		}
+	}
+}
+
+func (c *fakeChat) dialer() *websocket.Dialer {
+	return &websocket.Dialer{TLSClientConfig: c.Client().Transport.(*http.Transport).TLSClientConfig}
+}
+
+func TestChatRoom(t *testing.T) {
+	chat := newFakeChat(
+		compress(VersionBrotli, commandPacket(danmuMsg), commandPacket(onlineRank)).Encode(),
+		compress(VersionZlib, commandPacket(sendGift)).Encode(),
+		commandPacket(interactEnterMsg).Encode(),
+	)
+	defer chat.Close()
+	defer func(u string) { API = u }(API)
+	api := fakeAPI(strings.Replace(chat.URL, "https", "wss", 1) + "/sub")
+	defer api.Close()
+
+	r := (&Provider{Dialer: chat.dialer()}).Chat(21452505).(*danmuku.DanmukuRoom)
+	r.KeepAliveInterval = 10 * time.Millisecond
+	sub := r.Subscribe(nil, 16, danmuku.Block)
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	types := make([]string, 0)
+	for len(types) < 5 {
+		select {
+		case ev := <-sub.Events():
+			if ev.Addr() != "bilibili:21452505" {
+				t.Errorf("unexpected room %s", ev.Addr())
+			}
+			types = append(types, ev.EventType())
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout, got", types)
+		}
+	}
+	if got := strings.Join(types, " "); got != "connstate connstate chatmsg dgb uenter" {
+		t.Error("unexpected events", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	chat.mutex.Lock()
+	defer chat.mutex.Unlock()
+	if len(chat.auths) != 1 || chat.auths[0]["roomid"] != float64(21452505) ||
+		chat.auths[0]["key"] != "token-21452505" || chat.auths[0]["protover"] != float64(3) {
+		t.Errorf("unexpected auth %v", chat.auths)
+	}
+	if chat.heartbeats < 2 {
+		t.Error("expected heartbeats, got", chat.heartbeats)
+	}
+}
+
+func TestChatRoomAuthFailed(t *testing.T) {
+	chat := newFakeChat()
+	chat.rejectAuth = true
+	defer chat.Close()
+	defer func(u string) { API = u }(API)
+	api := fakeAPI(strings.Replace(chat.URL, "https", "wss", 1) + "/sub")
+	defer api.Close()
+
+	r := NewChatRoom(21452505, chat.dialer())
+	r.Backoff = danmuku.Backoff{Min: time.Millisecond, Max: time.Millisecond, Factor: 1, MaxRetries: 1}
+	r.Start(context.Background())
+	select {
+	case <-r.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if r.Err() != ErrAuthFailed {
+		t.Errorf("Err() = %v, want ErrAuthFailed", r.Err())
+	}
+}
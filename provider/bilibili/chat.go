@@ -0,0 +1,117 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+const (
+	DefaultHeartbeatInterval = 30 * time.Second
+	authTimeout              = 10 * time.Second
+)
+
+var ErrAuthFailed = errors.New("bilibili: auth failed")
+
+var heartbeatPacket = (&Packet{Version: VersionHeartbeat, Operation: OpHeartbeat, Body: []byte("[object Object]")}).Encode()
+
+// NewChatRoom 返回一个直播间的弹幕, dialer 为 nil 时使用 websocket.DefaultDialer.
+func NewChatRoom(roomId int, dialer *websocket.Dialer) *danmuku.DanmukuRoom {
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	return danmuku.NewChatRoom(Scheme, roomId, func(ctx context.Context, roomId int) (danmuku.ChatConn, error) {
+		return dial(ctx, dialer, roomId)
+	}, DefaultHeartbeatInterval)
+}
+
+type chatConn struct {
+	*websocket.Conn
+	roomId int
+}
+
+// dial 连接弹幕服务器并发送认证包, 等待认证成功.
+func dial(ctx context.Context, dialer *websocket.Dialer, roomId int) (*chatConn, error) {
+	addr, token := chatServer(ctx, roomId)
+	conn, _, err := dialer.DialContext(ctx, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	release := danmuku.CloseOnDone(ctx, conn)
+	defer release()
+	auth, _ := json.Marshal(map[string]interface{}{
+		"uid":      0,
+		"roomid":   roomId,
+		"protover": VersionBrotli,
+		"platform": "web",
+		"type":     2,
+		"key":      token,
+	})
+	packet := &Packet{Version: VersionHeartbeat, Operation: OpAuth, Body: auth}
+	if err := conn.WriteMessage(websocket.BinaryMessage, packet.Encode()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		packets, err := DecodePackets(data)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		for _, p := range packets {
+			if p.Operation != OpAuthReply {
+				continue
+			}
+			var reply struct {
+				Code int `json:"code"`
+			}
+			if json.Unmarshal(p.Body, &reply) != nil || reply.Code != 0 {
+				conn.Close()
+				return nil, ErrAuthFailed
+			}
+			return &chatConn{conn, roomId}, nil
+		}
+	}
+}
+
+func (c *chatConn) Read() ([]danmuku.Event, error) {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	packets, err := DecodePackets(data)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]danmuku.Event, 0, len(packets))
+	for _, p := range packets {
+		if p.Operation != OpCommand {
+			continue
+		}
+		ev, err := DecodeCommand(p.Body, c.roomId)
+		if err != nil {
+			log.Println("bilibili: decode:", err)
+			continue
+		}
+		if ev != nil {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+func (c *chatConn) Heartbeat() error {
+	return c.WriteMessage(websocket.BinaryMessage, heartbeatPacket)
+}
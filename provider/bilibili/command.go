@@ -0,0 +1,149 @@
+package bilibili
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+type command struct {
+	Cmd  string            `json:"cmd"`
+	Info []json.RawMessage `json:"info"`
+	Data json.RawMessage   `json:"data"`
+}
+
+type giftData struct {
+	GiftId       int    `json:"giftId"`
+	GiftName     string `json:"giftName"`
+	Num          int    `json:"num"`
+	SuperGiftNum int    `json:"super_gift_num"`
+	Uid          int    `json:"uid"`
+	Uname        string `json:"uname"`
+	Face         string `json:"face"`
+	Price        int    `json:"price"`
+	CoinType     string `json:"coin_type"`
+	MedalInfo    struct {
+		MedalName  string `json:"medal_name"`
+		MedalLevel int    `json:"medal_level"`
+	} `json:"medal_info"`
+}
+
+type interactData struct {
+	Uid       int    `json:"uid"`
+	Uname     string `json:"uname"`
+	MsgType   int    `json:"msg_type"`
+	FansMedal struct {
+		MedalName  string `json:"medal_name"`
+		MedalLevel int    `json:"medal_level"`
+	} `json:"fans_medal"`
+}
+
+// interactEnter 是 INTERACT_WORD 里进入直播间的 msg_type, 其它是关注和分享
+const interactEnter = 1
+
+// DecodeCommand 把 OpCommand 包的 JSON 正文转换成事件. 只支持 DANMU_MSG,
+// SEND_GIFT 和 INTERACT_WORD 的进房, 其它命令返回 nil.
+func DecodeCommand(body []byte, roomId int) (danmuku.Event, error) {
+	var cmd command
+	if err := json.Unmarshal(body, &cmd); err != nil {
+		return nil, err
+	}
+	// 命令可能带有 "DANMU_MSG:4:0:2:2:2:0" 这样的后缀
+	name := strings.SplitN(cmd.Cmd, ":", 2)[0]
+	switch name {
+	case "DANMU_MSG":
+		return decodeDanmu(cmd.Info, roomId)
+	case "SEND_GIFT":
+		var data giftData
+		if err := json.Unmarshal(cmd.Data, &data); err != nil {
+			return nil, err
+		}
+		ev := &danmuku.Gift{
+			Header:     danmuku.Header{Type: "dgb", RoomId: roomId, Scheme: Scheme},
+			Uid:        data.Uid,
+			Nickname:   data.Uname,
+			GiftId:     strconv.Itoa(data.GiftId),
+			GiftName:   data.GiftName,
+			Count:      data.Num,
+			Hits:       data.SuperGiftNum,
+			BadgeName:  data.MedalInfo.MedalName,
+			BadgeLevel: data.MedalInfo.MedalLevel,
+			Avatar:     data.Face,
+		}
+		// 金瓜子 1000 个 1 元, 银瓜子免费
+		if data.CoinType == "gold" {
+			ev.Price = float64(data.Price) / 1000
+		}
+		return ev, nil
+	case "INTERACT_WORD":
+		var data interactData
+		if err := json.Unmarshal(cmd.Data, &data); err != nil {
+			return nil, err
+		}
+		if data.MsgType != interactEnter {
+			return nil, nil
+		}
+		return &danmuku.UserEnter{
+			Header:   danmuku.Header{Type: "uenter", RoomId: roomId, Scheme: Scheme},
+			Uid:      data.Uid,
+			Nickname: data.Uname,
+		}, nil
+	}
+	return nil, nil
+}
+
+// decodeDanmu 解码 DANMU_MSG 的 info 数组:
+//
+//	info[0][3] 颜色
+//	info[1]    内容
+//	info[2]    [uid, 昵称, 是否房管, ...]
+//	info[3]    粉丝牌 [等级, 名字, 主播名, 房间号, ...], 没有时为空数组
+//	info[4][0] 用户等级
+func decodeDanmu(info []json.RawMessage, roomId int) (danmuku.Event, error) {
+	if len(info) < 5 {
+		return nil, errBadPacket
+	}
+	var (
+		props []json.RawMessage
+		text  string
+		user  []json.RawMessage
+		medal []json.RawMessage
+		level []json.RawMessage
+	)
+	for i, v := range []interface{}{&props, &text, &user, &medal, &level} {
+		if err := json.Unmarshal(info[i], v); err != nil {
+			return nil, err
+		}
+	}
+	if len(user) < 3 {
+		return nil, errBadPacket
+	}
+	ev := &danmuku.ChatMessage{
+		Header: danmuku.Header{Type: "chatmsg", RoomId: roomId, Scheme: Scheme},
+		Text:   text,
+		Role:   danmuku.RoleNormal,
+	}
+	var admin int
+	json.Unmarshal(user[0], &ev.Uid)
+	json.Unmarshal(user[1], &ev.Nickname)
+	json.Unmarshal(user[2], &admin)
+	if admin == 1 {
+		ev.Role = danmuku.RoleModerator
+	}
+	if len(props) > 3 {
+		var color uint32
+		json.Unmarshal(props[3], &color)
+		ev.Color = danmuku.NearestColor(color)
+	}
+	if len(medal) >= 4 {
+		json.Unmarshal(medal[0], &ev.BadgeLevel)
+		json.Unmarshal(medal[1], &ev.BadgeName)
+		json.Unmarshal(medal[3], &ev.BadgeRoom)
+	}
+	if len(level) > 0 {
+		json.Unmarshal(level[0], &ev.Level)
+	}
+	return ev, nil
+}
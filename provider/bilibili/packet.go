@@ -0,0 +1,124 @@
+package bilibili
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+)
+
+// 弹幕服务器的包由 16 字节的大端头部和正文组成:
+//
+//	uint32 包长度 (包括头部)
+//	uint16 头部长度, 固定为 16
+//	uint16 协议版本
+//	uint32 操作
+//	uint32 序号, 固定为 1
+const headerLength = 16
+
+// 协议版本
+const (
+	VersionJSON      = 0 // 正文是 JSON
+	VersionHeartbeat = 1 // 心跳回复, 正文是 4 字节的人气值
+	VersionZlib      = 2 // 正文是 zlib 压缩的多个包
+	VersionBrotli    = 3 // 正文是 brotli 压缩的多个包
+)
+
+// 操作
+const (
+	OpHeartbeat      = 2
+	OpHeartbeatReply = 3
+	OpCommand        = 5
+	OpAuth           = 7
+	OpAuthReply      = 8
+)
+
+// maxDecompressedSize 限制一个压缩包解压后的大小, 防止压缩炸弹
+const maxDecompressedSize = 4 << 20
+
+var (
+	errBadPacket = errors.New("bilibili: bad packet")
+	errTooLarge  = errors.New("bilibili: decompressed packet too large")
+)
+
+type Packet struct {
+	Version   uint16
+	Operation uint32
+	Body      []byte
+}
+
+// Encode 编码一个包
+func (p *Packet) Encode() []byte {
+	data := make([]byte, headerLength+len(p.Body))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint16(data[4:6], headerLength)
+	binary.BigEndian.PutUint16(data[6:8], p.Version)
+	binary.BigEndian.PutUint32(data[8:12], p.Operation)
+	binary.BigEndian.PutUint32(data[12:16], 1)
+	copy(data[headerLength:], p.Body)
+	return data
+}
+
+// DecodePackets 解码一条 websocket 消息里的所有包, 压缩的包会被解压展开.
+func DecodePackets(data []byte) ([]*Packet, error) {
+	packets := make([]*Packet, 0, 1)
+	for len(data) > 0 {
+		if len(data) < headerLength {
+			return nil, errBadPacket
+		}
+		length := binary.BigEndian.Uint32(data[0:4])
+		hlen := binary.BigEndian.Uint16(data[4:6])
+		if length < uint32(hlen) || uint32(len(data)) < length || hlen < headerLength {
+			return nil, errBadPacket
+		}
+		p := &Packet{
+			Version:   binary.BigEndian.Uint16(data[6:8]),
+			Operation: binary.BigEndian.Uint32(data[8:12]),
+			Body:      data[hlen:length],
+		}
+		data = data[length:]
+
+		if p.Operation != OpCommand || (p.Version != VersionZlib && p.Version != VersionBrotli) {
+			packets = append(packets, p)
+			continue
+		}
+		inner, err := decompress(p.Version, p.Body)
+		if err != nil {
+			return nil, err
+		}
+		innerPackets, err := DecodePackets(inner)
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, innerPackets...)
+	}
+	return packets, nil
+}
+
+func decompress(version uint16, body []byte) ([]byte, error) {
+	if version == VersionBrotli {
+		return readLimited(brotli.NewReader(bytes.NewReader(body)))
+	}
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r)
+}
+
+// readLimited 读取 r 的全部内容, 超过 maxDecompressedSize 时返回 errTooLarge
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDecompressedSize {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,147 @@
+// Package douyu 是斗鱼的 provider, 导入后可以使用 "douyu:156277" 这样的地址.
+package douyu
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/provider"
+	"github.com/zwh8800/Love66/room"
+)
+
+func init() {
+	provider.Register("douyu", &Provider{})
+}
+
+type Provider struct {
+	// Transport 是连接弹幕服务器的方式, 为 nil 时使用 danmuku.DefaultTransport
+	Transport danmuku.Transport
+	// Client 用来请求房间信息, 为 nil 时使用 room.DefaultClient
+	Client *room.Client
+}
+
+func (p *Provider) client() *room.Client {
+	if p.Client == nil {
+		return room.DefaultClient
+	}
+	return p.Client
+}
+
+// Resolve 通过房间信息接口把短房间号换成真实的房间号
+func (p *Provider) Resolve(ctx context.Context, id string) (int, error) {
+	roomId, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, provider.ErrBadAddr
+	}
+	r, err := p.client().Room(ctx, roomId)
+	if err != nil {
+		return 0, err
+	}
+	return r.RoomId(), nil
+}
+
+func (p *Provider) Info(ctx context.Context, roomId int) (*provider.Info, error) {
+	r, err := p.client().Room(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	return &provider.Info{
+		Online:   r.Online(),
+		Title:    r.RoomName(),
+		Nickname: r.Nickname(),
+		Category: r.GameName(),
+	}, nil
+}
+
+// Streams 返回默认清晰度和线路的直播流, 其它清晰度和线路用
+// SelectStream 请求
+func (p *Provider) Streams(ctx context.Context, roomId int) ([]provider.Stream, error) {
+	play, err := p.client().Play(ctx, roomId, room.RateSource, "")
+	if err != nil {
+		return nil, err
+	}
+	return playStreams(play), nil
+}
+
+func playStreams(play *room.Play) []provider.Stream {
+	bitrate := 0
+	for _, rate := range play.Rates {
+		if rate.Rate == play.Rate {
+			bitrate = rate.Bit
+		}
+	}
+	streams := make([]provider.Stream, 0, 2)
+	for _, s := range []struct{ url, format string }{{play.FlvUrl, room.FormatFLV}, {play.HlsUrl, room.FormatHLS}} {
+		if s.url != "" {
+			streams = append(streams, provider.Stream{
+				Url:     s.url,
+				Quality: play.RateName(),
+				Format:  s.format,
+				Cdn:     play.Cdn,
+				Bitrate: bitrate,
+			})
+		}
+	}
+	return streams
+}
+
+// SelectStream 先用默认的直播流取得清晰度和线路的列表, 选好以后再请求
+// 对应的直播流.
+func (p *Provider) SelectStream(ctx context.Context, roomId int, pref provider.Preference) (*provider.Stream, error) {
+	play, err := p.client().Play(ctx, roomId, room.RateSource, "")
+	if err != nil {
+		return nil, err
+	}
+	streams := playStreams(play)
+	if len(streams) == 0 {
+		return nil, nil
+	}
+
+	rates, lines := play.Rates, play.Lines
+	if len(rates) == 0 {
+		rates = []room.Rate{{Name: play.RateName(), Rate: play.Rate}}
+	}
+	if len(lines) == 0 {
+		lines = []room.Line{{Cdn: play.Cdn}}
+	}
+	// 每个清晰度, 线路和格式的组合, 地址还没有请求
+	type choice struct {
+		rate int
+		cdn  string
+	}
+	choices := make([]choice, 0)
+	candidates := make([]provider.Stream, 0)
+	for _, line := range lines {
+		for _, rate := range rates {
+			for _, format := range []string{room.FormatFLV, room.FormatHLS} {
+				choices = append(choices, choice{rate.Rate, line.Cdn})
+				candidates = append(candidates, provider.Stream{Quality: rate.Name, Format: format, Cdn: line.Cdn, Bitrate: rate.Bit})
+			}
+		}
+	}
+	i := pref.Select(candidates)
+	if c := choices[i]; c.rate != play.Rate || c.cdn != play.Cdn {
+		if play, err = p.client().Play(ctx, roomId, c.rate, c.cdn); err != nil {
+			return nil, err
+		}
+	}
+	// 接口会把不支持的清晰度和线路换成默认的, 以返回的为准
+	streams = playStreams(play)
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	for _, s := range streams {
+		if s.Format == candidates[i].Format {
+			return &s, nil
+		}
+	}
+	return &streams[0], nil
+}
+
+func (p *Provider) Chat(roomId int) danmuku.Source {
+	if p.Transport == nil {
+		return danmuku.NewDanmukuRoom(roomId)
+	}
+	return danmuku.NewDanmukuRoomWithTransport(roomId, p.Transport)
+}
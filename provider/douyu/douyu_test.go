@@ -0,0 +1,139 @@
+package douyu
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/danmuku/douyutest"
+	"github.com/zwh8800/Love66/danmuku/stt"
+	"github.com/zwh8800/Love66/provider"
+	"github.com/zwh8800/Love66/room"
+)
+
+func TestProvider(t *testing.T) {
+	api := douyutest.NewAPI()
+	defer api.Close()
+	defer func(u, p, h string) { room.LiveAPI, room.RoomPage, room.H5PlayAPI = u, p, h }(room.LiveAPI, room.RoomPage, room.H5PlayAPI)
+	room.LiveAPI, room.RoomPage, room.H5PlayAPI = api.LiveAPI(), api.RoomPage(), api.H5PlayAPI()
+	api.SetPage(douyutest.RoomOnline, douyutest.Response{Body: douyutest.H5Page})
+	ctx := context.Background()
+
+	r, err := provider.Open(ctx, "douyu:156277")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := r.Info()
+	if r.String() != "douyu:156277" || !info.Online || info.Title != "测试直播间" ||
+		info.Nickname != "测试主播" || info.Category != "英雄联盟" {
+		t.Errorf("unexpected room %s %#v", r, info)
+	}
+	// 签名的接口只有 flv
+	if url := r.StreamUrl(ctx); !strings.HasPrefix(url, "https://hw-tct.douyucdn.cn/live/156277rGXYXxoMzv.flv?wsAuth=9f2c") {
+		t.Error("unexpected stream url", url)
+	}
+	streams, err := r.Provider.Streams(ctx, r.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 1 || streams[0].Format != "flv" || streams[0].Quality != "原画" || streams[0].Cdn != "hw-h5" {
+		t.Errorf("unexpected streams %#v", streams)
+	}
+
+	// 没有签名脚本时使用旧的接口, 同时有 flv 和 hls
+	api.SetPage(douyutest.RoomOnline, douyutest.Response{Status: http.StatusNotFound})
+	streams, err = r.Provider.Streams(ctx, r.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 || streams[0].Format != "flv" || streams[1].Format != "hls" || streams[0].Cdn != "ws-h5" ||
+		streams[0].Url != "https://hdl3a.douyucdn.cn/live/156277rGXYXxoMzv.flv?wsAuth=5d1e&token=h5-douyu-0-156277" {
+		t.Errorf("unexpected streams %#v", streams)
+	}
+
+	// 没有平台时是斗鱼
+	r, err = provider.Open(ctx, "3258")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scheme != "douyu" || r.Info().Online || r.StreamUrl(ctx) != "" {
+		t.Errorf("unexpected room %s %#v", r, r.Info())
+	}
+
+	for _, addr := range []string{"douyu:1", "douyu:abc"} {
+		if _, err := provider.Open(ctx, addr); err == nil {
+			t.Errorf("Open(%s): expected error", addr)
+		}
+	}
+}
+
+func TestSelectStream(t *testing.T) {
+	api := douyutest.NewAPI()
+	defer api.Close()
+	api.SetPlay(douyutest.RoomOnline, 1, "tct-h5", douyutest.Response{Body: douyutest.InfoOnlineLow})
+	p := &Provider{Client: &room.Client{BaseURL: api.LiveAPI(), DisableH5Play: true}}
+	ctx := context.Background()
+
+	stream, err := p.SelectStream(ctx, douyutest.RoomOnline, provider.Preference{Quality: provider.QualityLowest, Cdn: "tct-h5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream == nil || stream.Quality != "流畅" || stream.Bitrate != 500 || stream.Format != "flv" || stream.Cdn != "tct-h5" ||
+		stream.Url != "https://tc-tct.douyucdn2.cn/dyliveflv1/156277rGXYXxoMzv_500.flv?txSecret=7c2e&txTime=6553f100" {
+		t.Errorf("unexpected stream %#v", stream)
+	}
+	if requests := api.Requests(); len(requests) != 2 || requests[1] != "/html5/live?cdn=tct-h5&rate=1&roomId=156277" {
+		t.Error("unexpected requests", requests)
+	}
+
+	// 默认的直播流只请求一次
+	stream, err = p.SelectStream(ctx, douyutest.RoomOnline, provider.Preference{Format: "hls"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream == nil || stream.Format != "hls" || stream.Quality != "原画" || len(api.Requests()) != 3 {
+		t.Errorf("unexpected stream %#v", stream)
+	}
+
+	// 接口不支持的清晰度换成了默认的
+	stream, err = p.SelectStream(ctx, douyutest.RoomOnline, provider.Preference{Quality: "高清"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream == nil || stream.Quality != "原画" || stream.Format != "flv" {
+		t.Errorf("unexpected stream %#v", stream)
+	}
+
+	if stream, err := p.SelectStream(ctx, douyutest.RoomOffline, provider.Preference{}); stream != nil || err != nil {
+		t.Errorf("offline room: %#v, %v", stream, err)
+	}
+}
+
+func TestChat(t *testing.T) {
+	server := douyutest.NewServer([]douyutest.Step{{
+		Message: stt.NewMessage("chatmsg", "rid", "156277", "uid", "1", "nn", "a", "txt", "hello"),
+	}})
+	defer server.Close()
+
+	p := &Provider{Transport: &danmuku.OpenBarrageTransport{Addr: server.Addr}}
+	source := p.Chat(156277)
+	sub := source.Subscribe(nil, 16, danmuku.Block)
+	source.Start(context.Background())
+	defer source.Stop()
+	for {
+		select {
+		case ev := <-sub.Events():
+			if chat, ok := ev.(*danmuku.ChatMessage); ok {
+				if chat.Text != "hello" || chat.Addr() != "douyu:156277" {
+					t.Errorf("unexpected chat %#v", chat)
+				}
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for chat")
+		}
+	}
+}
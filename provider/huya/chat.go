@@ -0,0 +1,167 @@
+package huya
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+// ChatServer 是弹幕服务器的地址, 测试时可以换成假服务器
+var ChatServer = "wss://cdnws.api.huya.com"
+
+const (
+	DefaultHeartbeatInterval = 60 * time.Second
+	registerTimeout          = 10 * time.Second
+)
+
+// WebSocketCommand 的 iCmdType
+const (
+	cmdHeartbeat        = 5
+	cmdHeartbeatAck     = 6
+	cmdPushMessage      = 7
+	cmdRegisterGroupReq = 16
+	cmdRegisterGroupRsp = 17
+	cmdPushMessageV2    = 22
+)
+
+var ErrRegisterFailed = errors.New("huya: register failed")
+
+// NewChatRoom 返回一个直播间的弹幕, dialer 为 nil 时使用 websocket.DefaultDialer.
+func NewChatRoom(roomId int, dialer *websocket.Dialer) *danmuku.DanmukuRoom {
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	return danmuku.NewChatRoom(Scheme, roomId, func(ctx context.Context, roomId int) (danmuku.ChatConn, error) {
+		return dial(ctx, dialer, roomId)
+	}, DefaultHeartbeatInterval)
+}
+
+// encodeCommand 编码 WebSocketCommand: 0 iCmdType, 1 vData
+func encodeCommand(cmdType int, data []byte) []byte {
+	var w tarsWriter
+	w.Int(0, int64(cmdType))
+	w.Bytes(1, data)
+	return w.Data()
+}
+
+func decodeCommand(data []byte) (int, []byte, error) {
+	cmd, err := decodeTars(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(cmd.Int(0)), cmd.Bytes(1), nil
+}
+
+var heartbeatCommand = encodeCommand(cmdHeartbeat, nil)
+
+type chatConn struct {
+	*websocket.Conn
+	roomId int
+}
+
+// dial 从房间页面取得主播的 uid, 连接弹幕服务器并订阅主播的消息组.
+func dial(ctx context.Context, dialer *websocket.Dialer, roomId int) (*chatConn, error) {
+	page, err := getRoomPage(ctx, strconv.Itoa(roomId))
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := dialer.DialContext(ctx, ChatServer, nil)
+	if err != nil {
+		return nil, err
+	}
+	release := danmuku.CloseOnDone(ctx, conn)
+	defer release()
+
+	// WSRegisterGroupReq: 0 vGroupId, 1 sToken
+	uid := strconv.FormatInt(page.PresenterUid, 10)
+	var req tarsWriter
+	req.StringList(0, []string{"live:" + uid, "chat:" + uid})
+	req.String(1, "")
+	if err := conn.WriteMessage(websocket.BinaryMessage, encodeCommand(cmdRegisterGroupReq, req.Data())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(registerTimeout))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		cmdType, body, err := decodeCommand(data)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if cmdType != cmdRegisterGroupRsp {
+			continue
+		}
+		// WSRegisterGroupRsp: 0 iResCode, 2 sMessage
+		rsp, err := decodeTars(body)
+		if err != nil || rsp.Int(0) != 0 {
+			conn.Close()
+			return nil, ErrRegisterFailed
+		}
+		return &chatConn{conn, roomId}, nil
+	}
+}
+
+func (c *chatConn) Read() ([]danmuku.Event, error) {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	cmdType, body, err := decodeCommand(data)
+	if err != nil {
+		return nil, err
+	}
+	var items []pushItem
+	switch cmdType {
+	case cmdPushMessage:
+		// WSPushMessage: 1 iUri, 2 sMsg
+		msg, err := decodeTars(body)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, pushItem{int(msg.Int(1)), msg.Bytes(2)})
+	case cmdPushMessageV2:
+		// WSPushMessage_V2: 1 vMsgItem, WSMsgItem: 0 iUri, 1 sMsg
+		msg, err := decodeTars(body)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range msg.List(1) {
+			if item, ok := v.(tarsStruct); ok {
+				items = append(items, pushItem{int(item.Int(0)), item.Bytes(1)})
+			}
+		}
+	}
+
+	events := make([]danmuku.Event, 0, len(items))
+	for _, item := range items {
+		ev, err := DecodeMessage(item.uri, item.data, c.roomId)
+		if err != nil {
+			log.Println("huya: decode:", err)
+			continue
+		}
+		if ev != nil {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+func (c *chatConn) Heartbeat() error {
+	return c.WriteMessage(websocket.BinaryMessage, heartbeatCommand)
+}
+
+type pushItem struct {
+	uri  int
+	data []byte
+}
@@ -0,0 +1,233 @@
+// Package huya 是虎牙直播的 provider, 导入后可以使用 "huya:11342412" 或
+// "huya:kpl" 这样的地址.
+package huya
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/provider"
+	"github.com/zwh8800/Love66/room"
+)
+
+// Scheme 是虎牙房间地址和事件里的平台名
+const Scheme = "huya"
+
+// RoomPage 是房间页面的地址前缀, 测试时可以换成假服务器
+var RoomPage = "https://www.huya.com/"
+
+// userAgent 是桌面浏览器的 UA, 手机的 UA 会跳转到 m.huya.com
+const userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// client 请求房间页面, 超时和重试和斗鱼的房间信息接口相同
+var client = &room.Client{UserAgent: userAgent}
+
+var ErrRoomNotFound = errors.New("huya: room not found")
+
+func init() {
+	provider.Register(Scheme, &Provider{})
+}
+
+type Provider struct {
+	// Dialer 用来连接弹幕服务器, 为 nil 时使用 websocket.DefaultDialer
+	Dialer *websocket.Dialer
+}
+
+// roomPage 是从房间页面的脚本里取出的信息
+type roomPage struct {
+	RoomId       int
+	PresenterUid int64
+	Info         provider.Info
+	Streams      []provider.Stream
+}
+
+// getRoomPage 请求房间页面并解析, id 可以是房间号或别名
+func getRoomPage(ctx context.Context, id string) (*roomPage, error) {
+	data, err := client.Get(ctx, RoomPage+id)
+	if err, ok := err.(*room.StatusError); ok && err.Code == http.StatusNotFound {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseRoomPage(data)
+}
+
+// parseRoomPage 从页面里的 TT_ROOM_DATA, TT_PROFILE_INFO 和 hyPlayerConfig
+// 取出房间信息. 这些字段有时是字符串有时是数字.
+func parseRoomPage(data []byte) (*roomPage, error) {
+	var roomData, profile map[string]interface{}
+	if err := scriptValue(data, "var TT_ROOM_DATA = ", &roomData); err != nil {
+		return nil, ErrRoomNotFound
+	}
+	if err := scriptValue(data, "var TT_PROFILE_INFO = ", &profile); err != nil {
+		return nil, ErrRoomNotFound
+	}
+	page := &roomPage{
+		RoomId:       int(toInt(roomData["profileRoom"])),
+		PresenterUid: toInt(profile["lp"]),
+		Info: provider.Info{
+			Online:   fmt.Sprint(roomData["state"]) == "ON",
+			Title:    toString(roomData["introduction"]),
+			Nickname: toString(profile["nick"]),
+			Category: toString(roomData["gameFullName"]),
+		},
+	}
+	if page.RoomId == 0 {
+		return nil, ErrRoomNotFound
+	}
+	if page.Info.Online {
+		page.Streams = parseStreams(data)
+	}
+	return page, nil
+}
+
+// scriptValue 解析页面里 prefix 后面的 JSON 值
+func scriptValue(data []byte, prefix string, v interface{}) error {
+	i := bytes.Index(data, []byte(prefix))
+	if i < 0 {
+		return ErrRoomNotFound
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data[i+len(prefix):]))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func toInt(v interface{}) int64 {
+	switch v := v.(type) {
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+func toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+type streamInfo struct {
+	Data []struct {
+		GameStreamInfoList []struct {
+			CdnType      string `json:"sCdnType"`
+			StreamName   string `json:"sStreamName"`
+			FlvUrl       string `json:"sFlvUrl"`
+			FlvUrlSuffix string `json:"sFlvUrlSuffix"`
+			FlvAntiCode  string `json:"sFlvAntiCode"`
+			HlsUrl       string `json:"sHlsUrl"`
+			HlsUrlSuffix string `json:"sHlsUrlSuffix"`
+			HlsAntiCode  string `json:"sHlsAntiCode"`
+		} `json:"gameStreamInfoList"`
+	} `json:"data"`
+	MultiStreamInfo []multiStream `json:"vMultiStreamInfo"`
+}
+
+// multiStream 是一个清晰度, 码率是 kbps
+type multiStream struct {
+	DisplayName string `json:"sDisplayName"`
+	BitRate     int    `json:"iBitRate"`
+}
+
+// parseStreams 解析 hyPlayerConfig 里的 stream, 它可能是 JSON 对象或者
+// base64 编码的 JSON. 第一个清晰度是默认的, 其它清晰度在地址后面加上
+// ratio 参数. 每个清晰度先返回所有 CDN 的 flv, 再返回 hls.
+func parseStreams(data []byte) []provider.Stream {
+	i := bytes.Index(data, []byte("hyPlayerConfig"))
+	if i < 0 {
+		return nil
+	}
+	var raw json.RawMessage
+	if scriptValue(data[i:], "stream: ", &raw) != nil {
+		return nil
+	}
+	var encoded string
+	if json.Unmarshal(raw, &encoded) == nil {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil
+		}
+		raw = decoded
+	}
+	var info streamInfo
+	if json.Unmarshal(raw, &info) != nil || len(info.Data) == 0 {
+		return nil
+	}
+	rates := info.MultiStreamInfo
+	if len(rates) == 0 {
+		rates = []multiStream{{}}
+	}
+	list := info.Data[0].GameStreamInfoList
+	streams := make([]provider.Stream, 0, 2*len(list)*len(rates))
+	for i, rate := range rates {
+		ratio := ""
+		if i > 0 {
+			ratio = fmt.Sprintf("&ratio=%d", rate.BitRate)
+		}
+		for _, format := range []string{"flv", "hls"} {
+			for _, s := range list {
+				base, suffix, antiCode := s.FlvUrl, s.FlvUrlSuffix, s.FlvAntiCode
+				if format == "hls" {
+					base, suffix, antiCode = s.HlsUrl, s.HlsUrlSuffix, s.HlsAntiCode
+				}
+				if base == "" {
+					continue
+				}
+				streams = append(streams, provider.Stream{
+					Url:     fmt.Sprintf("%s/%s.%s?%s%s", base, s.StreamName, suffix, html.UnescapeString(antiCode), ratio),
+					Quality: rate.DisplayName,
+					Format:  format,
+					Cdn:     s.CdnType,
+					Bitrate: rate.BitRate,
+				})
+			}
+		}
+	}
+	return streams
+}
+
+// Resolve 把房间号或别名换成页面里的房间号
+func (p *Provider) Resolve(ctx context.Context, id string) (int, error) {
+	if id == "" {
+		return 0, provider.ErrBadAddr
+	}
+	page, err := getRoomPage(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return page.RoomId, nil
+}
+
+func (p *Provider) Info(ctx context.Context, roomId int) (*provider.Info, error) {
+	page, err := getRoomPage(ctx, strconv.Itoa(roomId))
+	if err != nil {
+		return nil, err
+	}
+	return &page.Info, nil
+}
+
+func (p *Provider) Streams(ctx context.Context, roomId int) ([]provider.Stream, error) {
+	page, err := getRoomPage(ctx, strconv.Itoa(roomId))
+	if err != nil {
+		return nil, err
+	}
+	return page.Streams, nil
+}
+
+func (p *Provider) Chat(roomId int) danmuku.Source {
+	return NewChatRoom(roomId, p.Dialer)
+}
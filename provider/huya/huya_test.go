@@ -0,0 +1,418 @@
+package huya
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/provider"
+	"github.com/zwh8800/Love66/room"
+)
+
+func TestTars(t *testing.T) {
+	long := strings.Repeat("长", 100)
+	var w tarsWriter
+	w.Int(0, 0)
+	w.Int(1, -1)
+	w.Int(2, 300)
+	w.Int(3, -70000)
+	w.Int(4, 1346609715000)
+	w.String(5, "虎牙")
+	w.String(6, long)
+	w.Bytes(7, []byte{1, 2, 3})
+	w.StringList(8, []string{"live:1", "chat:1"})
+	w.Struct(20, func(w *tarsWriter) {
+		w.Int(0, 42)
+		w.String(1, "inner")
+	})
+	w.Int(9, 7)
+
+	s, err := decodeTars(w.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Int(0) != 0 || s.Int(1) != -1 || s.Int(2) != 300 || s.Int(3) != -70000 || s.Int(4) != 1346609715000 ||
+		s.String(5) != "虎牙" || s.String(6) != long || !bytes.Equal(s.Bytes(7), []byte{1, 2, 3}) || s.Int(9) != 7 {
+		t.Errorf("unexpected struct %v", s)
+	}
+	if l := s.List(8); !reflect.DeepEqual(l, []interface{}{"live:1", "chat:1"}) {
+		t.Errorf("unexpected list %v", l)
+	}
+	if inner := s.Struct(20); inner.Int(0) != 42 || inner.String(1) != "inner" {
+		t.Errorf("unexpected inner struct %v", inner)
+	}
+	if missing := s.Struct(30); len(missing) != 0 || missing.String(0) != "" {
+		t.Errorf("unexpected missing struct %v", missing)
+	}
+
+	// map<string, int32> 和 double, 写入器不支持, 手工编码
+	other := []byte{
+		0x08, 0x00, 0x01, 0x06, 0x01, 'a', 0x02, 0x00, 0x00, 0x01, 0x00,
+		0x15, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+	}
+	s, err = decodeTars(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := s[0].(map[interface{}]interface{}); m["a"] != int64(256) || s[1] != 1.5 {
+		t.Errorf("unexpected struct %v", s)
+	}
+
+	data := w.Data()
+	bad := [][]byte{
+		data[:len(data)-1],
+		{0x06, 0x05, 'a'},
+		{0x0d, 0x00, 0x02, 0x10, 0x01},
+		{0x09, 0x02, 0xff},
+		{0x0e},
+	}
+	for _, b := range bad {
+		if _, err := decodeTars(b); err == nil {
+			t.Errorf("decodeTars(% x): expected error", b)
+		}
+	}
+}
+
+// fakePage 启动假的房间页面并修改 RoomPage, 页面来自 testdata
+func fakePage(t *testing.T) *httptest.Server {
+	pages := map[string]string{
+		"/660000": "room_online.html",
+		"/kpl":    "room_online.html",
+		"/880000": "room_offline.html",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/999":
+			// 不存在的房间也返回 200, 页面里没有房间数据
+			w.Write([]byte("<html><body>没有找到该房间</body></html>"))
+			return
+		case "/500":
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		name, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(data)
+	}))
+	RoomPage = server.URL + "/"
+	return server
+}
+
+func TestProvider(t *testing.T) {
+	defer func(u string, c *room.Client) { RoomPage, client = u, c }(RoomPage, client)
+	client = &room.Client{UserAgent: userAgent, RetryWait: time.Millisecond}
+	page := fakePage(t)
+	defer page.Close()
+	ctx := context.Background()
+
+	r, err := provider.Open(ctx, "huya:kpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := r.Info()
+	if r.String() != "huya:660000" || !info.Online || info.Title != "KPL春季赛 AG超玩会 vs 狼队" ||
+		info.Nickname != "王者荣耀KPL职业联赛" || info.Category != "王者荣耀" {
+		t.Errorf("unexpected room %s %#v", r, info)
+	}
+	streams, err := r.Provider.Streams(ctx, r.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	variants := make([]string, 0)
+	for _, s := range streams {
+		variants = append(variants, fmt.Sprintf("%s/%s/%s/%d", s.Quality, s.Format, s.Cdn, s.Bitrate))
+	}
+	if got := strings.Join(variants[:5], " "); got != "蓝光4M/flv/AL/4000 蓝光4M/flv/TX/4000 蓝光4M/hls/AL/4000 蓝光4M/hls/TX/4000 超清/flv/AL/2000" ||
+		len(streams) != 12 {
+		t.Error("unexpected streams", variants)
+	}
+	if url := streams[11].Url; !strings.HasSuffix(url, "/1346609715-1346609715-5781438549082079232-2693342886-10057-A-0-1.m3u8"+
+		"?wsSecret=1a2b3c4d&wsTime=6553f100&ctype=huya_live&ratio=500") {
+		t.Error("unexpected stream url", url)
+	}
+	want := "http://al.flv.huya.com/src/1346609715-1346609715-5781438549082079232-2693342886-10057-A-0-1.flv" +
+		"?wsSecret=4a1b2c3d&wsTime=6553f100&fm=RFdxOEJjSjNoNkRKdDZUWV8kMF8kMV8kMl8kMw%3D%3D&ctype=huya_live&fs=bgct"
+	if url := r.StreamUrl(ctx); url != want {
+		t.Errorf("unexpected stream url %s", url)
+	}
+
+	r.Preference = provider.Preference{Quality: provider.QualityLowest, Format: "hls", Cdn: "tx"}
+	if url := r.StreamUrl(ctx); url != streams[11].Url {
+		t.Errorf("unexpected stream url %s", url)
+	}
+
+	r, err = provider.Open(ctx, "huya:880000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Id != 880000 || r.Info().Online || r.Info().Nickname != "小明" || r.StreamUrl(ctx) != "" {
+		t.Errorf("unexpected room %s %#v", r, r.Info())
+	}
+
+	for _, addr := range []string{"huya:999", "huya:404", "huya:500", "huya:"} {
+		if _, err := provider.Open(ctx, addr); err == nil {
+			t.Errorf("Open(%s): expected error", addr)
+		}
+	}
+	if _, err := (&Provider{}).Resolve(ctx, "999"); err != ErrRoomNotFound {
+		t.Errorf("Resolve(999) = %v, want ErrRoomNotFound", err)
+	}
+}
+
+func TestParseStreams(t *testing.T) {
+	stream := `{"data":[{"gameStreamInfoList":[{"sStreamName":"s","sFlvUrl":"http://a/src","sFlvUrlSuffix":"flv","sFlvAntiCode":"a=1&amp;b=2"}]}]}`
+	page := "var hyPlayerConfig = {\n    stream: \"" + base64.StdEncoding.EncodeToString([]byte(stream)) + "\",\n};"
+	streams := parseStreams([]byte(page))
+	if len(streams) != 1 || streams[0].Url != "http://a/src/s.flv?a=1&b=2" || streams[0].Format != "flv" {
+		t.Errorf("unexpected streams %#v", streams)
+	}
+	for _, page := range []string{"", "var hyPlayerConfig = {stream: \"!!\"};", "var hyPlayerConfig = {stream: {\"data\":[]}};"} {
+		if streams := parseStreams([]byte(page)); len(streams) != 0 {
+			t.Errorf("parseStreams(%q) = %#v", page, streams)
+		}
+	}
+}
+
+func messageNotice(uid int64, nick, text string, color int64) []byte {
+	var w tarsWriter
+	w.Struct(0, func(w *tarsWriter) {
+		w.Int(0, uid)
+		w.Int(1, uid)
+		w.String(2, nick)
+		w.Int(3, 1)
+		w.String(4, "https://huyaimg.msstatic.com/avatar/3.jpg")
+		w.Int(5, 3)
+	})
+	w.Int(1, 1346609715)
+	w.Int(2, 1346609715)
+	w.String(3, text)
+	w.Int(4, 0)
+	w.Struct(6, func(w *tarsWriter) {
+		w.Int(0, color)
+		w.Int(1, 4)
+	})
+	return w.Data()
+}
+
+func sendItem(uid int64, nick string, item, count int64) []byte {
+	var w tarsWriter
+	w.Int(0, item)
+	w.String(1, "pay-1")
+	w.Int(2, count)
+	w.Int(3, 1346609715)
+	w.Int(4, uid)
+	w.String(5, "王者荣耀KPL职业联赛")
+	w.String(6, nick)
+	return w.Data()
+}
+
+func TestDecodeMessage(t *testing.T) {
+	ev, err := DecodeMessage(uriMessageNotice, messageNotice(1199512345678, "观众甲", "666", 0xff0000), 660000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat, ok := ev.(*danmuku.ChatMessage)
+	if !ok || chat.Addr() != "huya:660000" || chat.EventType() != "chatmsg" || chat.Text != "666" ||
+		chat.Uid != 1199512345678 || chat.Nickname != "观众甲" || chat.Color != danmuku.ColorRed ||
+		chat.Noble != 3 || chat.Avatar == "" {
+		t.Errorf("unexpected chat %#v", ev)
+	}
+	ev, _ = DecodeMessage(uriMessageNotice, messageNotice(1, "a", "b", -1), 660000)
+	if chat := ev.(*danmuku.ChatMessage); chat.Color != danmuku.ColorDefault {
+		t.Error("unexpected color", chat.Color)
+	}
+
+	ev, err = DecodeMessage(uriSendItemBroadcast, sendItem(23456, "观众丙", 4, 10), 660000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := ev.(*danmuku.Gift)
+	if !ok || g.EventType() != "dgb" || g.GiftId != "4" || g.Count != 10 || g.Uid != 23456 || g.Nickname != "观众丙" {
+		t.Errorf("unexpected gift %#v", ev)
+	}
+
+	if ev, err := DecodeMessage(8006, []byte{0x00, 0x01}, 660000); ev != nil || err != nil {
+		t.Errorf("DecodeMessage(8006) = %#v, %v", ev, err)
+	}
+	if _, err := DecodeMessage(uriMessageNotice, []byte{0x06, 0x05}, 660000); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// fakeChat 是弹幕服务器的替身: 检查订阅请求, 回复心跳, 并把 commands
+// 里的消息发给每个连接.
+type fakeChat struct {
+	*httptest.Server
+	rejectRegister bool
+	commands       [][]byte
+
+	mutex      sync.Mutex
+	groups     [][]interface{}
+	heartbeats int
+}
+
+func newFakeChat(commands ...[]byte) *fakeChat {
+	c := &fakeChat{commands: commands}
+	upgrader := websocket.Upgrader{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c.serve(conn)
+	}))
+	ChatServer = strings.Replace(c.URL, "http", "ws", 1)
+	return c
+}
+
+func (c *fakeChat) serve(conn *websocket.Conn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		cmdType, body, err := decodeCommand(data)
+		if err != nil {
+			return
+		}
+		switch cmdType {
+		case cmdRegisterGroupReq:
+			req, _ := decodeTars(body)
+			c.mutex.Lock()
+			c.groups = append(c.groups, req.List(0))
+			c.mutex.Unlock()
+			var rsp tarsWriter
+			if c.rejectRegister {
+				rsp.Int(0, -1)
+				rsp.String(2, "invalid token")
+			} else {
+				rsp.Int(0, 0)
+			}
+			conn.WriteMessage(websocket.BinaryMessage, encodeCommand(cmdRegisterGroupRsp, rsp.Data()))
+			for _, data := range c.commands {
+				conn.WriteMessage(websocket.BinaryMessage, data)
+			}
+		case cmdHeartbeat:
+			c.mutex.Lock()
+			c.heartbeats++
+			c.mutex.Unlock()
+			conn.WriteMessage(websocket.BinaryMessage, encodeCommand(cmdHeartbeatAck, nil))
+		}
+	}
+}
+
+func pushMessage(uri int64, msg []byte) []byte {
+	var w tarsWriter
+	w.Int(0, 0)
+	w.Int(1, uri)
+	w.Bytes(2, msg)
+	return encodeCommand(cmdPushMessage, w.Data())
+}
+
+func pushMessageV2(items map[int64][]byte, order ...int64) []byte {
+	var w tarsWriter
+	w.String(0, "live:1346609715")
+	w.head(1, tarsList)
+	w.Int(0, int64(len(order)))
+	for _, uri := range order {
+		w.Struct(0, func(w *tarsWriter) {
+			w.Int(0, uri)
+			w.Bytes(1, items[uri])
+		})
+	}
+	return encodeCommand(cmdPushMessageV2, w.Data())
+}
+
+func TestChatRoom(t *testing.T) {
+	defer func(u, c string) { RoomPage, ChatServer = u, c }(RoomPage, ChatServer)
+	page := fakePage(t)
+	defer page.Close()
+	chat := newFakeChat(
+		pushMessage(uriMessageNotice, messageNotice(1, "观众甲", "666", 0x00ff00)),
+		pushMessage(8006, []byte{0x00, 0x01}),
+		pushMessageV2(map[int64][]byte{
+			uriSendItemBroadcast: sendItem(2, "观众丙", 4, 1),
+			uriMessageNotice:     messageNotice(3, "观众丁", "好", -1),
+		}, uriSendItemBroadcast, uriMessageNotice),
+	)
+	defer chat.Close()
+
+	r := (&Provider{}).Chat(660000).(*danmuku.DanmukuRoom)
+	r.KeepAliveInterval = 10 * time.Millisecond
+	sub := r.Subscribe(nil, 16, danmuku.Block)
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	types := make([]string, 0)
+	for len(types) < 5 {
+		select {
+		case ev := <-sub.Events():
+			if ev.Addr() != "huya:660000" {
+				t.Errorf("unexpected room %s", ev.Addr())
+			}
+			types = append(types, ev.EventType())
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout, got", types)
+		}
+	}
+	if got := strings.Join(types, " "); got != "connstate connstate chatmsg dgb chatmsg" {
+		t.Error("unexpected events", got)
+	}
+	if r.State() != danmuku.StateConnected {
+		t.Error("unexpected state", r.State())
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	chat.mutex.Lock()
+	defer chat.mutex.Unlock()
+	want := [][]interface{}{{"live:1346609715", "chat:1346609715"}}
+	if !reflect.DeepEqual(chat.groups, want) {
+		t.Errorf("unexpected groups %v", chat.groups)
+	}
+	if chat.heartbeats < 2 {
+		t.Error("expected heartbeats, got", chat.heartbeats)
+	}
+}
+
+func TestChatRoomRegisterFailed(t *testing.T) {
+	defer func(u, c string) { RoomPage, ChatServer = u, c }(RoomPage, ChatServer)
+	page := fakePage(t)
+	defer page.Close()
+	chat := newFakeChat()
+	chat.rejectRegister = true
+	defer chat.Close()
+
+	r := NewChatRoom(660000, nil)
+	r.Backoff = danmuku.Backoff{Min: time.Millisecond, Max: time.Millisecond, Factor: 1, MaxRetries: 1}
+	r.Start(context.Background())
+	select {
+	case <-r.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if r.Err() != ErrRegisterFailed {
+		t.Errorf("Err() = %v, want ErrRegisterFailed", r.Err())
+	}
+}
@@ -0,0 +1,73 @@
+package huya
+
+import (
+	"strconv"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+// 推送消息的 iUri
+const (
+	uriMessageNotice     = 1400
+	uriSendItemBroadcast = 6501
+)
+
+// DecodeMessage 把推送消息的正文转换成事件. 只支持 MessageNotice 和
+// SendItemSubBroadcastPacket, 其它消息返回 nil.
+func DecodeMessage(uri int, data []byte, roomId int) (danmuku.Event, error) {
+	switch uri {
+	case uriMessageNotice:
+		msg, err := decodeTars(data)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMessageNotice(msg, roomId), nil
+	case uriSendItemBroadcast:
+		msg, err := decodeTars(data)
+		if err != nil {
+			return nil, err
+		}
+		return decodeSendItem(msg, roomId), nil
+	}
+	return nil, nil
+}
+
+// decodeMessageNotice 解码 MessageNotice:
+//
+//	0 tUserInfo      SenderInfo: 0 lUid, 2 sNickName, 4 sAvatarUrl, 5 iNobleLevel
+//	3 sContent
+//	6 tBulletFormat  BulletFormat: 0 iFontColor, -1 是默认颜色
+func decodeMessageNotice(msg tarsStruct, roomId int) danmuku.Event {
+	sender := msg.Struct(0)
+	ev := &danmuku.ChatMessage{
+		Header:   danmuku.Header{Type: "chatmsg", RoomId: roomId, Scheme: Scheme},
+		Uid:      int(sender.Int(0)),
+		Nickname: sender.String(2),
+		Avatar:   sender.String(4),
+		Noble:    int(sender.Int(5)),
+		Text:     msg.String(3),
+		Role:     danmuku.RoleNormal,
+	}
+	if color := msg.Struct(6).Int(0); color > 0 {
+		ev.Color = danmuku.NearestColor(uint32(color))
+	}
+	return ev
+}
+
+// decodeSendItem 解码 SendItemSubBroadcastPacket:
+//
+//	0 iItemType  礼物 id
+//	2 iItemCount
+//	4 lSenderUid
+//	6 sSenderNick
+//
+// 消息里没有礼物名字.
+func decodeSendItem(msg tarsStruct, roomId int) danmuku.Event {
+	return &danmuku.Gift{
+		Header:   danmuku.Header{Type: "dgb", RoomId: roomId, Scheme: Scheme},
+		Uid:      int(msg.Int(4)),
+		Nickname: msg.String(6),
+		GiftId:   strconv.FormatInt(msg.Int(0), 10),
+		Count:    int(msg.Int(2)),
+	}
+}
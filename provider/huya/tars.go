@@ -0,0 +1,328 @@
+package huya
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// TARS (JCE) 编码里的类型. 每个字段由头部和值组成, 头部的高 4 位是 tag,
+// 低 4 位是类型, tag 大于等于 15 时后面再跟一个字节的 tag.
+const (
+	tarsInt8        = 0
+	tarsInt16       = 1
+	tarsInt32       = 2
+	tarsInt64       = 3
+	tarsFloat       = 4
+	tarsDouble      = 5
+	tarsString1     = 6
+	tarsString4     = 7
+	tarsMap         = 8
+	tarsList        = 9
+	tarsStructBegin = 10
+	tarsStructEnd   = 11
+	tarsZero        = 12
+	tarsSimpleList  = 13
+)
+
+var errBadTars = errors.New("huya: bad tars data")
+
+// tarsStruct 是解码后的结构体, 按 tag 保存字段. 字段的值是 int64, float64,
+// string, []byte, []interface{}, map[interface{}]interface{} 或 tarsStruct.
+// 虎牙的 vector<byte> 字段通常是另一个编码后的结构体.
+type tarsStruct map[int]interface{}
+
+func (s tarsStruct) Int(tag int) int64 {
+	v, _ := s[tag].(int64)
+	return v
+}
+
+func (s tarsStruct) String(tag int) string {
+	v, _ := s[tag].(string)
+	return v
+}
+
+func (s tarsStruct) Bytes(tag int) []byte {
+	v, _ := s[tag].([]byte)
+	return v
+}
+
+// Struct 返回结构体字段, 不存在时返回空的结构体
+func (s tarsStruct) Struct(tag int) tarsStruct {
+	if v, ok := s[tag].(tarsStruct); ok {
+		return v
+	}
+	return tarsStruct{}
+}
+
+func (s tarsStruct) List(tag int) []interface{} {
+	v, _ := s[tag].([]interface{})
+	return v
+}
+
+// decodeTars 解码一个结构体的所有字段
+func decodeTars(data []byte) (tarsStruct, error) {
+	r := &tarsReader{data: data}
+	s, err := r.fields()
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.data) {
+		return nil, errBadTars
+	}
+	return s, nil
+}
+
+type tarsReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *tarsReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errBadTars
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *tarsReader) head() (int, byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	tag, typ := int(b[0]>>4), b[0]&0x0f
+	if tag == 15 {
+		if b, err = r.next(1); err != nil {
+			return 0, 0, err
+		}
+		tag = int(b[0])
+	}
+	return tag, typ, nil
+}
+
+// fields 读取结构体的字段, 直到 StructEnd 或者数据结束
+func (r *tarsReader) fields() (tarsStruct, error) {
+	s := make(tarsStruct)
+	for r.pos < len(r.data) {
+		tag, typ, err := r.head()
+		if err != nil {
+			return nil, err
+		}
+		if typ == tarsStructEnd {
+			return s, nil
+		}
+		if s[tag], err = r.value(typ); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// length 读取 map, list 和 simple list 的长度, 它是一个 tag 为 0 的整数
+func (r *tarsReader) length() (int, error) {
+	_, typ, err := r.head()
+	if err != nil {
+		return 0, err
+	}
+	v, err := r.value(typ)
+	n, ok := v.(int64)
+	if err != nil || !ok || n < 0 || n > int64(len(r.data)) {
+		return 0, errBadTars
+	}
+	return int(n), nil
+}
+
+func (r *tarsReader) value(typ byte) (interface{}, error) {
+	switch typ {
+	case tarsZero:
+		return int64(0), nil
+	case tarsInt8:
+		b, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int8(b[0])), nil
+	case tarsInt16:
+		b, err := r.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case tarsInt32:
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case tarsInt64:
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case tarsFloat:
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case tarsDouble:
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case tarsString1, tarsString4:
+		var n int
+		if typ == tarsString1 {
+			b, err := r.next(1)
+			if err != nil {
+				return nil, err
+			}
+			n = int(b[0])
+		} else {
+			b, err := r.next(4)
+			if err != nil {
+				return nil, err
+			}
+			n = int(binary.BigEndian.Uint32(b))
+		}
+		b, err := r.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case tarsMap:
+		n, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := r.field()
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.field()
+			if err != nil {
+				return nil, err
+			}
+			// 只有可以比较的值能作为 key
+			switch k.(type) {
+			case int64, float64, string:
+				m[k] = v
+			}
+		}
+		return m, nil
+	case tarsList:
+		n, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		l := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := r.field()
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case tarsSimpleList:
+		// 元素类型, 总是 int8
+		if _, _, err := r.head(); err != nil {
+			return nil, err
+		}
+		n, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		return r.next(n)
+	case tarsStructBegin:
+		return r.fields()
+	}
+	return nil, errBadTars
+}
+
+// field 读取一个带头部的值, 用于 map 和 list 的元素
+func (r *tarsReader) field() (interface{}, error) {
+	_, typ, err := r.head()
+	if err != nil {
+		return nil, err
+	}
+	return r.value(typ)
+}
+
+// tarsWriter 编码结构体的字段
+type tarsWriter struct {
+	buf bytes.Buffer
+}
+
+// Data 返回已经编码的数据
+func (w *tarsWriter) Data() []byte {
+	return w.buf.Bytes()
+}
+
+func (w *tarsWriter) head(tag int, typ byte) {
+	if tag < 15 {
+		w.buf.WriteByte(byte(tag)<<4 | typ)
+		return
+	}
+	w.buf.WriteByte(0xf0 | typ)
+	w.buf.WriteByte(byte(tag))
+}
+
+func (w *tarsWriter) Int(tag int, v int64) {
+	switch {
+	case v == 0:
+		w.head(tag, tarsZero)
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		w.head(tag, tarsInt8)
+		w.buf.WriteByte(byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		w.head(tag, tarsInt16)
+		binary.Write(&w.buf, binary.BigEndian, int16(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		w.head(tag, tarsInt32)
+		binary.Write(&w.buf, binary.BigEndian, int32(v))
+	default:
+		w.head(tag, tarsInt64)
+		binary.Write(&w.buf, binary.BigEndian, v)
+	}
+}
+
+func (w *tarsWriter) String(tag int, s string) {
+	if len(s) <= math.MaxUint8 {
+		w.head(tag, tarsString1)
+		w.buf.WriteByte(byte(len(s)))
+	} else {
+		w.head(tag, tarsString4)
+		binary.Write(&w.buf, binary.BigEndian, uint32(len(s)))
+	}
+	w.buf.WriteString(s)
+}
+
+func (w *tarsWriter) Bytes(tag int, b []byte) {
+	w.head(tag, tarsSimpleList)
+	w.head(0, tarsInt8)
+	w.Int(0, int64(len(b)))
+	w.buf.Write(b)
+}
+
+func (w *tarsWriter) StringList(tag int, l []string) {
+	w.head(tag, tarsList)
+	w.Int(0, int64(len(l)))
+	for _, s := range l {
+		w.String(0, s)
+	}
+}
+
+// Struct 写一个嵌套的结构体, fields 写它的字段
+func (w *tarsWriter) Struct(tag int, fields func(w *tarsWriter)) {
+	w.head(tag, tarsStructBegin)
+	fields(w)
+	w.head(0, tarsStructEnd)
+}
@@ -0,0 +1,179 @@
+// Package provider 把不同直播平台的房间信息, 直播流和弹幕统一起来.
+// 房间用 "douyu:156277" 这样的地址表示, 冒号前是平台, 平台由各自的包在
+// init 里用 Register 注册, 使用时导入对应的包:
+//
+//	import _ "github.com/zwh8800/Love66/provider/douyu"
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+// DefaultScheme 是没有写平台的地址使用的平台, 和 danmuku.DefaultScheme 相同
+const DefaultScheme = danmuku.DefaultScheme
+
+var ErrBadAddr = danmuku.ErrBadAddr
+
+// Info 是房间的基本信息
+type Info struct {
+	Online   bool
+	Title    string
+	Nickname string
+	Category string
+}
+
+// Stream 是一个可以交给播放器的直播流
+type Stream struct {
+	Url     string
+	Quality string
+	Format  string
+	// Cdn 是线路, 平台不区分线路时为空
+	Cdn string
+	// Bitrate 是码率 (kbps), 原画或者不知道时为 0
+	Bitrate int
+}
+
+// Provider 是一个直播平台. roomId 是 Resolve 返回的平台内的房间号.
+type Provider interface {
+	// Resolve 把地址里的房间号或别名解析成真实的房间号
+	Resolve(ctx context.Context, id string) (int, error)
+	Info(ctx context.Context, roomId int) (*Info, error)
+	// Streams 返回可以播放的直播流, 按推荐程度排序, 没有直播时为空
+	Streams(ctx context.Context, roomId int) ([]Stream, error)
+	// Chat 返回房间的弹幕事件源, 事件的 Room() 是 roomId, Addr() 和
+	// Room.String 相同
+	Chat(roomId int) danmuku.Source
+}
+
+var (
+	providersMutex sync.Mutex
+	providers      = make(map[string]Provider)
+)
+
+// Register 注册一个平台, 重复注册时 panic
+func Register(scheme string, p Provider) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	if _, ok := providers[scheme]; ok {
+		panic("provider: Register called twice for " + scheme)
+	}
+	providers[scheme] = p
+}
+
+func Lookup(scheme string) (Provider, bool) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	p, ok := providers[scheme]
+	return p, ok
+}
+
+// Schemes 返回已经注册的平台, 按名字排序
+func Schemes() []string {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	schemes := make([]string, 0, len(providers))
+	for scheme := range providers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// ParseAddr 把 "douyu:156277" 拆成平台和房间号或别名, 规则见
+// danmuku.SplitRoomAddr.
+func ParseAddr(addr string) (scheme, id string, err error) {
+	return danmuku.SplitRoomAddr(addr)
+}
+
+// Room 是一个已经解析的房间, 缓存最近一次获取的信息. 不是并发安全的.
+type Room struct {
+	Scheme   string
+	Id       int
+	Provider Provider
+	// Preference 是 StreamUrl 选择直播流时的偏好
+	Preference Preference
+
+	info    *Info
+	updated time.Time
+}
+
+// Open 解析地址并获取房间信息
+func Open(ctx context.Context, addr string) (*Room, error) {
+	scheme, id, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := Lookup(scheme)
+	if !ok {
+		return nil, fmt.Errorf("provider: unknown provider %q", scheme)
+	}
+	roomId, err := p.Resolve(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r := &Room{Scheme: scheme, Id: roomId, Provider: p}
+	if err := r.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// String 返回 "douyu:156277"
+func (r *Room) String() string {
+	return danmuku.RoomAddr(r.Scheme, r.Id)
+}
+
+// Refresh 重新获取房间信息, 失败时保留原来的信息
+func (r *Room) Refresh(ctx context.Context) error {
+	info, err := r.Provider.Info(ctx, r.Id)
+	if err != nil {
+		return err
+	}
+	r.info = info
+	r.updated = time.Now()
+	return nil
+}
+
+func (r *Room) RefreshIfExpire(ctx context.Context, expire time.Duration) {
+	if time.Since(r.updated) > expire {
+		r.Refresh(ctx)
+	}
+}
+
+// Info 返回最近一次获取的信息, 不会是 nil
+func (r *Room) Info() *Info {
+	if r.info == nil {
+		return &Info{}
+	}
+	return r.info
+}
+
+// StreamUrl 返回最符合 Preference 的直播流的地址, 没有直播或者出错时
+// 返回空字符串
+func (r *Room) StreamUrl(ctx context.Context) string {
+	if s, ok := r.Provider.(StreamSelector); ok {
+		stream, err := s.SelectStream(ctx, r.Id, r.Preference)
+		if err != nil || stream == nil {
+			return ""
+		}
+		return stream.Url
+	}
+	streams, err := r.Provider.Streams(ctx, r.Id)
+	if err != nil {
+		return ""
+	}
+	if i := r.Preference.Select(streams); i >= 0 {
+		return streams[i].Url
+	}
+	return ""
+}
+
+func (r *Room) Chat() danmuku.Source {
+	return r.Provider.Chat(r.Id)
+}
@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/zwh8800/Love66/danmuku"
+)
+
+type fakeProvider struct {
+	online bool
+	fail   bool
+}
+
+func (p *fakeProvider) Resolve(ctx context.Context, id string) (int, error) {
+	if id == "alias" {
+		return 42, nil
+	}
+	return strconv.Atoi(id)
+}
+
+func (p *fakeProvider) Info(ctx context.Context, roomId int) (*Info, error) {
+	if p.fail {
+		return nil, errors.New("network down")
+	}
+	return &Info{Online: p.online, Title: "room " + strconv.Itoa(roomId)}, nil
+}
+
+func (p *fakeProvider) Streams(ctx context.Context, roomId int) ([]Stream, error) {
+	if !p.online {
+		return nil, nil
+	}
+	return []Stream{{Url: "http://example.com/1.flv", Format: "flv"}, {Url: "http://example.com/2.m3u8", Format: "hls"}}, nil
+}
+
+func (p *fakeProvider) Chat(roomId int) danmuku.Source {
+	return nil
+}
+
+func TestParseAddr(t *testing.T) {
+	cases := []struct {
+		addr, scheme, id string
+		err              error
+	}{
+		{"156277", "douyu", "156277", nil},
+		{"douyu:156277", "douyu", "156277", nil},
+		{"Bilibili:21452505", "bilibili", "21452505", nil},
+		{" huya:kpl ", "huya", "kpl", nil},
+		{"huya:", "", "", ErrBadAddr},
+		{":1", "", "", ErrBadAddr},
+		{"", "", "", ErrBadAddr},
+	}
+	for _, c := range cases {
+		scheme, id, err := ParseAddr(c.addr)
+		if scheme != c.scheme || id != c.id || err != c.err {
+			t.Errorf("ParseAddr(%q) = %q, %q, %v", c.addr, scheme, id, err)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	p := &fakeProvider{online: true}
+	Register("fake", p)
+	defer func() {
+		providersMutex.Lock()
+		delete(providers, "fake")
+		providersMutex.Unlock()
+	}()
+
+	if got, ok := Lookup("fake"); !ok || got != p {
+		t.Error("Lookup(fake) failed")
+	}
+	if _, ok := Lookup("nope"); ok {
+		t.Error("Lookup(nope) should fail")
+	}
+	if !reflect.DeepEqual(Schemes(), []string{"fake"}) {
+		t.Error("unexpected schemes", Schemes())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Register twice should panic")
+		}
+	}()
+	Register("fake", p)
+}
+
+func TestOpen(t *testing.T) {
+	p := &fakeProvider{online: true}
+	Register("fake", p)
+	defer func() {
+		providersMutex.Lock()
+		delete(providers, "fake")
+		providersMutex.Unlock()
+	}()
+	ctx := context.Background()
+
+	r, err := Open(ctx, "fake:alias")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.String() != "fake:42" || !r.Info().Online || r.Info().Title != "room 42" {
+		t.Errorf("unexpected room %s %#v", r, r.Info())
+	}
+	if url := r.StreamUrl(ctx); url != "http://example.com/1.flv" {
+		t.Error("unexpected stream url", url)
+	}
+	r.Preference = Preference{Format: "HLS"}
+	if url := r.StreamUrl(ctx); url != "http://example.com/2.m3u8" {
+		t.Error("unexpected stream url", url)
+	}
+
+	// 刷新失败时保留原来的信息
+	p.online, p.fail = false, true
+	if err := r.Refresh(ctx); err == nil {
+		t.Error("expected error")
+	}
+	if !r.Info().Online {
+		t.Error("info should be kept")
+	}
+	if url := r.StreamUrl(ctx); url != "" {
+		t.Error("offline room should have no stream", url)
+	}
+
+	if _, err := Open(ctx, "nope:1"); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+	if _, err := Open(ctx, "fake:x"); err == nil {
+		t.Error("expected error for bad room id")
+	}
+}
+
+func TestPreference(t *testing.T) {
+	streams := []Stream{
+		{Url: "0", Quality: "原画", Format: "flv", Cdn: "ws"},
+		{Url: "1", Quality: "原画", Format: "hls", Cdn: "ws"},
+		{Url: "2", Quality: "高清", Format: "flv", Cdn: "ws", Bitrate: 900},
+		{Url: "3", Quality: "流畅", Format: "flv", Cdn: "ws", Bitrate: 500},
+		{Url: "4", Quality: "流畅", Format: "hls", Cdn: "tct", Bitrate: 500},
+		{Url: "5", Quality: "超清", Format: "flv", Cdn: "tct", Bitrate: 2000},
+	}
+	cases := []struct {
+		pref Preference
+		want int
+	}{
+		{Preference{}, 0},
+		{Preference{Quality: QualityLowest}, 3},
+		{Preference{Quality: "Highest"}, 0},
+		{Preference{Quality: "高清"}, 2},
+		{Preference{Quality: "蓝光"}, 0},
+		{Preference{Format: "hls"}, 1},
+		{Preference{Format: "hls", Quality: QualityLowest}, 4},
+		{Preference{Cdn: "TCT", Quality: QualityHighest}, 5},
+		{Preference{Cdn: "tct", Format: "flv"}, 5},
+		// 没有符合的格式和线路时忽略
+		{Preference{Cdn: "ali", Format: "rtmp", Quality: QualityLowest}, 3},
+	}
+	for _, c := range cases {
+		if got := c.pref.Select(streams); got != c.want {
+			t.Errorf("%+v: Select() = %d, want %d", c.pref, got, c.want)
+		}
+	}
+	if got := (Preference{}).Select(nil); got != -1 {
+		t.Errorf("Select(nil) = %d, want -1", got)
+	}
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"context"
+	"strings"
+)
+
+// Preference.Quality 的特殊值, 其它值按清晰度名字匹配
+const (
+	QualityLowest  = "lowest"
+	QualityHighest = "highest"
+)
+
+// Preference 是播放列表里给房间设置的直播流偏好, 空字段表示使用平台
+// 推荐的直播流. 没有符合的直播流时忽略这一项.
+type Preference struct {
+	// Quality 是 QualityLowest, QualityHighest 或者清晰度的名字, 比如 "高清"
+	Quality string `json:"quality,omitempty"`
+	// Format 是 "flv" 或 "hls"
+	Format string `json:"format,omitempty"`
+	// Cdn 是线路, 和 Stream.Cdn 比较
+	Cdn string `json:"cdn,omitempty"`
+}
+
+// StreamSelector 是清晰度和线路要分别请求的平台, 比如斗鱼. Room.StreamUrl
+// 优先使用它, 没有实现时从 Streams 里用 Preference.Select 选择.
+type StreamSelector interface {
+	// SelectStream 返回最符合 pref 的直播流, 没有直播时返回 nil
+	SelectStream(ctx context.Context, roomId int, pref Preference) (*Stream, error)
+}
+
+// Select 返回 streams 里最符合偏好的直播流的下标, streams 为空时返回 -1.
+// 先按格式和线路筛选, 再按清晰度选择; 码率为 0 的直播流当作原画.
+func (p Preference) Select(streams []Stream) int {
+	candidates := make([]int, 0, len(streams))
+	for i := range streams {
+		candidates = append(candidates, i)
+	}
+	candidates = filter(candidates, func(i int) bool {
+		return p.Format == "" || strings.EqualFold(streams[i].Format, p.Format)
+	})
+	candidates = filter(candidates, func(i int) bool {
+		return p.Cdn == "" || strings.EqualFold(streams[i].Cdn, p.Cdn)
+	})
+	if len(candidates) == 0 {
+		return -1
+	}
+
+	best := candidates[0]
+	switch strings.ToLower(p.Quality) {
+	case "":
+	case QualityLowest:
+		for _, i := range candidates {
+			if lower(streams[i].Bitrate, streams[best].Bitrate) {
+				best = i
+			}
+		}
+	case QualityHighest:
+		for _, i := range candidates {
+			if lower(streams[best].Bitrate, streams[i].Bitrate) {
+				best = i
+			}
+		}
+	default:
+		for _, i := range candidates {
+			if streams[i].Quality == p.Quality {
+				return i
+			}
+		}
+	}
+	return best
+}
+
+// lower 比较码率, 0 是原画, 比其它码率都高
+func lower(a, b int) bool {
+	if a == 0 {
+		return false
+	}
+	return b == 0 || a < b
+}
+
+// filter 返回 candidates 里满足 f 的部分, 一个都不满足时原样返回
+func filter(candidates []int, f func(i int) bool) []int {
+	matched := make([]int, 0, len(candidates))
+	for _, i := range candidates {
+		if f(i) {
+			matched = append(matched, i)
+		}
+	}
+	if len(matched) == 0 {
+		return candidates
+	}
+	return matched
+}
@@ -0,0 +1,233 @@
+package room
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultUserAgent 是手机浏览器的 UA, 房间信息接口在 m.douyu.com 上
+	DefaultUserAgent = "Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1"
+	DefaultTimeout   = 10 * time.Second
+	DefaultRetries   = 2
+	DefaultRetryWait = 500 * time.Millisecond
+)
+
+var (
+	ErrRoomNotFound = errors.New("room: room not found")
+	ErrRoomClosed   = errors.New("room: room closed")
+)
+
+// APIError 是接口返回的其它错误码
+type APIError struct {
+	Code int
+	Msg  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("room: error %d: %s", e.Code, e.Msg)
+}
+
+// StatusError 是接口回复的 HTTP 状态不是 200
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "room: " + e.Status
+}
+
+// apiErrors 是已知的错误码, 接口不同版本的错误码不完全一样
+var apiErrors = map[int]error{
+	-3:  ErrRoomNotFound,
+	101: ErrRoomNotFound,
+	102: ErrRoomNotFound,
+	-4:  ErrRoomClosed,
+	104: ErrRoomClosed,
+}
+
+// apiError 把错误码换成 ErrRoomNotFound 或 ErrRoomClosed, 未知的错误码
+// 按错误信息判断, 都不是时返回 *APIError.
+func apiError(code int, msg string) error {
+	if err, ok := apiErrors[code]; ok {
+		return err
+	}
+	switch {
+	case strings.Contains(msg, "关闭") || strings.Contains(msg, "封禁"):
+		return ErrRoomClosed
+	case strings.Contains(msg, "未找到") || strings.Contains(msg, "不存在"):
+		return ErrRoomNotFound
+	}
+	return &APIError{code, msg}
+}
+
+// Client 请求斗鱼的房间信息接口. 零值可以直接使用.
+type Client struct {
+	// HTTPClient 为 nil 时使用超时为 DefaultTimeout 的 client
+	HTTPClient *http.Client
+	// BaseURL 是房间信息接口的地址, 为空时使用 LiveAPI
+	BaseURL string
+	// RoomPage 和 H5PlayAPI 是签名脚本所在的房间页面和需要签名的直播流接口,
+	// 为空时使用同名的包变量
+	RoomPage  string
+	H5PlayAPI string
+	// DisableH5Play 时 Play 只使用旧的房间信息接口
+	DisableH5Play bool
+	// UserAgent 为空时使用 DefaultUserAgent
+	UserAgent string
+	// Retries 是遇到 5xx 时的重试次数, 0 时使用 DefaultRetries, 负数不重试
+	Retries int
+	// RetryWait 是第一次重试前的等待时间, 之后每次翻倍, 0 时使用 DefaultRetryWait
+	RetryWait time.Duration
+}
+
+// DefaultClient 是 NewDouyuRoom 使用的 Client
+var DefaultClient = &Client{}
+
+var defaultHTTPClient = &http.Client{Timeout: DefaultTimeout}
+
+// Room 请求房间信息并返回房间
+func (c *Client) Room(ctx context.Context, roomId int) (*DouyuRoom, error) {
+	roomInfo, err := c.getRoomInfo(ctx, roomId, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &DouyuRoom{c, roomId, roomInfo, time.Now()}, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return defaultHTTPClient
+}
+
+func (c *Client) roomPage() string {
+	if c.RoomPage != "" {
+		return c.RoomPage
+	}
+	return RoomPage
+}
+
+func (c *Client) h5PlayAPI() string {
+	if c.H5PlayAPI != "" {
+		return c.H5PlayAPI
+	}
+	return H5PlayAPI
+}
+
+func (c *Client) apiUrl(roomId int, query url.Values) (string, error) {
+	base := c.BaseURL
+	if base == "" {
+		base = LiveAPI
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("roomId", strconv.Itoa(roomId))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Get 请求 url 并返回内容, 给其它平台的页面和接口使用. 和房间信息接口
+// 一样有超时, 遇到 5xx 时重试, 其它不是 200 的回复返回 *StatusError.
+func (c *Client) Get(ctx context.Context, url string) ([]byte, error) {
+	return c.do(ctx, "GET", url, nil)
+}
+
+// do 请求 url, form 不为空时用 POST 提交表单. 遇到 5xx 时等待后重试.
+func (c *Client) do(ctx context.Context, method, url string, form url.Values) ([]byte, error) {
+	retries, wait := c.Retries, c.RetryWait
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	if wait == 0 {
+		wait = DefaultRetryWait
+	}
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", userAgent)
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		resp, err := c.httpClient().Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 500 || attempt >= retries {
+			if resp.StatusCode != http.StatusOK {
+				return nil, &StatusError{resp.StatusCode, resp.Status}
+			}
+			return data, err
+		}
+
+		select {
+		case <-time.After(wait << uint(attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// decodeAPI 检查接口返回的错误码, 然后把回复解析到 v
+func decodeAPI(data []byte, v interface{}) error {
+	// 出错时 data 的类型不一定, 先只解析错误码
+	var status struct {
+		Error int    `json:"error"`
+		Msg   string `json:"msg"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return err
+	}
+	if status.Error != 0 {
+		return apiError(status.Error, status.Msg)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// getRoomInfo 请求房间信息, query 是额外的参数
+func (c *Client) getRoomInfo(ctx context.Context, roomId int, query url.Values) (*douyuRoomInfoJson, error) {
+	url, err := c.apiUrl(roomId, query)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.do(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	var info douyuRoomInfoJson
+	if err := decodeAPI(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
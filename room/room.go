@@ -1,32 +1,33 @@
 package room
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
-	"net/url"
+	"context"
 	"strconv"
 	"time"
 )
 
+// LiveAPI 是房间信息接口, 测试时可以换成 douyutest.API 的地址
+var LiveAPI = "https://m.douyu.com/html5/live"
+
 type DouyuRoom struct {
+	client      *Client
 	roomId      int
 	roomInfo    *douyuRoomInfoJson
 	lastRefresh time.Time
 }
 
+// NewDouyuRoom 用 DefaultClient 请求房间信息
 func NewDouyuRoom(roomId int) (*DouyuRoom, error) {
-	url := resolveApiUrl(roomId)
-	roomInfo, err := getRoomInfo(url)
-	if err != nil {
-		return nil, err
-	}
-	return &DouyuRoom{roomId, roomInfo, time.Now()}, nil
+	return DefaultClient.Room(context.Background(), roomId)
 }
 
 func (r *DouyuRoom) Refresh() error {
-	url := resolveApiUrl(r.roomId)
-	roomInfo, err := getRoomInfo(url)
+	return r.RefreshContext(context.Background())
+}
+
+// RefreshContext 重新请求房间信息, 失败时保留原来的信息
+func (r *DouyuRoom) RefreshContext(ctx context.Context) error {
+	roomInfo, err := r.client.getRoomInfo(ctx, r.roomId, nil)
 	if err != nil {
 		return err
 	}
@@ -73,49 +74,20 @@ func (r *DouyuRoom) LiveStreamUrl() string {
 	}
 }
 
-func resolveApiUrl(roomId int) string {
-	u, err := url.Parse("http://m.douyu.com/html5/live")
-	if err != nil {
-		return ""
-	}
-	q := u.Query()
-	q.Set("roomId", strconv.Itoa(roomId))
-	u.RawQuery = q.Encode()
-
-	return u.String()
-}
-
-func getRoomInfo(url string) (*douyuRoomInfoJson, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	respBodyData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	var info douyuRoomInfoJson
-
-	if err := json.Unmarshal(respBodyData, &info); err != nil {
-		return nil, err
-	}
-	return &info, nil
-}
-
 // 不关注的字段统统用interface{}, 防止parse出错
 type douyuRoomInfoJson struct {
 	Error int    `json:"error"`
 	Msg   string `json:"msg"`
 	Data  struct {
-		RoomID       string `json:"room_id"`
-		TagName      string `json:"tag_name"`
-		RoomSrc      string `json:"room_src"`
-		RoomName     string `json:"room_name"`
-		ShowStatus   string `json:"show_status"`
-		Online       int    `json:"online"`
-		Nickname     string `json:"nickname"`
-		HlsURL       string `json:"hls_url"`
+		RoomID     string `json:"room_id"`
+		TagName    string `json:"tag_name"`
+		RoomSrc    string `json:"room_src"`
+		RoomName   string `json:"room_name"`
+		ShowStatus string `json:"show_status"`
+		Online     int    `json:"online"`
+		Nickname   string `json:"nickname"`
+		HlsURL     string `json:"hls_url"`
+		playJson
 		IsPassPlayer int    `json:"is_pass_player"`
 		IsTicket     int    `json:"is_ticket"`
 		StoreLink    string `json:"storeLink"`
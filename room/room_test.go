@@ -1,31 +1,321 @@
 package room
 
-import "testing"
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku/douyutest"
+)
 
 func TestGetInfo(t *testing.T) {
-	roomIds := []int{156277, 3258, 423574, 60937}
-	rooms := make([]*DouyuRoom, 0)
-	for _, id := range roomIds {
-		room, err := NewDouyuRoom(id)
-		if err != nil {
-			t.Logf("Error in NewDouyuRoom(%d): %s\n", id, err)
-			continue
+	api := douyutest.NewAPI()
+	defer api.Close()
+	defer func(u string) { LiveAPI = u }(LiveAPI)
+	LiveAPI = api.LiveAPI()
+
+	room, err := NewDouyuRoom(douyutest.RoomOnline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !room.Online() || room.RoomId() != 156277 || room.RoomName() != "测试直播间" ||
+		room.Nickname() != "测试主播" || room.GameName() != "英雄联盟" ||
+		!strings.HasPrefix(room.LiveStreamUrl(), "https://hls3a.douyucdn.cn/live/156277") {
+		t.Errorf("unexpected room %#v", room.roomInfo.Data)
+	}
+
+	room, err = NewDouyuRoom(douyutest.RoomOffline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Online() || room.LiveStreamUrl() != "" || room.RoomName() != "下播了" {
+		t.Errorf("unexpected room %#v", room.roomInfo.Data)
+	}
+
+	// 下播后刷新
+	api.SetInfo(douyutest.RoomOnline, douyutest.Response{Body: douyutest.InfoOffline})
+	room, _ = NewDouyuRoom(douyutest.RoomOnline)
+	if room.Online() {
+		t.Error("room should be offline")
+	}
+	if requests := api.Requests(); requests[0] != "/html5/live?roomId=156277" {
+		t.Error("unexpected request", requests[0])
+	}
+}
+
+func TestGetInfoErrors(t *testing.T) {
+	api := douyutest.NewAPI()
+	defer api.Close()
+	defer func(u string) { LiveAPI = u }(LiveAPI)
+	LiveAPI = api.LiveAPI()
+
+	api.SetInfo(2, douyutest.Response{Body: douyutest.InfoMalformed})
+	api.SetInfo(3, douyutest.Response{Status: http.StatusNotFound, Body: "not found"})
+	api.SetInfo(4, douyutest.Response{Body: `{"error":999,"msg":"未知错误","data":[]}`})
+	for _, roomId := range []int{1, 2, 3, 4} {
+		if room, err := NewDouyuRoom(roomId); err == nil {
+			t.Errorf("room %d: expected error, got %#v", roomId, room.roomInfo)
+		}
+	}
+	if _, err := NewDouyuRoom(1); err != ErrRoomNotFound {
+		t.Errorf("err = %v, want ErrRoomNotFound", err)
+	}
+	if _, err := NewDouyuRoom(4); err == nil || err.Error() != "room: error 999: 未知错误" {
+		t.Error("unexpected error", err)
+	}
+	api.SetInfo(5, douyutest.Response{Body: douyutest.InfoClosed})
+	if _, err := NewDouyuRoom(5); err != ErrRoomClosed {
+		t.Errorf("err = %v, want ErrRoomClosed", err)
+	}
+
+	room, err := NewDouyuRoom(douyutest.RoomOnline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 刷新失败时保留原来的信息
+	api.SetInfo(douyutest.RoomOnline, douyutest.Response{Body: douyutest.InfoMalformed})
+	if err := room.Refresh(); err == nil {
+		t.Error("expected error")
+	}
+	if !room.Online() {
+		t.Error("room info should be kept")
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	cases := []struct {
+		code int
+		msg  string
+		err  error
+	}{
+		{-3, "房间未找到", ErrRoomNotFound},
+		{101, "", ErrRoomNotFound},
+		{-4, "", ErrRoomClosed},
+		{1, "该房间已被封禁", ErrRoomClosed},
+		{1, "房间不存在", ErrRoomNotFound},
+	}
+	for _, c := range cases {
+		if err := apiError(c.code, c.msg); err != c.err {
+			t.Errorf("apiError(%d, %q) = %v, want %v", c.code, c.msg, err, c.err)
+		}
+	}
+	if err, ok := apiError(1, "系统繁忙").(*APIError); !ok || err.Code != 1 || err.Msg != "系统繁忙" {
+		t.Errorf("unexpected error %#v", err)
+	}
+}
+
+// recorder 记录请求的 User-Agent
+type recorder struct {
+	mutex      sync.Mutex
+	userAgents []string
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mutex.Lock()
+	r.userAgents = append(r.userAgents, req.Header.Get("User-Agent"))
+	r.mutex.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClient(t *testing.T) {
+	api := douyutest.NewAPI()
+	defer api.Close()
+	rec := &recorder{}
+	client := &Client{
+		HTTPClient: &http.Client{Transport: rec},
+		BaseURL:    api.LiveAPI(),
+		UserAgent:  "test-agent",
+		RetryWait:  time.Millisecond,
+	}
+	ctx := context.Background()
+
+	room, err := client.Room(ctx, douyutest.RoomOnline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !room.Online() {
+		t.Error("room should be online")
+	}
+
+	// 5xx 时重试, 最后一次的错误返回给调用方
+	api.SetInfo(douyutest.RoomOnline, douyutest.Response{Status: http.StatusBadGateway, Body: "bad gateway"})
+	if err := room.RefreshContext(ctx); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Error("unexpected error", err)
+	}
+	if n := len(api.Requests()); n != 1+1+DefaultRetries {
+		t.Errorf("expected %d requests, got %d", 1+1+DefaultRetries, n)
+	}
+	rec.mutex.Lock()
+	for _, ua := range rec.userAgents {
+		if ua != "test-agent" {
+			t.Error("unexpected user agent", ua)
+		}
+	}
+	rec.mutex.Unlock()
+
+	// 4xx 不重试
+	client.Retries = 5
+	api.SetInfo(douyutest.RoomOnline, douyutest.Response{Status: http.StatusForbidden, Body: "forbidden"})
+	before := len(api.Requests())
+	if err, ok := room.RefreshContext(ctx).(*StatusError); !ok || err.Code != http.StatusForbidden {
+		t.Error("expected StatusError", err)
+	}
+	if n := len(api.Requests()) - before; n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+
+	// 重试的等待可以被 ctx 取消
+	client.RetryWait = time.Hour
+	api.SetInfo(douyutest.RoomOnline, douyutest.Response{Status: http.StatusServiceUnavailable, Body: "unavailable"})
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := room.RefreshContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+	if !room.Online() {
+		t.Error("room info should be kept")
+	}
+
+	// 没有重试时直接返回
+	client.Retries = -1
+	client.RetryWait = time.Hour
+	before = len(api.Requests())
+	if err := room.RefreshContext(context.Background()); err == nil {
+		t.Error("expected error")
+	}
+	if n := len(api.Requests()) - before; n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	api := douyutest.NewAPI()
+	defer api.Close()
+	api.SetPlay(douyutest.RoomOnline, 1, "tct-h5", douyutest.Response{Body: douyutest.InfoOnlineLow})
+	client := &Client{BaseURL: api.LiveAPI(), DisableH5Play: true}
+	ctx := context.Background()
+
+	room, err := client.Room(ctx, douyutest.RoomOnline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	play := room.Play()
+	if play.Rate != RateSource || play.RateName() != "原画" || play.Cdn != "ws-h5" ||
+		len(play.Rates) != 4 || len(play.Lines) != 2 || play.Lines[1].Name != "备用线路5" ||
+		play.FlvUrl != "https://hdl3a.douyucdn.cn/live/156277rGXYXxoMzv.flv?wsAuth=5d1e&token=h5-douyu-0-156277" ||
+		play.HlsUrl != room.LiveStreamUrl() {
+		t.Errorf("unexpected play %#v", play)
+	}
+
+	play, err = client.Play(ctx, douyutest.RoomOnline, 1, "tct-h5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if play.Rate != 1 || play.RateName() != "流畅" || play.Cdn != "tct-h5" ||
+		play.FlvUrl != "https://tc-tct.douyucdn2.cn/dyliveflv1/156277rGXYXxoMzv_500.flv?txSecret=7c2e&txTime=6553f100" {
+		t.Errorf("unexpected play %#v", play)
+	}
+	requests := api.Requests()
+	if last := requests[len(requests)-1]; last != "/html5/live?cdn=tct-h5&rate=1&roomId=156277" {
+		t.Error("unexpected request", last)
+	}
+
+	play, err = client.Play(ctx, douyutest.RoomOffline, RateSource, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if play.FlvUrl != "" || play.HlsUrl != "" {
+		t.Errorf("offline room should have no stream %#v", play)
+	}
+	if _, err := client.Play(ctx, 1, RateSource, ""); err != ErrRoomNotFound {
+		t.Errorf("err = %v, want ErrRoomNotFound", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	params, err := sign([]byte(douyutest.H5Page), 156277, DeviceId, 1700000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// md5("156277" + DeviceId + "1700000000" + "220120231018")
+	if params.Get("v") != douyutest.SignVersion || params.Get("did") != DeviceId || params.Get("tt") != "1700000000" ||
+		params.Get("sign") != "4bb17d8b740e94a44fb3e141a1e1c842" {
+		t.Errorf("unexpected params %v", params)
+	}
+
+	bad := []string{
+		"<html><body>没有脚本</body></html>",
+		"<script>function ub98484234(a, b, c) { return undefinedFunction(); }</script>",
+		"<script>function ub98484234(a, b, c) { return 'v=1&did=2'; }</script>",
+		"<script>function ub98484234(a, b, c) { syntax error</script>",
+	}
+	for _, page := range bad {
+		if params, err := sign([]byte(page), 1, DeviceId, 1); err == nil {
+			t.Errorf("sign(%q) = %v, expected error", page, params)
 		}
-		rooms = append(rooms, room)
+	}
+}
+
+func TestH5Play(t *testing.T) {
+	api := douyutest.NewAPI()
+	defer api.Close()
+	api.SetPage(douyutest.RoomOnline, douyutest.Response{Body: douyutest.H5Page})
+	api.SetPage(douyutest.RoomOffline, douyutest.Response{Body: douyutest.H5Page})
+	client := &Client{BaseURL: api.LiveAPI(), RoomPage: api.RoomPage(), H5PlayAPI: api.H5PlayAPI(), RetryWait: time.Millisecond}
+	ctx := context.Background()
+
+	play, err := client.Play(ctx, douyutest.RoomOnline, 2, "tct-h5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if play.Cdn != "hw-h5" || play.RateName() != "原画" || len(play.Rates) != 4 || len(play.Lines) != 2 || play.HlsUrl != "" ||
+		!strings.HasPrefix(play.FlvUrl, "https://hw-tct.douyucdn.cn/live/156277rGXYXxoMzv.flv?wsAuth=9f2c") {
+		t.Errorf("unexpected play %#v", play)
+	}
+	requests := api.Requests()
+	if len(requests) != 2 || requests[0] != "/156277" ||
+		!strings.HasPrefix(requests[1], "POST /lapi/live/getH5Play/156277 cdn=tct-h5&did="+DeviceId) ||
+		!strings.Contains(requests[1], "&rate=2&sign=") {
+		t.Error("unexpected requests", requests)
+	}
+
+	play, err = client.Play(ctx, douyutest.RoomOffline, RateSource, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if play.FlvUrl != "" || play.HlsUrl != "" || len(api.Requests()) != 4 {
+		t.Errorf("offline room should have no stream %#v", play)
 	}
 
-	for _, room := range rooms {
-		var onlineStr string
-		if room.Online() {
-			onlineStr = "is online"
-		} else {
-			onlineStr = "is offline"
+	// 签名错误, 没有签名脚本或者页面打不开时使用旧的接口
+	api.SetH5Play(douyutest.RoomOnline, douyutest.Response{Body: douyutest.H5PlayBadSign})
+	play, err = client.Play(ctx, douyutest.RoomOnline, RateSource, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if play.Cdn != "ws-h5" || play.HlsUrl == "" {
+		t.Errorf("expected legacy play %#v", play)
+	}
+	for _, page := range []douyutest.Response{{Body: "<html></html>"}, {Status: http.StatusBadGateway, Body: "bad gateway"}} {
+		api.SetPage(douyutest.RoomOnline, page)
+		play, err = client.Play(ctx, douyutest.RoomOnline, RateSource, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if play.Cdn != "ws-h5" || play.HlsUrl == "" {
+			t.Errorf("expected legacy play %#v", play)
 		}
-		t.Logf("room #%d: ", room.RoomId())
-		t.Logf("\troom %s", onlineStr)
-		t.Logf("\troom name: %s", room.RoomName())
-		t.Logf("\thost nickname: %s", room.Nickname())
-		t.Logf("\tgame name: %s", room.GameName())
-		t.Logf("\tlive stream url: %s", room.LiveStreamUrl())
+	}
+	requests = api.Requests()
+	if last := requests[len(requests)-1]; last != "/html5/live?rate=0&roomId=156277" {
+		t.Error("unexpected request", last)
+	}
+
+	// 两个接口都没有的房间
+	if _, err := client.Play(ctx, 1, RateSource, ""); err != ErrRoomNotFound {
+		t.Errorf("err = %v, want ErrRoomNotFound", err)
 	}
 }
@@ -0,0 +1,80 @@
+package room
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// signTimeout 限制签名脚本的运行时间, 防止混淆后的脚本死循环
+const signTimeout = 2 * time.Second
+
+var (
+	errNoSignScript = errors.New("room: sign script not found")
+	errBadSign      = errors.New("room: bad sign result")
+
+	scriptPattern   = regexp.MustCompile(`(?s)<script[^>]*>(.*?)</script>`)
+	signFuncPattern = regexp.MustCompile(`function\s+(ub\d+)\s*\(`)
+)
+
+// signScript 从房间页面里找出签名脚本和签名函数的名字. 函数名一直是
+// ub98484234 这样的形式, 脚本内容每隔一段时间会重新混淆.
+func signScript(page []byte) (script, name string, err error) {
+	for _, m := range scriptPattern.FindAllSubmatch(page, -1) {
+		if f := signFuncPattern.FindSubmatch(m[1]); f != nil {
+			return string(m[1]), string(f[1]), nil
+		}
+	}
+	return "", "", errNoSignScript
+}
+
+// sign 在 JS 引擎里运行签名脚本, 返回请求 getH5Play 的参数 (v, did, tt,
+// sign). 脚本会调用 CryptoJS.MD5, 这里用 Go 实现.
+func sign(page []byte, roomId int, did string, tt int64) (url.Values, error) {
+	script, name, err := signScript(page)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := goja.New()
+	cryptoJS := vm.NewObject()
+	cryptoJS.Set("MD5", func(call goja.FunctionCall) goja.Value {
+		sum := md5.Sum([]byte(call.Argument(0).String()))
+		digest := hex.EncodeToString(sum[:])
+		result := vm.NewObject()
+		result.Set("toString", func(goja.FunctionCall) goja.Value {
+			return vm.ToValue(digest)
+		})
+		return result
+	})
+	vm.Set("CryptoJS", cryptoJS)
+	vm.Set("window", vm.GlobalObject())
+
+	timer := time.AfterFunc(signTimeout, func() {
+		vm.Interrupt("room: sign script timeout")
+	})
+	defer timer.Stop()
+
+	if _, err := vm.RunString(script); err != nil {
+		return nil, err
+	}
+	fn, ok := goja.AssertFunction(vm.Get(name))
+	if !ok {
+		return nil, errNoSignScript
+	}
+	result, err := fn(goja.Undefined(), vm.ToValue(strconv.Itoa(roomId)), vm.ToValue(did), vm.ToValue(strconv.FormatInt(tt, 10)))
+	if err != nil {
+		return nil, err
+	}
+	params, err := url.ParseQuery(result.String())
+	if err != nil || params.Get("sign") == "" {
+		return nil, errBadSign
+	}
+	return params, nil
+}
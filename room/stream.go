@@ -0,0 +1,162 @@
+package room
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 直播流的格式
+const (
+	FormatFLV = "flv"
+	FormatHLS = "hls"
+)
+
+// RateSource 是原画的清晰度编号
+const RateSource = 0
+
+var (
+	// RoomPage 是房间页面的地址前缀, 签名脚本在页面里
+	RoomPage = "https://www.douyu.com/"
+	// H5PlayAPI 是需要签名的直播流接口的地址前缀
+	H5PlayAPI = "https://www.douyu.com/lapi/live/getH5Play/"
+	// DeviceId 是请求 getH5Play 时的设备 id, 参与签名
+	DeviceId = "10000000000000000000000000001501"
+)
+
+// h5PlayOffline 是 getH5Play 在没有直播时返回的错误码
+const h5PlayOffline = -5
+
+// Rate 是一个清晰度, Bit 是码率 (kbps), 原画的码率是 0
+type Rate struct {
+	Name string `json:"name"`
+	Rate int    `json:"rate"`
+	Bit  int    `json:"bit"`
+}
+
+// Line 是一条 CDN 线路
+type Line struct {
+	Name string `json:"name"`
+	Cdn  string `json:"cdn"`
+}
+
+// Play 是某个清晰度和线路的直播流, 同时列出房间可以选择的清晰度和线路.
+// 没有直播时地址为空.
+type Play struct {
+	Rate   int
+	Cdn    string
+	Rates  []Rate
+	Lines  []Line
+	FlvUrl string
+	HlsUrl string
+}
+
+// RateName 返回当前清晰度的名字
+func (p *Play) RateName() string {
+	for _, r := range p.Rates {
+		if r.Rate == p.Rate {
+			return r.Name
+		}
+	}
+	return ""
+}
+
+// playJson 是房间信息接口和 getH5Play 共有的直播流字段
+type playJson struct {
+	RtmpCdn      string `json:"rtmp_cdn"`
+	RtmpURL      string `json:"rtmp_url"`
+	RtmpLive     string `json:"rtmp_live"`
+	Rate         int    `json:"rate"`
+	MultiRates   []Rate `json:"multirates"`
+	CdnsWithName []Line `json:"cdnsWithName"`
+}
+
+func (j *playJson) play() *Play {
+	p := &Play{
+		Rate:  j.Rate,
+		Cdn:   j.RtmpCdn,
+		Rates: j.MultiRates,
+		Lines: j.CdnsWithName,
+	}
+	if j.RtmpURL != "" && j.RtmpLive != "" {
+		p.FlvUrl = j.RtmpURL + "/" + j.RtmpLive
+	}
+	return p
+}
+
+// Play 请求 rate 清晰度, cdn 线路的直播流. cdn 为空时使用默认线路,
+// 接口不支持的清晰度和线路会被换成默认的, 以返回的 Rate 和 Cdn 为准.
+//
+// 先用房间页面里的签名脚本请求 getH5Play, 失败时使用旧的房间信息接口.
+func (c *Client) Play(ctx context.Context, roomId, rate int, cdn string) (*Play, error) {
+	if !c.DisableH5Play {
+		play, err := c.h5Play(ctx, roomId, rate, cdn)
+		if err == nil {
+			return play, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Println("room: h5 play:", roomId, err)
+	}
+	return c.legacyPlay(ctx, roomId, rate, cdn)
+}
+
+// legacyPlay 请求 m.douyu.com 的房间信息接口, 它同时有 flv 和 hls
+func (c *Client) legacyPlay(ctx context.Context, roomId, rate int, cdn string) (*Play, error) {
+	query := url.Values{"rate": {strconv.Itoa(rate)}}
+	if cdn != "" {
+		query.Set("cdn", cdn)
+	}
+	info, err := c.getRoomInfo(ctx, roomId, query)
+	if err != nil {
+		return nil, err
+	}
+	return newPlay(info), nil
+}
+
+// h5Play 取得房间页面, 运行其中的签名脚本, 再用签名请求 getH5Play. 这个
+// 接口只有 flv.
+func (c *Client) h5Play(ctx context.Context, roomId, rate int, cdn string) (*Play, error) {
+	page, err := c.do(ctx, "GET", c.roomPage()+strconv.Itoa(roomId), nil)
+	if err != nil {
+		return nil, err
+	}
+	form, err := sign(page, roomId, DeviceId, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	form.Set("cdn", cdn)
+	form.Set("rate", strconv.Itoa(rate))
+	data, err := c.do(ctx, "POST", c.h5PlayAPI()+strconv.Itoa(roomId), form)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Data playJson `json:"data"`
+	}
+	if err := decodeAPI(data, &result); err != nil {
+		if err, ok := err.(*APIError); ok && err.Code == h5PlayOffline {
+			return &Play{Rate: rate, Cdn: cdn}, nil
+		}
+		return nil, err
+	}
+	return result.Data.play(), nil
+}
+
+func newPlay(info *douyuRoomInfoJson) *Play {
+	p := info.Data.play()
+	if info.Data.ShowStatus != "1" {
+		p.FlvUrl = ""
+		return p
+	}
+	p.HlsUrl = info.Data.HlsURL
+	return p
+}
+
+// Play 返回房间信息里默认清晰度和线路的直播流
+func (r *DouyuRoom) Play() *Play {
+	return newPlay(r.roomInfo)
+}
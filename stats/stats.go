@@ -0,0 +1,302 @@
+package stats
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/gift"
+)
+
+// 查询的时间窗口, Session 表示从收到第一个事件开始
+const (
+	Minute     = time.Minute
+	TenMinutes = 10 * time.Minute
+	Session    = time.Duration(0)
+)
+
+// 滑动窗口最长为 TenMinutes, 更早的事件只计入 Session
+const maxWindow = TenMinutes
+
+var emotePattern = regexp.MustCompile(`\[emot:[^\]]+\]`)
+
+// Tokens 把弹幕拆成词, 表情 [emot:xxx] 作为一个词, 其它按空白和标点分开.
+func Tokens(text string) []string {
+	tokens := emotePattern.FindAllString(text, -1)
+	text = emotePattern.ReplaceAllString(text, " ")
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+	return append(tokens, words...)
+}
+
+type Count struct {
+	Key   string
+	Count int
+}
+
+type Value struct {
+	Key   string
+	Value float64
+}
+
+// Snapshot 是一个房间在一个时间窗口内的统计
+type Snapshot struct {
+	Window    time.Duration
+	Messages  int
+	PerMinute float64
+	Chatters  int
+	Enters    int
+	GiftValue float64
+
+	TopChatters []Count
+	TopTokens   []Count
+	TopGifters  []Value
+}
+
+const (
+	kindChat = iota
+	kindGift
+	kindEnter
+)
+
+type entry struct {
+	time   time.Time
+	kind   int
+	uid    int
+	name   string
+	tokens []string
+	value  float64
+}
+
+// counter 累计一段时间内的统计, 可以加上或减去一个 entry
+type counter struct {
+	messages int
+	enters   int
+	value    float64
+	chatters map[int]int
+	names    map[int]string
+	tokens   map[string]int
+	gifters  map[int]float64
+}
+
+func newCounter() *counter {
+	return &counter{
+		chatters: make(map[int]int),
+		names:    make(map[int]string),
+		tokens:   make(map[string]int),
+		gifters:  make(map[int]float64),
+	}
+}
+
+func (c *counter) add(e *entry, n int) {
+	if e.name != "" {
+		c.names[e.uid] = e.name
+	}
+	switch e.kind {
+	case kindChat:
+		c.messages += n
+		c.chatters[e.uid] += n
+		if c.chatters[e.uid] == 0 {
+			delete(c.chatters, e.uid)
+		}
+		for _, token := range e.tokens {
+			c.tokens[token] += n
+			if c.tokens[token] == 0 {
+				delete(c.tokens, token)
+			}
+		}
+	case kindGift:
+		c.value += e.value * float64(n)
+		c.gifters[e.uid] += e.value * float64(n)
+		if n < 0 && c.gifters[e.uid] < 0.005 {
+			delete(c.gifters, e.uid)
+		}
+	case kindEnter:
+		c.enters += n
+	}
+	// 用户的事件都滑出窗口后名字也不再需要
+	if n < 0 {
+		_, chatting := c.chatters[e.uid]
+		_, gifting := c.gifters[e.uid]
+		if !chatting && !gifting {
+			delete(c.names, e.uid)
+		}
+	}
+}
+
+func (c *counter) name(uid int) string {
+	if name, ok := c.names[uid]; ok {
+		return name
+	}
+	return ""
+}
+
+type room struct {
+	start   time.Time
+	session *counter
+	// windows 里每个窗口的 counter 只包含 entries 里对应时间段内的事件,
+	// 事件滑出窗口时从 counter 里减去.
+	entries []*entry
+	windows map[time.Duration]*counter
+	heads   map[time.Duration]int
+}
+
+func newRoom(start time.Time) *room {
+	return &room{
+		start:   start,
+		session: newCounter(),
+		windows: map[time.Duration]*counter{Minute: newCounter(), TenMinutes: newCounter()},
+		heads:   map[time.Duration]int{Minute: 0, TenMinutes: 0},
+	}
+}
+
+func (r *room) expire(now time.Time) {
+	for window, c := range r.windows {
+		head := r.heads[window]
+		for head < len(r.entries) && now.Sub(r.entries[head].time) >= window {
+			c.add(r.entries[head], -1)
+			head++
+		}
+		r.heads[window] = head
+	}
+	// 最长的窗口之前的事件不再需要
+	if drop := r.heads[maxWindow]; drop > len(r.entries)/2 {
+		r.entries = append([]*entry(nil), r.entries[drop:]...)
+		for window := range r.heads {
+			r.heads[window] -= drop
+		}
+	}
+}
+
+// Collector 统计房间里的弹幕, 礼物和进房, 并发安全.
+type Collector struct {
+	// TopN 是排行榜的长度
+	TopN int
+
+	mutex  sync.Mutex
+	now    func() time.Time
+	lookup func(roomId int, giftId string) *gift.Gift
+	rooms  map[string]*room
+}
+
+// New 新建一个 Collector. lookup 用来计算没有合并的 dgb 礼物的价值, 可以为
+// nil; 已经由 gift.Combiner 合并的 gift.Combo 直接使用它的价值, 同一个礼物
+// 不要同时以两种形式加入.
+func New(lookup func(roomId int, giftId string) *gift.Gift) *Collector {
+	return &Collector{
+		TopN:   5,
+		now:    time.Now,
+		lookup: lookup,
+		rooms:  make(map[string]*room),
+	}
+}
+
+func (c *Collector) Add(ev danmuku.Event) {
+	e := &entry{}
+	switch ev := ev.(type) {
+	case *danmuku.ChatMessage:
+		e.kind, e.uid, e.name, e.tokens = kindChat, ev.Uid, ev.Nickname, Tokens(ev.Text)
+	case *danmuku.Gift:
+		e.kind, e.uid, e.name = kindGift, ev.Uid, ev.Nickname
+		if c.lookup != nil {
+			e.value = gift.Decode(ev, c.lookup).Value()
+		}
+	case *gift.Combo:
+		e.kind, e.uid, e.name, e.value = kindGift, ev.Uid, ev.Nickname, ev.Value()
+	case *danmuku.UserEnter:
+		e.kind, e.uid, e.name = kindEnter, ev.Uid, ev.Nickname
+	default:
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e.time = c.now()
+	r, ok := c.rooms[ev.Addr()]
+	if !ok {
+		r = newRoom(e.time)
+		c.rooms[ev.Addr()] = r
+	}
+	r.expire(e.time)
+	r.entries = append(r.entries, e)
+	r.session.add(e, 1)
+	for _, w := range r.windows {
+		w.add(e, 1)
+	}
+}
+
+// Snapshot 返回 addr 房间在 window 内的统计, window 是 Minute, TenMinutes
+// 或 Session. addr 是 Event.Addr 格式的房间地址.
+func (c *Collector) Snapshot(addr string, window time.Duration) Snapshot {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := Snapshot{Window: window}
+	r, ok := c.rooms[addr]
+	if !ok {
+		return s
+	}
+	now := c.now()
+	r.expire(now)
+
+	counter := r.session
+	minutes := now.Sub(r.start).Minutes()
+	if w, ok := r.windows[window]; ok {
+		counter = w
+		minutes = window.Minutes()
+	}
+	if minutes < 1 {
+		minutes = 1
+	}
+
+	s.Messages = counter.messages
+	s.PerMinute = float64(counter.messages) / minutes
+	s.Chatters = len(counter.chatters)
+	s.Enters = counter.enters
+	s.GiftValue = counter.value
+
+	chatters := make([]Count, 0, len(counter.chatters))
+	for uid, n := range counter.chatters {
+		chatters = append(chatters, Count{counter.name(uid), n})
+	}
+	s.TopChatters = topCounts(chatters, c.TopN)
+	tokens := make([]Count, 0, len(counter.tokens))
+	for token, n := range counter.tokens {
+		tokens = append(tokens, Count{token, n})
+	}
+	s.TopTokens = topCounts(tokens, c.TopN)
+	gifters := make([]Value, 0, len(counter.gifters))
+	for uid, value := range counter.gifters {
+		if value > 0 {
+			gifters = append(gifters, Value{counter.name(uid), value})
+		}
+	}
+	sort.Slice(gifters, func(i, j int) bool {
+		if gifters[i].Value == gifters[j].Value {
+			return gifters[i].Key < gifters[j].Key
+		}
+		return gifters[i].Value > gifters[j].Value
+	})
+	if len(gifters) > c.TopN {
+		gifters = gifters[:c.TopN]
+	}
+	s.TopGifters = gifters
+	return s
+}
+
+func topCounts(counts []Count, n int) []Count {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count == counts[j].Count {
+			return counts[i].Key < counts[j].Key
+		}
+		return counts[i].Count > counts[j].Count
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
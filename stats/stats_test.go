@@ -0,0 +1,98 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/gift"
+)
+
+func chat(uid int, nickname, text string) *danmuku.ChatMessage {
+	return &danmuku.ChatMessage{
+		Header:   danmuku.Header{Type: "chatmsg", RoomId: 1},
+		Uid:      uid,
+		Nickname: nickname,
+		Text:     text,
+	}
+}
+
+func TestTokens(t *testing.T) {
+	tokens := Tokens("主播 666[emot:dy101], Hello!hello")
+	want := []string{"[emot:dy101]", "主播", "666", "hello", "hello"}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Error("unexpected tokens", tokens)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	rocket := &gift.Gift{Id: "196", Name: "火箭", Type: gift.TypeYuchi, Price: 500}
+	c := New(func(roomId int, giftId string) *gift.Gift {
+		if giftId == rocket.Id {
+			return rocket
+		}
+		return nil
+	})
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	c.Add(chat(1, "a", "666"))
+	c.Add(&danmuku.UserEnter{Header: danmuku.Header{Type: "uenter", RoomId: 1}, Uid: 3})
+	now = now.Add(5 * time.Minute)
+	c.Add(chat(2, "b", "666 主播"))
+	c.Add(&danmuku.Gift{Header: danmuku.Header{Type: "dgb", RoomId: 1}, Uid: 2, Nickname: "b", GiftId: "196"})
+	now = now.Add(5*time.Minute + 30*time.Second)
+	c.Add(chat(2, "b", "hi"))
+	c.Add(&gift.Combo{Header: danmuku.Header{Type: "giftcombo", RoomId: 1}, Uid: 1, Nickname: "a",
+		Gift: rocket, Count: 2})
+	c.Add(&danmuku.StateChange{RoomId: 1})
+
+	minute := c.Snapshot("douyu:1", Minute)
+	if minute.Messages != 1 || minute.Chatters != 1 || minute.Enters != 0 || minute.GiftValue != 1000 ||
+		minute.PerMinute != 1 {
+		t.Errorf("unexpected minute stats %+v", minute)
+	}
+
+	ten := c.Snapshot("douyu:1", TenMinutes)
+	if ten.Messages != 2 || ten.Chatters != 1 || ten.GiftValue != 1500 {
+		t.Errorf("unexpected ten minute stats %+v", ten)
+	}
+	if !reflect.DeepEqual(ten.TopGifters, []Value{{"a", 1000}, {"b", 500}}) {
+		t.Error("unexpected top gifters", ten.TopGifters)
+	}
+
+	session := c.Snapshot("douyu:1", Session)
+	if session.Messages != 3 || session.Chatters != 2 || session.Enters != 1 || session.GiftValue != 1500 {
+		t.Errorf("unexpected session stats %+v", session)
+	}
+	if !reflect.DeepEqual(session.TopChatters, []Count{{"b", 2}, {"a", 1}}) {
+		t.Error("unexpected top chatters", session.TopChatters)
+	}
+	if !reflect.DeepEqual(session.TopTokens[0], Count{"666", 2}) {
+		t.Error("unexpected top tokens", session.TopTokens)
+	}
+
+	// 没有新事件时窗口也会滑动
+	now = now.Add(time.Hour)
+	if s := c.Snapshot("douyu:1", TenMinutes); s.Messages != 0 || s.GiftValue != 0 || len(s.TopGifters) != 0 {
+		t.Errorf("unexpected stats after an hour %+v", s)
+	}
+	for window, counter := range c.rooms["douyu:1"].windows {
+		if len(counter.names) != 0 || len(counter.chatters) != 0 || len(counter.gifters) != 0 {
+			t.Errorf("window %v keeps expired users: %v", window, counter.names)
+		}
+	}
+	if s := c.Snapshot("bilibili:1", Session); s.Messages != 0 {
+		t.Errorf("unexpected stats for unknown room %+v", s)
+	}
+
+	// 其它平台房间号相同的房间分开统计
+	c.Add(&danmuku.ChatMessage{Header: danmuku.Header{Type: "chatmsg", RoomId: 1, Scheme: "bilibili"}, Uid: 9, Nickname: "z", Text: "hi"})
+	if s := c.Snapshot("bilibili:1", Session); s.Messages != 1 || s.Chatters != 1 {
+		t.Errorf("unexpected bilibili stats %+v", s)
+	}
+	if s := c.Snapshot("douyu:1", Session); s.Messages != 3 {
+		t.Errorf("unexpected douyu stats %+v", s)
+	}
+}
@@ -0,0 +1,146 @@
+package theme
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/view"
+)
+
+// Config 是配置文件里的 theme 部分, 样式的写法见 view.ParseStyle:
+//
+//	"theme": {
+//		"monochrome": false,
+//		"colors": {"1": "red+bold"},
+//		"anchor": "black/yellow+bold"
+//	}
+//
+// colors 的键是弹幕的 col 字段, 没有写的样式使用默认值.
+type Config struct {
+	Monochrome bool              `json:"monochrome,omitempty"`
+	Colors     map[string]string `json:"colors,omitempty"`
+	Nickname   string            `json:"nickname,omitempty"`
+	Level      string            `json:"level,omitempty"`
+	Badge      string            `json:"badge,omitempty"`
+	Moderator  string            `json:"moderator,omitempty"`
+	Anchor     string            `json:"anchor,omitempty"`
+	Notice     string            `json:"notice,omitempty"`
+}
+
+type Theme struct {
+	// Monochrome 为 true 时终端只显示粗体, 下划线和反色
+	Monochrome bool
+
+	Colors    map[danmuku.Color]view.Style
+	Nickname  view.Style
+	Level     view.Style
+	Badge     view.Style
+	Moderator view.Style
+	Anchor    view.Style
+	Notice    view.Style
+}
+
+func Default() *Theme {
+	return &Theme{
+		Monochrome: os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb",
+
+		Colors: map[danmuku.Color]view.Style{
+			danmuku.ColorRed:    {Fg: view.ColorRed, Bold: true},
+			danmuku.ColorBlue:   {Fg: view.ColorBlue, Bold: true},
+			danmuku.ColorGreen:  {Fg: view.ColorGreen, Bold: true},
+			danmuku.ColorYellow: {Fg: view.ColorYellow, Bold: true},
+			danmuku.ColorPurple: {Fg: view.ColorMagenta, Bold: true},
+			danmuku.ColorPink:   {Fg: view.ColorMagenta},
+		},
+		Nickname:  view.Style{Fg: view.ColorCyan},
+		Level:     view.Style{Fg: view.ColorBlack, Bg: view.ColorCyan},
+		Badge:     view.Style{Fg: view.ColorBlack, Bg: view.ColorYellow},
+		Moderator: view.Style{Fg: view.ColorGreen, Bold: true, Underline: true},
+		Anchor:    view.Style{Fg: view.ColorRed, Bold: true, Reverse: true},
+		Notice:    view.Style{Fg: view.ColorYellow},
+	}
+}
+
+// New 在默认主题上应用 cfg
+func New(cfg Config) (*Theme, error) {
+	t := Default()
+	t.Monochrome = t.Monochrome || cfg.Monochrome
+	for col, s := range cfg.Colors {
+		n, err := strconv.Atoi(col)
+		if err != nil {
+			return nil, err
+		}
+		style, err := view.ParseStyle(s)
+		if err != nil {
+			return nil, err
+		}
+		t.Colors[danmuku.Color(n)] = style
+	}
+	for _, field := range []struct {
+		s     string
+		style *view.Style
+	}{
+		{cfg.Nickname, &t.Nickname},
+		{cfg.Level, &t.Level},
+		{cfg.Badge, &t.Badge},
+		{cfg.Moderator, &t.Moderator},
+		{cfg.Anchor, &t.Anchor},
+		{cfg.Notice, &t.Notice},
+	} {
+		if field.s == "" {
+			continue
+		}
+		style, err := view.ParseStyle(field.s)
+		if err != nil {
+			return nil, err
+		}
+		*field.style = style
+	}
+	return t, nil
+}
+
+// Load 读取配置文件里的 theme 部分, 没有时返回默认主题.
+func Load(path string) (*Theme, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := struct {
+		Theme Config `json:"theme"`
+	}{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return New(file.Theme)
+}
+
+// Chat 返回一条弹幕的显示样式: 等级, 粉丝牌, 身份, 昵称, 然后是按 col
+// 上色的 text. text 是已经翻译过表情的弹幕内容.
+func (t *Theme) Chat(ev *danmuku.ChatMessage, text string) view.Line {
+	line := make(view.Line, 0, 8)
+	if ev.Level > 0 {
+		line = append(line, view.Span{Text: "Lv" + strconv.Itoa(ev.Level), Style: t.Level}, view.Span{Text: " "})
+	}
+	if ev.BadgeName != "" {
+		line = append(line, view.Span{Text: ev.BadgeName + strconv.Itoa(ev.BadgeLevel), Style: t.Badge}, view.Span{Text: " "})
+	}
+	nickname := t.Nickname
+	switch ev.Role {
+	case danmuku.RoleAnchor:
+		line = append(line, view.Span{Text: "主播", Style: t.Anchor}, view.Span{Text: " "})
+		nickname = t.Anchor
+	case danmuku.RoleModerator:
+		line = append(line, view.Span{Text: "房管", Style: t.Moderator}, view.Span{Text: " "})
+		nickname = t.Moderator
+	}
+	line = append(line, view.Span{Text: ev.Nickname, Style: nickname}, view.Span{Text: ": "})
+	return append(line, view.Span{Text: text, Style: t.Colors[ev.Color]})
+}
+
+// NoticeLine 返回系统消息和礼物的显示样式
+func (t *Theme) NoticeLine(text string) view.Line {
+	return view.Line{{Text: text, Style: t.Notice}}
+}
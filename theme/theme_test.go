@@ -0,0 +1,57 @@
+package theme
+
+import (
+	"testing"
+
+	"github.com/zwh8800/Love66/danmuku"
+	"github.com/zwh8800/Love66/view"
+)
+
+func TestNew(t *testing.T) {
+	th, err := New(Config{
+		Monochrome: true,
+		Colors:     map[string]string{"1": "white/red"},
+		Anchor:     "bold",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !th.Monochrome || th.Colors[danmuku.ColorRed] != (view.Style{Fg: view.ColorWhite, Bg: view.ColorRed}) ||
+		th.Anchor != (view.Style{Bold: true}) || th.Colors[danmuku.ColorBlue].Fg != view.ColorBlue {
+		t.Errorf("unexpected theme %+v", th)
+	}
+
+	if _, err := New(Config{Colors: map[string]string{"x": "red"}}); err == nil {
+		t.Error("expected error for bad color key")
+	}
+	if _, err := New(Config{Level: "pink"}); err == nil {
+		t.Error("expected error for bad style")
+	}
+}
+
+func TestChat(t *testing.T) {
+	th := Default()
+	chat := &danmuku.ChatMessage{
+		Nickname:   "a",
+		Level:      12,
+		BadgeName:  "鱼丸",
+		BadgeLevel: 7,
+		Role:       danmuku.RoleModerator,
+		Color:      danmuku.ColorGreen,
+	}
+	line := th.Chat(chat, "666")
+	if line.String() != "Lv12 鱼丸7 房管 a: 666" {
+		t.Error("unexpected line", line.String())
+	}
+	last := line[len(line)-1]
+	if last.Style != th.Colors[danmuku.ColorGreen] {
+		t.Error("unexpected text style", last.Style)
+	}
+	if nick := line[len(line)-3]; nick.Text != "a" || nick.Style != th.Moderator {
+		t.Error("unexpected nickname span", nick)
+	}
+
+	if line := th.Chat(&danmuku.ChatMessage{Nickname: "b"}, "hi"); line.String() != "b: hi" {
+		t.Error("unexpected line", line.String())
+	}
+}
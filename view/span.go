@@ -0,0 +1,148 @@
+package view
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+type Color int
+
+const (
+	ColorDefault Color = iota
+	ColorBlack
+	ColorRed
+	ColorGreen
+	ColorYellow
+	ColorBlue
+	ColorMagenta
+	ColorCyan
+	ColorWhite
+)
+
+var colorNames = map[string]Color{
+	"default": ColorDefault,
+	"black":   ColorBlack,
+	"red":     ColorRed,
+	"green":   ColorGreen,
+	"yellow":  ColorYellow,
+	"blue":    ColorBlue,
+	"magenta": ColorMagenta,
+	"cyan":    ColorCyan,
+	"white":   ColorWhite,
+}
+
+var termboxColors = [...]termbox.Attribute{
+	ColorDefault: termbox.ColorDefault,
+	ColorBlack:   termbox.ColorBlack,
+	ColorRed:     termbox.ColorRed,
+	ColorGreen:   termbox.ColorGreen,
+	ColorYellow:  termbox.ColorYellow,
+	ColorBlue:    termbox.ColorBlue,
+	ColorMagenta: termbox.ColorMagenta,
+	ColorCyan:    termbox.ColorCyan,
+	ColorWhite:   termbox.ColorWhite,
+}
+
+type Style struct {
+	Fg        Color
+	Bg        Color
+	Bold      bool
+	Underline bool
+	Reverse   bool
+}
+
+// ParseStyle 解析 "red", "red+bold", "black/cyan+underline" 这样的样式,
+// 斜线后面是背景色. 空字符串是默认样式.
+func ParseStyle(s string) (Style, error) {
+	var style Style
+	if s == "" {
+		return style, nil
+	}
+	for i, part := range strings.Split(s, "+") {
+		part = strings.TrimSpace(strings.ToLower(part))
+		switch part {
+		case "bold":
+			style.Bold = true
+			continue
+		case "underline":
+			style.Underline = true
+			continue
+		case "reverse":
+			style.Reverse = true
+			continue
+		}
+		if i != 0 {
+			return style, errors.New("view: unknown attribute " + part)
+		}
+		colors := strings.SplitN(part, "/", 2)
+		fg, ok := colorNames[colors[0]]
+		if !ok {
+			return style, errors.New("view: unknown color " + colors[0])
+		}
+		style.Fg = fg
+		if len(colors) == 2 {
+			bg, ok := colorNames[colors[1]]
+			if !ok {
+				return style, errors.New("view: unknown color " + colors[1])
+			}
+			style.Bg = bg
+		}
+	}
+	return style, nil
+}
+
+// attributes 返回 termbox 的前景和背景, 单色模式下只保留粗体, 下划线和反色.
+func (s Style) attributes() (fg, bg termbox.Attribute) {
+	if !monochrome {
+		fg, bg = termboxColors[s.Fg], termboxColors[s.Bg]
+	}
+	if s.Bold {
+		fg |= termbox.AttrBold
+	}
+	if s.Underline {
+		fg |= termbox.AttrUnderline
+	}
+	if s.Reverse {
+		fg |= termbox.AttrReverse
+	}
+	return fg, bg
+}
+
+// Span 是一段样式相同的文字
+type Span struct {
+	Text  string
+	Style Style
+}
+
+// Line 是由多段文字组成的一行
+type Line []Span
+
+// Plain 返回默认样式的一行
+func Plain(text string) Line {
+	return Line{{Text: text}}
+}
+
+func (l Line) String() string {
+	s := ""
+	for _, span := range l {
+		s += span.Text
+	}
+	return s
+}
+
+func (l Line) displayLength() int {
+	length := 0
+	for _, span := range l {
+		length += displayLength(span.Text)
+	}
+	return length
+}
+
+var monochrome bool
+
+// SetMonochrome 打开后忽略所有颜色, 用于不支持颜色的终端
+func SetMonochrome(m bool) {
+	monochrome = m
+}
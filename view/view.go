@@ -12,7 +12,7 @@ type Handler func(args ...interface{})
 
 type Data struct {
 	LeftLines  []string
-	RightLines []string
+	RightLines []Line
 	Loading    bool
 }
 
@@ -20,6 +20,7 @@ var (
 	prev            Handler
 	next            Handler
 	quit            Handler
+	stats           Handler
 	lineCountChange Handler
 	mainLoopChannel chan bool
 	loadingChannel  chan bool = make(chan bool)
@@ -28,7 +29,8 @@ var (
 	w int
 	h int
 
-	data *Data
+	data       *Data
+	statsLines []string
 )
 
 func Init() error {
@@ -59,6 +61,11 @@ func SetData(d *Data) {
 	data = d
 }
 
+// SetStats 设置房间信息下面的统计面板, 为空时不显示
+func SetStats(lines []string) {
+	statsLines = lines
+}
+
 func findMaxLength(lines []string) int {
 	max := 0
 	for _, line := range lines {
@@ -83,19 +90,38 @@ func drawLeft() {
 		x = (width - maxLength) / 2
 	}
 	length := len(data.LeftLines)
-	y := (h - length) / 2
+	y := (h - length - len(statsLines)) / 2
+	if y < 0 {
+		y = 0
+	}
 
 	for i := 0; i < length; i++ {
 		y += tbPrint(x, y, width, termbox.ColorDefault, termbox.ColorDefault, data.LeftLines[i])
 	}
+	drawStats(y + 1)
+}
+
+func drawStats(y int) {
+	width := w/2 - 1
+	x := 0
+	if maxLength := findMaxLength(statsLines); maxLength < width {
+		x = (width - maxLength) / 2
+	}
+	for i := 0; i < len(statsLines) && y < h-1; i++ {
+		fg := termbox.ColorDefault
+		if i == 0 {
+			fg = termbox.ColorCyan
+		}
+		y += tbPrint(x, y, width, fg, termbox.ColorDefault, statsLines[i])
+	}
 }
 
-func calcLines(lines []string, width int) (int, []int) {
+func calcLines(lines []Line, width int) (int, []int) {
 	c := 0
 	arr := make([]int, 0)
 	for _, line := range lines {
-		c += len(line)/width + 1
-		arr = append(arr, len(line)/width+1)
+		c += line.displayLength()/width + 1
+		arr = append(arr, line.displayLength()/width+1)
 	}
 	return c, arr
 }
@@ -112,7 +138,7 @@ func drawRight() {
 		startLine++
 	}
 	for i := startLine; i < len(data.RightLines); i++ {
-		y += tbPrint(x, y, width, termbox.ColorDefault, termbox.ColorDefault, data.RightLines[i])
+		y += tbPrintLine(x, y, width, data.RightLines[i])
 	}
 }
 
@@ -142,6 +168,8 @@ var helpInfo = [...]string{
 	"上一房间",
 	"▶",
 	"下一房间",
+	"S",
+	"统计",
 	"ESC",
 	"退出",
 }
@@ -201,6 +229,10 @@ func OnKeyQuit(h Handler) {
 	quit = h
 }
 
+func OnKeyStats(h Handler) {
+	stats = h
+}
+
 func OnMaxLineCountChange(h Handler) {
 	lineCountChange = h
 }
@@ -223,6 +255,9 @@ func MainLoop() {
 			if ev.Ch == 'q' {
 				emit(quit)
 			}
+			if ev.Ch == 's' {
+				emit(stats)
+			}
 			switch ev.Key {
 			case termbox.KeyEsc:
 				emit(quit)
@@ -268,3 +303,25 @@ func tbPrint(x, y, w int, fg, bg termbox.Attribute, msg string) int {
 	}
 	return offset
 }
+
+func tbPrintLine(x, y, w int, line Line) int {
+	offset := 1
+	initX := x
+	for _, span := range line {
+		fg, bg := span.Style.attributes()
+		for _, c := range span.Text {
+			termbox.SetCell(x, y, c, fg, bg)
+			if isNoneLatinChar(c) {
+				x += 2
+			} else {
+				x++
+			}
+			if x-initX >= w {
+				x = initX
+				y++
+				offset++
+			}
+		}
+	}
+	return offset
+}
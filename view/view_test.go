@@ -18,8 +18,8 @@ func TestView(t *testing.T) {
 		[]string{
 			"hello world",
 		},
-		[]string{
-			"hello world",
+		[]Line{
+			Plain("hello world"),
 		},
 		false,
 	}
@@ -27,7 +27,10 @@ func TestView(t *testing.T) {
 	SetData(&data)
 	OnKeyNext(func(args ...interface{}) {
 		data.LeftLines[0] = "Next Press"
-		data.RightLines = append(data.RightLines, "Next Press")
+		data.RightLines = append(data.RightLines, Line{
+			{"Next", Style{Fg: ColorRed, Bold: true}},
+			{" Press", Style{}},
+		})
 		if len(data.RightLines) > maxLineCount {
 			data.RightLines =
 				data.RightLines[len(data.RightLines)-maxLineCount : len(data.RightLines)]
@@ -38,7 +41,7 @@ func TestView(t *testing.T) {
 		data.Loading = !data.Loading
 
 		data.LeftLines[0] = "Prev Press"
-		data.RightLines = append(data.RightLines, "Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press ")
+		data.RightLines = append(data.RightLines, Plain("Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press Prev Press "))
 		if len(data.RightLines) > maxLineCount {
 			data.RightLines =
 				data.RightLines[len(data.RightLines)-maxLineCount : len(data.RightLines)]
@@ -67,3 +70,30 @@ func TestView(t *testing.T) {
 	signal.Notify(c, os.Interrupt, os.Kill)
 	<-c
 }
+
+func TestParseStyle(t *testing.T) {
+	cases := []struct {
+		s     string
+		style Style
+		err   bool
+	}{
+		{"", Style{}, false},
+		{"red", Style{Fg: ColorRed}, false},
+		{"Red+Bold", Style{Fg: ColorRed, Bold: true}, false},
+		{"black/cyan+underline+reverse", Style{Fg: ColorBlack, Bg: ColorCyan, Underline: true, Reverse: true}, false},
+		{"bold", Style{Bold: true}, false},
+		{"pink", Style{}, true},
+		{"red+blue", Style{}, true},
+	}
+	for _, c := range cases {
+		style, err := ParseStyle(c.s)
+		if (err != nil) != c.err || (!c.err && style != c.style) {
+			t.Errorf("%q: got %+v, %v", c.s, style, err)
+		}
+	}
+
+	line := Line{{"a", Style{Fg: ColorRed}}, {"弹幕", Style{}}}
+	if line.String() != "a弹幕" || line.displayLength() != 5 {
+		t.Error("unexpected line", line.String(), line.displayLength())
+	}
+}